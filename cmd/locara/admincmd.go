@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/share"
+	"github.com/Firstbober/locara/internal/storage"
+	"github.com/Firstbober/locara/internal/templates"
+)
+
+// adminEnv is what an admin command runs with.
+type adminEnv struct {
+	cfg    *config.Config
+	stdout io.Writer
+	stderr io.Writer
+	json   bool
+}
+
+// print writes v as JSON when asked for, or else calls text.
+func (a *adminEnv) print(v any, text func(w io.Writer)) error {
+	if a.json {
+		return printJSON(a.stdout, v)
+	}
+	text(a.stdout)
+	return nil
+}
+
+// adminCommand is a maintenance subcommand working on the uploads directory
+// without the server.
+type adminCommand struct {
+	help string
+	// flags registers the flags of the command and returns the function
+	// running it, which returns the exit code.
+	flags func(fs *flag.FlagSet) func(a *adminEnv) (int, error)
+}
+
+var adminCommands = map[string]adminCommand{
+	"verify":   {"Check that every archive file exists and matches its size and checksum", verifyCommand},
+	"orphans":  {"List directories and files that belong to no archive", orphansCommand},
+	"gc":       {"Remove what orphans lists", gcCommand},
+	"stats":    {"Count archives and their size by type, uploader and year", statsCommand},
+	"renumber": {"Number the archives consecutively from 1, revoking share links to moved ones", renumberCommand},
+}
+
+// adminCommandNames lists the admin commands in the order of the usage.
+var adminCommandNames = []string{"verify", "orphans", "gc", "stats", "renumber"}
+
+func adminUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s admin <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range adminCommandNames {
+		fmt.Fprintf(w, "  %-10s %s\n", name, adminCommands[name].help)
+	}
+	fmt.Fprintf(w, "\nStop the server before running gc or renumber.\n")
+}
+
+// runAdminCommand runs a "locara admin" subcommand and returns the exit code.
+func runAdminCommand(args []string, path string, overrides map[string]string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		adminUsage(stderr)
+		return 2
+	}
+	cmd, ok := adminCommands[args[0]]
+	if !ok {
+		adminUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("admin "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	run := cmd.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s admin %s [flags]\n\n%s.\n\nFlags:\n", os.Args[0], args[0], cmd.help)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// Unlike the server the commands never create the uploads directory.
+	cfg, err := config.Read(path, overrides)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+
+	code, err := run(&adminEnv{cfg: cfg, stdout: stdout, stderr: stderr, json: *asJSON})
+	if err != nil {
+		fmt.Fprintf(stderr, "admin %s: %v\n", args[0], err)
+		return 1
+	}
+	return code
+}
+
+func printFindings(w io.Writer, findings []storage.Finding) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, f := range findings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Kind, f.Path, f.Detail)
+	}
+	tw.Flush()
+}
+
+func verifyCommand(fs *flag.FlagSet) func(*adminEnv) (int, error) {
+	quick := fs.Bool("quick", false, "Only check that files exist and have the recorded size, not their checksums")
+
+	return func(a *adminEnv) (int, error) {
+		checked, findings, err := storage.Verify(a.cfg.UseDirectory, !*quick)
+		if err != nil {
+			return 1, err
+		}
+
+		// Archives from before checksums were recorded cannot be checked,
+		// which is no fault of theirs.
+		problems := slices.ContainsFunc(findings, func(f storage.Finding) bool { return f.Kind != storage.KindNoChecksum })
+
+		err = a.print(struct {
+			Checked  int               `json:"checked"`
+			Findings []storage.Finding `json:"findings"`
+		}{checked, nonNil(findings)}, func(w io.Writer) {
+			printFindings(w, findings)
+			fmt.Fprintf(w, "Checked %d archive(s), %d finding(s)\n", checked, len(findings))
+		})
+		if problems {
+			return 1, err
+		}
+		return 0, err
+	}
+}
+
+func orphansCommand(fs *flag.FlagSet) func(*adminEnv) (int, error) {
+	return func(a *adminEnv) (int, error) {
+		findings, err := storage.FindOrphans(a.cfg.UseDirectory)
+		if err != nil {
+			return 1, err
+		}
+
+		return 0, a.print(struct {
+			Findings []storage.Finding `json:"findings"`
+		}{nonNil(findings)}, func(w io.Writer) {
+			printFindings(w, findings)
+			fmt.Fprintf(w, "%d orphan(s)\n", len(findings))
+		})
+	}
+}
+
+func gcCommand(fs *flag.FlagSet) func(*adminEnv) (int, error) {
+	dryRun := fs.Bool("dry-run", false, "Only show what would be removed")
+	unindexed := fs.Bool("unindexed", false, "Also remove archive directories without info.json, which may hold the only copy of a file")
+
+	return func(a *adminEnv) (int, error) {
+		findings, err := storage.FindOrphans(a.cfg.UseDirectory)
+		if err != nil {
+			return 1, err
+		}
+
+		var removed, kept []storage.Finding
+		code := 0
+		for _, f := range findings {
+			if f.Kind == storage.KindNoInfo && !*unindexed {
+				kept = append(kept, f)
+				continue
+			}
+			if !*dryRun {
+				if err := storage.RemoveOrphan(a.cfg.UseDirectory, f); err != nil {
+					fmt.Fprintf(a.stderr, "Failed to remove %s: %v\n", f.Path, err)
+					code = 1
+					continue
+				}
+			}
+			removed = append(removed, f)
+		}
+
+		return code, a.print(struct {
+			DryRun  bool              `json:"dry_run"`
+			Removed []storage.Finding `json:"removed"`
+			Kept    []storage.Finding `json:"kept"`
+		}{*dryRun, nonNil(removed), nonNil(kept)}, func(w io.Writer) {
+			verb := "Removed"
+			if *dryRun {
+				verb = "Would remove"
+			}
+			for _, f := range removed {
+				fmt.Fprintf(w, "%s %s (%s)\n", verb, f.Path, f.Kind)
+			}
+			for _, f := range kept {
+				fmt.Fprintf(w, "Kept %s (%s), use -unindexed to remove it\n", f.Path, f.Kind)
+			}
+			fmt.Fprintf(w, "%s %d orphan(s)\n", verb, len(removed))
+		})
+	}
+}
+
+func statsCommand(fs *flag.FlagSet) func(*adminEnv) (int, error) {
+	return func(a *adminEnv) (int, error) {
+		stats, err := storage.CollectStats(a.cfg.UseDirectory)
+		if err != nil {
+			return 1, err
+		}
+
+		return 0, a.print(stats, func(w io.Writer) {
+			fmt.Fprintf(w, "%d archive(s), %s\n", stats.Archives, templates.PrettyBytes(stats.Bytes))
+			if stats.FirstUpload != nil {
+				fmt.Fprintf(w, "Uploaded between %s and %s\n", stats.FirstUpload.Format("2006-01-02"), stats.LastUpload.Format("2006-01-02"))
+			}
+			for _, group := range []struct {
+				title   string
+				tallies map[string]*storage.Tally
+			}{
+				{"TYPE", stats.ByType},
+				{"UPLOADER", stats.ByUploader},
+				{"YEAR", stats.ByYear},
+			} {
+				fmt.Fprintln(w)
+				tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+				fmt.Fprintf(tw, "%s\tARCHIVES\tSIZE\n", group.title)
+				keys := make([]string, 0, len(group.tallies))
+				for key := range group.tallies {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					t := group.tallies[key]
+					fmt.Fprintf(tw, "%s\t%d\t%s\n", key, t.Archives, templates.PrettyBytes(t.Bytes))
+				}
+				tw.Flush()
+			}
+		})
+	}
+}
+
+func renumberCommand(fs *flag.FlagSet) func(*adminEnv) (int, error) {
+	dryRun := fs.Bool("dry-run", false, "Only show how archives would be renumbered")
+
+	return func(a *adminEnv) (int, error) {
+		moves, err := storage.PlanRenumber(a.cfg.UseDirectory)
+		if err != nil {
+			return 1, err
+		}
+
+		// Share links are signed for their archive ID, so links to moved
+		// archives cannot follow them. Without a secret no link was ever
+		// signed.
+		secret := []byte(a.cfg.SecretKey)
+		if len(secret) == 0 {
+			secret, err = storage.LoadSecret(a.cfg.UseDirectory)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return 1, err
+			}
+		}
+		var shares *share.Store
+		if len(secret) > 0 {
+			if shares, err = share.Open(a.cfg.UseDirectory, secret); err != nil {
+				return 1, fmt.Errorf("failed to open share link store: %w", err)
+			}
+		}
+		moved := make(map[int]bool)
+		for _, m := range moves {
+			if m.From != m.To {
+				moved[m.From] = true
+			}
+		}
+
+		revoked := 0
+		if *dryRun {
+			if shares != nil {
+				for _, link := range shares.List() {
+					if moved[link.ArchiveID] {
+						revoked++
+					}
+				}
+			}
+		} else {
+			if err := storage.Renumber(a.cfg.UseDirectory, moves); err != nil {
+				return 1, err
+			}
+			if shares != nil {
+				var errs []error
+				for id := range moved {
+					n, err := shares.RevokeArchive(id)
+					revoked += n
+					errs = append(errs, err)
+				}
+				if err := errors.Join(errs...); err != nil {
+					return 1, fmt.Errorf("archives renumbered, but failed to revoke their share links: %w", err)
+				}
+			}
+		}
+
+		return 0, a.print(struct {
+			DryRun            bool                  `json:"dry_run"`
+			Moves             []storage.Renumbering `json:"moves"`
+			RevokedShareLinks int                   `json:"revoked_share_links"`
+		}{*dryRun, nonNil(moves), revoked}, func(w io.Writer) {
+			verb, revokedVerb := "Renumbered", "Revoked"
+			if *dryRun {
+				verb, revokedVerb = "Would renumber", "Would revoke"
+			}
+			for _, m := range moves {
+				if m.From == m.To {
+					fmt.Fprintf(w, "%s #%d (fixing the ID in its info.json)\n", verb, m.From)
+				} else {
+					fmt.Fprintf(w, "%s #%d as #%d\n", verb, m.From, m.To)
+				}
+			}
+			fmt.Fprintf(w, "%s %d archive(s), %s %d share link(s)\n", verb, len(moves), revokedVerb, revoked)
+		})
+	}
+}
+
+// nonNil makes empty results print as [] rather than null in JSON.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
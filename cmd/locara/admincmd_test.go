@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firstbober/locara/internal/models"
+	"github.com/Firstbober/locara/internal/share"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+// writeOfflineConfig writes a minimal config file for the commands working on
+// the uploads directory, which is set through overrides.
+func writeOfflineConfig(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[[users]]\nname = \"tester\"\nauth = \""+testAuthCode+"\"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	return path
+}
+
+func TestAdminCommands(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeOfflineConfig(t)
+	overrides := map[string]string{"use_directory": dir, "secret_key": "admin-test-secret"}
+
+	run := func(wantCode int, args ...string) string {
+		t.Helper()
+
+		var stdout, stderr bytes.Buffer
+		if code := runAdminCommand(args, configPath, overrides, &stdout, &stderr); code != wantCode {
+			t.Fatalf("locara admin %s exited with %d, want %d; stderr: %s", strings.Join(args, " "), code, wantCode, stderr.String())
+		}
+		return stdout.String()
+	}
+
+	for _, name := range []string{"first", "second", "third"} {
+		header := &multipart.FileHeader{Filename: name + ".txt", Size: 7}
+		meta := &models.Archive{Name: name, FileName: header.Filename, SizeBytes: 7, DatedOn: "2024-01-01", Type: "document", Uploader: "tester"}
+		if err := storage.SaveArchive(context.Background(), dir, strings.NewReader("content"), header, meta); err != nil {
+			t.Fatalf("SaveArchive() failed: %v", err)
+		}
+	}
+
+	if out := run(0, "verify"); !strings.Contains(out, "Checked 3 archive(s), 0 finding(s)") {
+		t.Errorf("verify printed:\n%s", out)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2", "second.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	run(0, "verify", "-quick")
+	var verified struct {
+		Checked  int               `json:"checked"`
+		Findings []storage.Finding `json:"findings"`
+	}
+	if err := json.Unmarshal([]byte(run(1, "verify", "-json")), &verified); err != nil {
+		t.Fatalf("verify -json printed invalid JSON: %v", err)
+	}
+	if verified.Checked != 3 || len(verified.Findings) != 1 || verified.Findings[0].Kind != storage.KindChecksumMismatch {
+		t.Errorf("verify -json = %+v, want one checksum mismatch", verified)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, ".upload-left"), 0o755); err != nil {
+		t.Fatalf("Mkdir() failed: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "7"), 0o755); err != nil {
+		t.Fatalf("Mkdir() failed: %v", err)
+	}
+	if out := run(0, "orphans"); !strings.Contains(out, "2 orphan(s)") {
+		t.Errorf("orphans printed:\n%s", out)
+	}
+	run(0, "gc", "-dry-run")
+	if _, err := os.Stat(filepath.Join(dir, ".upload-left")); err != nil {
+		t.Errorf("gc -dry-run removed a staging directory: %v", err)
+	}
+	if out := run(0, "gc"); !strings.Contains(out, "Removed 1 orphan(s)") || !strings.Contains(out, "Kept 7") {
+		t.Errorf("gc printed:\n%s", out)
+	}
+	run(0, "gc", "-unindexed")
+	if out := run(0, "orphans", "-json"); strings.TrimSpace(out) != "{\n  \"findings\": []\n}" {
+		t.Errorf("orphans -json after gc printed:\n%s", out)
+	}
+
+	if out := run(0, "stats"); !strings.Contains(out, "3 archive(s), 21 B") {
+		t.Errorf("stats printed:\n%s", out)
+	}
+
+	if err := storage.DeleteArchive(dir, 1); err != nil {
+		t.Fatalf("DeleteArchive() failed: %v", err)
+	}
+	shares, err := share.Open(dir, []byte(overrides["secret_key"]))
+	if err != nil {
+		t.Fatalf("share.Open() failed: %v", err)
+	}
+	if _, err := shares.Create(3, "tester", time.Now().Add(time.Hour), 0, ""); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	if out := run(0, "renumber", "-dry-run"); !strings.Contains(out, "Would renumber #3 as #2") || !strings.Contains(out, "Would revoke 1 share link(s)") {
+		t.Errorf("renumber -dry-run printed:\n%s", out)
+	}
+	run(0, "renumber")
+	archives, err := storage.ListArchives(dir)
+	if err != nil || len(archives) != 2 {
+		t.Fatalf("ListArchives() after renumber = %v, %v", archives, err)
+	}
+	for i, a := range archives {
+		if a.ID != i+1 {
+			t.Errorf("archive %q has ID %d after renumber, want %d", a.Name, a.ID, i+1)
+		}
+	}
+	if shares, _ := share.Open(dir, []byte(overrides["secret_key"])); len(shares.List()) != 0 {
+		t.Errorf("renumber kept %d share link(s) to a moved archive", len(shares.List()))
+	}
+
+	run(2, "unknown")
+	run(2, "verify", "extra")
+}
+
+func TestAdminCommandsWriteNothing(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeOfflineConfig(t)
+
+	for _, name := range []string{"first", "second"} {
+		header := &multipart.FileHeader{Filename: name + ".txt", Size: 7}
+		meta := &models.Archive{Name: name, FileName: header.Filename, SizeBytes: 7, DatedOn: "2024-01-01", Type: "document", Uploader: "tester"}
+		if err := storage.SaveArchive(context.Background(), dir, strings.NewReader("content"), header, meta); err != nil {
+			t.Fatalf("SaveArchive() failed: %v", err)
+		}
+	}
+	if err := storage.DeleteArchive(dir, 1); err != nil {
+		t.Fatalf("DeleteArchive() failed: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".upload-left"), 0o755); err != nil {
+		t.Fatalf("Mkdir() failed: %v", err)
+	}
+
+	// tree lists every path below dir with its modification time.
+	tree := func() map[string]time.Time {
+		t.Helper()
+		paths := make(map[string]time.Time)
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			paths[path] = info.ModTime()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk() failed: %v", err)
+		}
+		return paths
+	}
+	before := tree()
+
+	overrides := map[string]string{"use_directory": dir}
+	for _, args := range [][]string{{"verify"}, {"orphans"}, {"stats"}, {"gc", "-dry-run"}, {"renumber", "-dry-run"}} {
+		var stdout, stderr bytes.Buffer
+		if code := runAdminCommand(args, configPath, overrides, &stdout, &stderr); code != 0 {
+			t.Fatalf("locara admin %s exited with %d; stderr: %s", strings.Join(args, " "), code, stderr.String())
+		}
+	}
+	after := tree()
+	if len(after) != len(before) {
+		t.Errorf("read-only commands changed the uploads directory from %v to %v", before, after)
+	}
+	for path, modTime := range before {
+		if !after[path].Equal(modTime) {
+			t.Errorf("read-only commands modified %s", path)
+		}
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	var stdout, stderr bytes.Buffer
+	if code := runAdminCommand([]string{"stats"}, configPath, map[string]string{"use_directory": missing}, &stdout, &stderr); code != 1 {
+		t.Errorf("locara admin stats on a missing directory exited with %d, want 1", code)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("locara admin stats created the uploads directory")
+	}
+}
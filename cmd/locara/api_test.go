@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+// apiSpec checks responses against the OpenAPI document served by the API.
+// It understands the subset of OpenAPI and JSON Schema the document uses.
+type apiSpec struct {
+	doc map[string]any
+}
+
+func loadAPISpec(t *testing.T, url string) *apiSpec {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	var doc map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("OpenAPI document is not JSON: %v", err)
+	}
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		t.Fatalf("openapi = %v, want 3.x", doc["openapi"])
+	}
+	return &apiSpec{doc: doc}
+}
+
+// object returns the object at key of m, following a $ref.
+func (s *apiSpec) object(m map[string]any, key string) map[string]any {
+	v, _ := m[key].(map[string]any)
+	return s.resolve(v)
+}
+
+func (s *apiSpec) resolve(m map[string]any) map[string]any {
+	ref, ok := m["$ref"].(string)
+	if !ok {
+		return m
+	}
+	var cur any = s.doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		obj, _ := cur.(map[string]any)
+		cur = obj[part]
+	}
+	resolved, _ := cur.(map[string]any)
+	return s.resolve(resolved)
+}
+
+// check validates the response to method on the path template against the
+// document and returns the decoded JSON body, if any.
+func (s *apiSpec) check(t *testing.T, method, path string, resp *http.Response) any {
+	t.Helper()
+	defer resp.Body.Close()
+
+	where := fmt.Sprintf("%s %s -> %d", method, path, resp.StatusCode)
+	op := s.object(s.object(s.object(s.doc, "paths"), path), strings.ToLower(method))
+	if op == nil {
+		t.Errorf("%s: operation not documented", where)
+		return nil
+	}
+	response := s.object(s.object(op, "responses"), strconv.Itoa(resp.StatusCode))
+	if response == nil {
+		t.Errorf("%s: status not documented", where)
+		return nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	content := s.object(response, "content")
+	if content == nil {
+		if len(body) != 0 {
+			t.Errorf("%s: undocumented body %q", where, body)
+		}
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media := s.object(content, mediaType)
+	if media == nil {
+		t.Errorf("%s: undocumented Content-Type %q", where, mediaType)
+		return nil
+	}
+	if mediaType != "application/json" {
+		return nil
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Errorf("%s: invalid JSON %q: %v", where, body, err)
+		return nil
+	}
+	for _, problem := range s.validate(s.object(media, "schema"), v, "body") {
+		t.Errorf("%s: %s", where, problem)
+	}
+	return v
+}
+
+// validate returns where v does not match the schema.
+func (s *apiSpec) validate(schema map[string]any, v any, at string) []string {
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("want object, got %T", v)
+			return problems
+		}
+		props := s.object(schema, "properties")
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		for name, value := range obj {
+			prop := s.object(props, name)
+			if prop == nil {
+				if schema["additionalProperties"] == false {
+					fail("undocumented property %q", name)
+				}
+				continue
+			}
+			problems = append(problems, s.validate(prop, value, at+"."+name)...)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			fail("want array, got %T", v)
+			return problems
+		}
+		for i, item := range items {
+			problems = append(problems, s.validate(s.object(schema, "items"), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("want string, got %T", v)
+			return problems
+		}
+		if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(str)) {
+			fail("%q is not one of %v", str, enum)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				fail("invalid date-time %q", str)
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			fail("want integer, got %v", v)
+		} else if min, ok := schema["minimum"].(float64); ok && n < min {
+			fail("%v is below the minimum %v", n, min)
+		}
+	}
+	return problems
+}
+
+func apiUpload(t *testing.T, url, token string, fields map[string]string, content string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if content != "" {
+		fw, _ := mw.CreateFormFile("file", "test.txt")
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAPIv1(t *testing.T) {
+	a := newTestApp(t, &config.Config{BaseUrl: "/locara"})
+	a.cfg.Users = append(a.cfg.Users,
+		config.User{Name: "other", Auth: "other-code", Role: config.RoleUploader},
+		config.User{Name: "boss", Auth: "admin-code", Role: config.RoleAdmin},
+	)
+	server := httptest.NewServer(a.handler())
+	t.Cleanup(server.Close)
+	api := server.URL + "/locara/api/v1"
+
+	spec := loadAPISpec(t, api+"/openapi.json")
+	servers, _ := spec.doc["servers"].([]any)
+	if len(servers) != 1 || servers[0].(map[string]any)["url"] != "/locara/api/v1" {
+		t.Errorf("servers = %v, want the base URL", servers)
+	}
+
+	do := func(method, path, template, token string, body io.Reader, contentType string) any {
+		t.Helper()
+
+		req, _ := http.NewRequest(method, api+path, body)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return spec.check(t, method, template, resp)
+	}
+	code := func(v any) any {
+		if m, ok := v.(map[string]any); ok {
+			return m["code"]
+		}
+		return nil
+	}
+
+	if got := do("GET", "/archives", "/archives", "", nil, ""); fmt.Sprint(got) != "[]" {
+		t.Errorf("empty list = %v, want []", got)
+	}
+
+	fields := map[string]string{"name": "Test", "dated_on": "2024-01-01", "type": "other", "author": "Author"}
+	uploads := []struct {
+		token   string
+		fields  map[string]string
+		content string
+		status  int
+	}{
+		{"", fields, "data", http.StatusUnauthorized},
+		{"wrong", fields, "data", http.StatusUnauthorized},
+		{testAuthCode, map[string]string{"name": "Test"}, "data", http.StatusBadRequest},
+		{testAuthCode, fields, "", http.StatusBadRequest},
+		{testAuthCode, fields, "archive content", http.StatusCreated},
+	}
+	for _, u := range uploads {
+		resp, err := http.DefaultClient.Do(apiUpload(t, api+"/archives", u.token, u.fields, u.content))
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if resp.StatusCode != u.status {
+			t.Errorf("upload with token %q = %d, want %d", u.token, resp.StatusCode, u.status)
+		}
+		if resp.StatusCode == http.StatusCreated && resp.Header.Get("Location") != "/locara/api/v1/archives/1" {
+			t.Errorf("Location = %q, want /locara/api/v1/archives/1", resp.Header.Get("Location"))
+		}
+		spec.check(t, "POST", "/archives", resp)
+	}
+
+	if got := do("GET", "/archives/1", "/archives/{id}", "", nil, ""); got.(map[string]any)["uploader"] != "tester" {
+		t.Errorf("archive = %v, want it uploaded by tester", got)
+	}
+	if got := do("GET", "/archives/9", "/archives/{id}", "", nil, ""); code(got) != "not_found" {
+		t.Errorf("missing archive = %v, want not_found", got)
+	}
+	if got := do("GET", "/archives/x", "/archives/{id}", "", nil, ""); code(got) != "invalid_id" {
+		t.Errorf("invalid ID = %v, want invalid_id", got)
+	}
+
+	patches := []struct {
+		token, contentType, body string
+		code                     any
+	}{
+		{"", "application/json", `{"name":"New"}`, "auth_required"},
+		{"other-code", "application/json", `{"name":"New"}`, "forbidden"},
+		{testAuthCode, "text/plain", `{"name":"New"}`, "unsupported_media_type"},
+		{testAuthCode, "application/json", `{"title":"New"}`, "invalid_request"},
+		{testAuthCode, "application/json", `{"name":" "}`, "invalid_request"},
+		{testAuthCode, "application/merge-patch+json", `{"name":"New \"name\""}`, nil},
+		{"admin-code", "application/json", `{"author":"Boss"}`, nil},
+	}
+	for _, p := range patches {
+		got := do("PATCH", "/archives/1", "/archives/{id}", p.token, strings.NewReader(p.body), p.contentType)
+		if code(got) != p.code {
+			t.Errorf("PATCH %s as %q = %v, want code %v", p.body, p.token, got, p.code)
+		}
+	}
+	if got := do("GET", "/archives/1", "/archives/{id}", "", nil, ""); got.(map[string]any)["name"] != `New "name"` || got.(map[string]any)["author"] != "Boss" {
+		t.Errorf("archive after PATCH = %v", got)
+	}
+
+	resp, err := http.Get(api + "/archives/1/file")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	spec.check(t, "GET", "/archives/{id}/file", resp)
+	if string(body) != "archive content" {
+		t.Errorf("file = %q, want %q", body, "archive content")
+	}
+
+	rangeReq, _ := http.NewRequest(http.MethodGet, api+"/archives/1/file", nil)
+	rangeReq.Header.Set("Range", "bytes=8-")
+	if resp, err = http.DefaultClient.Do(rangeReq); err != nil {
+		t.Fatalf("Range download failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != "bytes 8-14/15" {
+		t.Errorf("range download = %d %q, want 206 for bytes 8-14/15", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	spec.check(t, "GET", "/archives/{id}/file", resp)
+
+	searches := map[string]int{
+		"?type=OTHER&year=2024": 1,
+		"?q=boss":               1,
+		"?q=missing":            0,
+		"?year=2023":            0,
+	}
+	for query, want := range searches {
+		if got, _ := do("GET", "/archives"+query, "/archives", "", nil, "").([]any); len(got) != want {
+			t.Errorf("search %s found %d archives, want %d", query, len(got), want)
+		}
+	}
+	if got := do("GET", "/archives?year=24", "/archives", "", nil, ""); code(got) != "invalid_request" {
+		t.Errorf("search with invalid year = %v, want invalid_request", got)
+	}
+
+	shareReq, _ := http.NewRequest(http.MethodPost, server.URL+"/locara/api/archive/1/share", nil)
+	shareReq.Header.Set("Authorization", "Bearer "+testAuthCode)
+	if resp, err := http.DefaultClient.Do(shareReq); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Creating share link failed: %v %v", resp, err)
+	}
+
+	if got := do("DELETE", "/archives/1", "/archives/{id}", "other-code", nil, ""); code(got) != "forbidden" {
+		t.Errorf("DELETE by other uploader = %v, want forbidden", got)
+	}
+	// A directory in the way of the share store makes revoking fail.
+	blocker := filepath.Join(a.cfg.UseDirectory, ".shares.json.tmp")
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("Mkdir() failed: %v", err)
+	}
+	if got := do("DELETE", "/archives/1", "/archives/{id}", "admin-code", nil, ""); code(got) != "internal_error" {
+		t.Errorf("DELETE with failing revocation = %v, want internal_error", got)
+	}
+	if _, err := storage.GetArchive(a.cfg.UseDirectory, 1); err != nil || len(a.shares.List()) != 1 {
+		t.Errorf("archive and share links after failed DELETE = %v, %v, want both kept", err, a.shares.List())
+	}
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	do("DELETE", "/archives/1", "/archives/{id}", "admin-code", nil, "")
+	if got := do("DELETE", "/archives/1", "/archives/{id}", "admin-code", nil, ""); code(got) != "not_found" {
+		t.Errorf("second DELETE = %v, want not_found", got)
+	}
+	if links := a.shares.List(); len(links) != 0 {
+		t.Errorf("share links of deleted archive = %v, want none", links)
+	}
+	if got := do("GET", "/archives/1/file", "/archives/{id}/file", "", nil, ""); code(got) != "not_found" {
+		t.Errorf("file of deleted archive = %v, want not_found", got)
+	}
+
+	resp, err = http.Get(server.URL + "/locara/api/v1/nope")
+	if err != nil {
+		t.Fatalf("GET unknown route failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unknown API route = %d %s, want a JSON 404", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestLegacyAPIDeprecated(t *testing.T) {
+	server := newTestServer(t, "/locara")
+
+	resp, err := noRedirectClient().Get(server.URL + "/locara/api/archive/3")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Deprecation"), "@") {
+		t.Errorf("Deprecation = %q, want a date", resp.Header.Get("Deprecation"))
+	}
+	if want := `</locara/api/v1/archives/3/file>; rel="successor-version"`; resp.Header.Get("Link") != want {
+		t.Errorf("Link = %q, want %q", resp.Header.Get("Link"), want)
+	}
+}
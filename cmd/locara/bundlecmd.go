@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/Firstbober/locara/internal/bundle"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/templates"
+)
+
+// runExportCommand runs "locara export", which writes every archive to a
+// bundle, and returns the exit code.
+func runExportCommand(args []string, path string, overrides map[string]string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	quiet := fs.Bool("quiet", false, "Only print errors")
+	asJSON := fs.Bool("json", false, "Print the manifest as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s export [flags] <bundle>\n\n", os.Args[0])
+		fmt.Fprintf(stderr, "Write every archive with its metadata and a manifest of checksums to a\n")
+		fmt.Fprintf(stderr, "bundle: a .tar, .tar.gz or .tgz file, - for a tar on standard output, or\n")
+		fmt.Fprintf(stderr, "otherwise a new or empty directory.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+
+	// With the bundle on standard output, messages go to standard error.
+	out := stdout
+	if name == "-" {
+		out = stderr
+	}
+
+	cfg, err := config.LoadWithOverrides(path, overrides)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	w, err := bundle.Create(name, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "export: %v\n", err)
+		return 1
+	}
+	var total int64
+	m, err := bundle.Export(ctx, cfg.UseDirectory, w, func(e bundle.Entry) {
+		total += e.Size
+		if !*quiet && !*asJSON {
+			fmt.Fprintf(out, "Exported archive #%d (%s)\n", e.ID, templates.PrettyBytes(e.Size))
+		}
+	})
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "export: %v\n", err)
+		if name != "-" {
+			// A partial bundle must not pass for a backup.
+			os.RemoveAll(name)
+		}
+		return 1
+	}
+
+	if *asJSON {
+		if err := printJSON(out, m); err != nil {
+			fmt.Fprintf(stderr, "export: %v\n", err)
+			return 1
+		}
+	} else if !*quiet {
+		fmt.Fprintf(out, "Exported %d archive(s), %s\n", len(m.Archives), templates.PrettyBytes(total))
+	}
+	return 0
+}
+
+// runRestoreCommand runs "locara restore", which saves the archives of a
+// bundle to the uploads directory, and returns the exit code.
+func runRestoreCommand(args []string, path string, overrides map[string]string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts bundle.RestoreOptions
+	fs.BoolVar(&opts.Remap, "remap", false, "Give the archives new IDs following the existing ones instead of keeping theirs")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Only verify the bundle and show what would be restored")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s restore [flags] <bundle>\n\n", os.Args[0])
+		fmt.Fprintf(stderr, "Restore the archives of a bundle written by export, - reading it from\n")
+		fmt.Fprintf(stderr, "standard input. Every file is checked against the manifest. Archives whose\n")
+		fmt.Fprintf(stderr, "ID is taken by another one are reported as conflicts and skipped.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadWithOverrides(path, overrides)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+
+	r, err := bundle.Open(fs.Arg(0), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "restore: %v\n", err)
+		return 1
+	}
+	defer r.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	verbs := map[string]string{
+		bundle.StatusRestored: "Restored",
+		bundle.StatusPresent:  "Already present",
+		bundle.StatusConflict: "Conflict for",
+		bundle.StatusFailed:   "Failed to restore",
+	}
+	if opts.DryRun {
+		verbs[bundle.StatusRestored] = "Would restore"
+	}
+
+	results := []bundle.Result{}
+	counts := make(map[string]int)
+	err = bundle.Restore(ctx, cfg.UseDirectory, r, opts, func(res bundle.Result) {
+		results = append(results, res)
+		counts[res.Status]++
+		if *asJSON {
+			return
+		}
+		line := fmt.Sprintf("%s archive #%d", verbs[res.Status], res.ID)
+		if res.NewID != 0 && res.NewID != res.ID {
+			line += fmt.Sprintf(" as #%d", res.NewID)
+		}
+		if res.Detail != "" {
+			line += ": " + res.Detail
+		}
+		fmt.Fprintln(stdout, line)
+	})
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(stderr, "restore: interrupted, run it again to continue\n")
+	} else if err != nil {
+		fmt.Fprintf(stderr, "restore: %v\n", err)
+	}
+
+	if *asJSON {
+		if err := printJSON(stdout, struct {
+			DryRun  bool            `json:"dry_run"`
+			Results []bundle.Result `json:"results"`
+		}{opts.DryRun, results}); err != nil {
+			fmt.Fprintf(stderr, "restore: %v\n", err)
+			return 1
+		}
+	} else {
+		fmt.Fprintf(stdout, "%s %d archive(s), %d already present, %d conflict(s), %d failed\n", verbs[bundle.StatusRestored],
+			counts[bundle.StatusRestored], counts[bundle.StatusPresent], counts[bundle.StatusConflict], counts[bundle.StatusFailed])
+	}
+
+	if err != nil || counts[bundle.StatusConflict]+counts[bundle.StatusFailed] > 0 {
+		return 1
+	}
+	return 0
+}
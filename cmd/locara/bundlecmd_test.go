@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/models"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+func TestExportAndRestoreCommands(t *testing.T) {
+	configPath := writeOfflineConfig(t)
+	src, dst := t.TempDir(), t.TempDir()
+
+	for _, name := range []string{"first", "second"} {
+		header := &multipart.FileHeader{Filename: name + ".txt", Size: 7}
+		meta := &models.Archive{Name: name, FileName: header.Filename, SizeBytes: 7, DatedOn: "2024"}
+		if err := storage.SaveArchive(context.Background(), src, strings.NewReader(name+"!"), header, meta); err != nil {
+			t.Fatalf("SaveArchive() failed: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runExportCommand([]string{"-"}, configPath, map[string]string{"use_directory": src}, &stdout, &stderr); code != 0 {
+		t.Fatalf("locara export - exited with %d; stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Exported 2 archive(s)") {
+		t.Errorf("export - printed to stderr:\n%s", stderr.String())
+	}
+	tarball := stdout.Bytes()
+
+	restore := func(wantCode int, args ...string) string {
+		t.Helper()
+
+		var stdout, stderr bytes.Buffer
+		if code := runRestoreCommand(args, configPath, map[string]string{"use_directory": dst}, bytes.NewReader(tarball), &stdout, &stderr); code != wantCode {
+			t.Fatalf("locara restore %s exited with %d, want %d; stderr: %s", strings.Join(args, " "), code, wantCode, stderr.String())
+		}
+		return stdout.String()
+	}
+
+	if out := restore(0, "-dry-run", "-"); !strings.Contains(out, "Would restore 2 archive(s)") {
+		t.Errorf("restore -dry-run printed:\n%s", out)
+	}
+	if out := restore(0, "-"); !strings.Contains(out, "Restored archive #2\n") {
+		t.Errorf("restore printed:\n%s", out)
+	}
+	if out := restore(0, "-json", "-"); strings.Count(out, `"status": "present"`) != 2 {
+		t.Errorf("restore -json printed:\n%s", out)
+	}
+
+	if err := storage.DeleteArchive(dst, 1); err != nil {
+		t.Fatalf("DeleteArchive() failed: %v", err)
+	}
+	header := &multipart.FileHeader{Filename: "other.txt", Size: 6}
+	if err := storage.SaveArchive(context.Background(), dst, strings.NewReader("other!"), header, &models.Archive{FileName: "other.txt"}); err != nil {
+		t.Fatalf("SaveArchive() failed: %v", err)
+	}
+	if err := os.Rename(filepath.Join(dst, "3"), filepath.Join(dst, "1")); err != nil {
+		t.Fatalf("Rename() failed: %v", err)
+	}
+	if out := restore(1, "-"); !strings.Contains(out, "Conflict for archive #1") {
+		t.Errorf("restore onto a taken ID printed:\n%s", out)
+	}
+	if out := restore(0, "-remap", "-"); !strings.Contains(out, "Restored archive #1 as #3") {
+		t.Errorf("restore -remap printed:\n%s", out)
+	}
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	stdout.Reset()
+	if code := runExportCommand([]string{"-quiet", dir}, configPath, map[string]string{"use_directory": src}, &stdout, &stderr); code != 0 || stdout.Len() != 0 {
+		t.Errorf("locara export -quiet exited with %d, printed %q", code, stdout.String())
+	}
+	if code := runExportCommand([]string{dir}, configPath, map[string]string{"use_directory": src}, &stdout, &stderr); code != 1 {
+		t.Errorf("locara export to a full directory exited with %d, want 1", code)
+	}
+}
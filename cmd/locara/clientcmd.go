@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/Firstbober/locara/internal/templates"
+	"github.com/Firstbober/locara/pkg/client"
+)
+
+// Client commands read the server URL and token from a TOML file in the
+// user's config directory, overridden by these environment variables and
+// then by the -url and -token flags.
+const (
+	clientConfigEnv = "LOCARA_CLIENT_CONFIG"
+	clientURLEnv    = "LOCARA_URL"
+	clientTokenEnv  = "LOCARA_TOKEN"
+)
+
+// clientSettings is the client config file.
+type clientSettings struct {
+	// URL is the server URL including its base_url, e.g.
+	// "https://example.com/locara".
+	URL string `toml:"url"`
+	// Token is the auth code of the user.
+	Token string `toml:"token"`
+}
+
+// clientEnv is what a client command runs with.
+type clientEnv struct {
+	client *client.Client
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// progressInterval is how often transfer progress is shown.
+const progressInterval = 100 * time.Millisecond
+
+// errUsage makes a client command print its usage.
+var errUsage = errors.New("invalid arguments")
+
+// clientCommand is a subcommand talking to a running server.
+type clientCommand struct {
+	args string // positional arguments, for the usage line
+	help string
+	// flags registers the flags of the command and returns the function
+	// running it with the remaining arguments.
+	flags func(fs *flag.FlagSet) func(ctx context.Context, env *clientEnv, args []string) error
+}
+
+var clientCommands = map[string]clientCommand{
+	"upload": {"<file>", "Upload a file as a new archive", uploadCommand},
+	"list":   {"", "List archives, optionally narrowed down", listCommand},
+	"get":    {"<id>", "Download the file of an archive, resuming a partial download", getCommand},
+	"info":   {"<id>", "Show the metadata of an archive", infoCommand},
+	"delete": {"<id>", "Delete an archive", deleteCommand},
+}
+
+// clientCommandNames lists the client commands in the order of the usage.
+var clientCommandNames = []string{"upload", "list", "get", "info", "delete"}
+
+// runClientCommand runs the named client command and returns the exit code.
+func runClientCommand(name string, args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	cmd := clientCommands[name]
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	url := fs.String("url", "", "Server URL including base_url (env "+clientURLEnv+")")
+	token := fs.String("token", "", "Auth code (env "+clientTokenEnv+")")
+	run := cmd.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s %s [flags] %s\n\n%s.\n\nFlags:\n", os.Args[0], name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	settings, err := loadClientSettings(getenv)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load client config: %v\n", err)
+		return 1
+	}
+	if *url != "" {
+		settings.URL = *url
+	}
+	if *token != "" {
+		settings.Token = *token
+	}
+	if settings.URL == "" {
+		fmt.Fprintf(stderr, "No server URL: set url in %s, %s or -url\n", clientConfigPath(getenv), clientURLEnv)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	env := &clientEnv{client: client.New(settings.URL, settings.Token), stdout: stdout, stderr: stderr}
+	if err := run(ctx, env, fs.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// clientConfigPath returns where the client config file is read from.
+func clientConfigPath(getenv func(string) string) string {
+	if path := getenv(clientConfigEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "locara", "client.toml")
+}
+
+// loadClientSettings reads the client config file, which may be missing,
+// and applies the environment.
+func loadClientSettings(getenv func(string) string) (clientSettings, error) {
+	var settings clientSettings
+	if path := clientConfigPath(getenv); path != "" {
+		if _, err := toml.DecodeFile(path, &settings); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return settings, err
+		}
+	}
+	if v := getenv(clientURLEnv); v != "" {
+		settings.URL = v
+	}
+	if v := getenv(clientTokenEnv); v != "" {
+		settings.Token = v
+	}
+	return settings, nil
+}
+
+func uploadCommand(fs *flag.FlagSet) func(context.Context, *clientEnv, []string) error {
+	var u client.Upload
+	fs.StringVar(&u.Name, "name", "", "Name of the archive (required)")
+	fs.StringVar(&u.DatedOn, "dated", "", "Date of the archived material, e.g. 2003-07-01 (required)")
+	fs.StringVar(&u.Type, "type", "", "Type of the archive, e.g. video (required)")
+	fs.StringVar(&u.Author, "author", "", "Author of the archived material (required)")
+	fs.StringVar(&u.FileName, "filename", "", "Name to store the file under instead of its own")
+	quiet := fs.Bool("quiet", false, "Do not show progress")
+	asJSON := fs.Bool("json", false, "Print the metadata of the new archive as JSON")
+
+	return func(ctx context.Context, env *clientEnv, args []string) error {
+		if len(args) != 1 || u.Name == "" || u.DatedOn == "" || u.Type == "" || u.Author == "" {
+			return errUsage
+		}
+		if !*quiet {
+			u.Progress = progressPrinter(env.stderr)
+		}
+
+		archive, err := env.client.UploadFile(ctx, args[0], u)
+		if u.Progress != nil {
+			fmt.Fprintln(env.stderr)
+		}
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(env.stdout, archive)
+		}
+		fmt.Fprintf(env.stdout, "Uploaded %s as archive #%d\n", archive.FileName, archive.ID)
+		return nil
+	}
+}
+
+func listCommand(fs *flag.FlagSet) func(context.Context, *clientEnv, []string) error {
+	var q client.Query
+	fs.StringVar(&q.Text, "q", "", "Text in the name, author, type or file name")
+	fs.StringVar(&q.Type, "type", "", "Only archives of this type")
+	fs.StringVar(&q.Author, "author", "", "Only archives by this author")
+	fs.StringVar(&q.Uploader, "uploader", "", "Only archives uploaded by this user")
+	fs.IntVar(&q.Year, "year", 0, "Only archives dated in this year")
+	asJSON := fs.Bool("json", false, "Print the archives as JSON")
+
+	return func(ctx context.Context, env *clientEnv, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+
+		archives, err := env.client.Search(ctx, q)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(env.stdout, archives)
+		}
+
+		tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tDATED\tTYPE\tSIZE\tAUTHOR\tNAME")
+		for _, a := range archives {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", a.ID, a.DatedOn, a.Type, templates.PrettyBytes(a.SizeBytes), a.Author, a.Name)
+		}
+		return tw.Flush()
+	}
+}
+
+func getCommand(fs *flag.FlagSet) func(context.Context, *clientEnv, []string) error {
+	output := fs.String("o", "", "File to save to, - for standard output (default: the archive's file name)")
+	quiet := fs.Bool("quiet", false, "Do not show progress")
+	force := fs.Bool("f", false, "Replace the file if it exists")
+
+	return func(ctx context.Context, env *clientEnv, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		id, err := parseArchiveID(args[0])
+		if err != nil {
+			return err
+		}
+
+		if *output == "-" {
+			_, err := env.client.Download(ctx, id, env.stdout, 0, nil)
+			return err
+		}
+
+		path := *output
+		if path == "" {
+			archive, err := env.client.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+			// Never write outside the working directory because of a
+			// file name chosen by the uploader.
+			path = filepath.Base(archive.FileName)
+			if path == "." || path == ".." || path == string(filepath.Separator) {
+				path = "archive-" + strconv.Itoa(id)
+			}
+		}
+
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists, use -f to replace it", path)
+		}
+
+		var progress client.ProgressFunc
+		if !*quiet {
+			progress = progressPrinter(env.stderr)
+		}
+		archive, err := env.client.DownloadFile(ctx, id, path, progress)
+		if progress != nil {
+			fmt.Fprintln(env.stderr)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.stdout, "Saved archive #%d as %s\n", archive.ID, path)
+		return nil
+	}
+}
+
+func infoCommand(fs *flag.FlagSet) func(context.Context, *clientEnv, []string) error {
+	asJSON := fs.Bool("json", false, "Print the metadata as JSON")
+
+	return func(ctx context.Context, env *clientEnv, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		id, err := parseArchiveID(args[0])
+		if err != nil {
+			return err
+		}
+
+		a, err := env.client.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(env.stdout, a)
+		}
+
+		tw := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+		for _, row := range [][2]string{
+			{"ID", strconv.Itoa(a.ID)},
+			{"Name", a.Name},
+			{"Dated", a.DatedOn},
+			{"Type", a.Type},
+			{"Author", a.Author},
+			{"File", a.FileName},
+			{"Size", fmt.Sprintf("%s (%d bytes)", templates.PrettyBytes(a.SizeBytes), a.SizeBytes)},
+			{"MD5", a.MD5Sum},
+			{"Uploaded", a.UploadedOn.Local().Format("2006-01-02 15:04:05")},
+			{"Uploader", a.Uploader},
+		} {
+			fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+		}
+		return tw.Flush()
+	}
+}
+
+func deleteCommand(fs *flag.FlagSet) func(context.Context, *clientEnv, []string) error {
+	return func(ctx context.Context, env *clientEnv, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		id, err := parseArchiveID(args[0])
+		if err != nil {
+			return err
+		}
+
+		if err := env.client.Delete(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(env.stdout, "Deleted archive #%d\n", id)
+		return nil
+	}
+}
+
+func parseArchiveID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid archive ID %q", s)
+	}
+	return id, nil
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// progressPrinter returns a progress callback rewriting one line of w, at
+// most every progressInterval.
+func progressPrinter(w io.Writer) client.ProgressFunc {
+	var last time.Time
+	return func(done, total int64) {
+		if time.Since(last) < progressInterval && done != total {
+			return
+		}
+		last = time.Now()
+
+		if total < 0 {
+			fmt.Fprintf(w, "\r%s", templates.PrettyBytes(done))
+			return
+		}
+		percent := 100
+		if total > 0 {
+			percent = int(done * 100 / total)
+		}
+		fmt.Fprintf(w, "\r%s / %s (%d%%)", templates.PrettyBytes(done), templates.PrettyBytes(total), percent)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientCommands(t *testing.T) {
+	server := newTestServer(t, "/locara")
+	dir := t.TempDir()
+
+	// The config file names a wrong server, overridden by the environment.
+	configPath := filepath.Join(dir, "client.toml")
+	if err := os.WriteFile(configPath, []byte("url = \"http://127.0.0.1:1\"\ntoken = \""+testAuthCode+"\"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	env := map[string]string{
+		clientConfigEnv: configPath,
+		clientURLEnv:    server.URL + "/locara",
+	}
+
+	run := func(wantCode int, args ...string) string {
+		t.Helper()
+
+		var stdout, stderr bytes.Buffer
+		if code := runClientCommand(args[0], args[1:], &stdout, &stderr, func(k string) string { return env[k] }); code != wantCode {
+			t.Fatalf("locara %s exited with %d, want %d; stderr: %s", strings.Join(args, " "), code, wantCode, stderr.String())
+		}
+		return stdout.String()
+	}
+
+	file := filepath.Join(dir, "tape.mp4")
+	if err := os.WriteFile(file, []byte("video data"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if out := run(0, "upload", "-quiet", "--name", "Summer Tape", "--dated", "2003-07-01", "--type", "video", "--author", "Jan", file); out != "Uploaded tape.mp4 as archive #1\n" {
+		t.Errorf("upload printed %q", out)
+	}
+	run(0, "upload", "-quiet", "-name", "Minutes", "-dated", "2010-02-01", "-type", "document", "-author", "Jan", file)
+	run(2, "upload", "-name", "No date", file)
+
+	out := run(0, "list", "--type", "video", "--year", "2003")
+	if !strings.Contains(out, "Summer Tape") || strings.Contains(out, "Minutes") {
+		t.Errorf("list --type video --year 2003 printed:\n%s", out)
+	}
+	if out := run(0, "list"); strings.Count(out, "\n") != 3 {
+		t.Errorf("list printed:\n%s", out)
+	}
+
+	if out := run(0, "info", "2"); !strings.Contains(out, "Name:") || !strings.Contains(out, "Minutes") {
+		t.Errorf("info 2 printed:\n%s", out)
+	}
+	if out := run(0, "info", "-json", "1"); !strings.Contains(out, `"name": "Summer Tape"`) {
+		t.Errorf("info -json 1 printed:\n%s", out)
+	}
+
+	saved := filepath.Join(dir, "saved.mp4")
+	if err := os.WriteFile(saved, []byte("keep"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	run(1, "get", "-quiet", "-o", saved, "1")
+	if data, _ := os.ReadFile(saved); string(data) != "keep" {
+		t.Errorf("get without -f replaced the existing file with %q", data)
+	}
+	run(0, "get", "-quiet", "-f", "-o", saved, "1")
+	if data, err := os.ReadFile(saved); err != nil || string(data) != "video data" {
+		t.Errorf("get saved %q, %v, want %q", data, err, "video data")
+	}
+	if out := run(0, "get", "-o", "-", "1"); out != "video data" {
+		t.Errorf("get -o - printed %q", out)
+	}
+
+	run(1, "delete", "-token", "wrong", "1")
+	if out := run(0, "delete", "1"); out != "Deleted archive #1\n" {
+		t.Errorf("delete printed %q", out)
+	}
+	run(1, "info", "1")
+	run(2, "get", "abc", "def")
+
+	delete(env, clientURLEnv)
+	env[clientConfigEnv] = filepath.Join(dir, "missing.toml")
+	run(2, "list")
+}
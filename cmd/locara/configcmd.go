@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/templates"
+)
+
+// configPathEnv names the environment variable holding the config file path.
+const configPathEnv = "LOCARA_CONFIG"
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+	fmt.Fprintf(out, "Commands:\n")
+	fmt.Fprintf(out, "  config print    Print the effective configuration with secrets redacted\n")
+	fmt.Fprintf(out, "  config check    Report every problem with the configuration and exit non-zero on errors\n")
+	fmt.Fprintf(out, "  admin <command> Maintain the uploads directory offline (see %s admin)\n", os.Args[0])
+	fmt.Fprintf(out, "  import <dir>    Create archives from the files below a directory (see %s import -h)\n", os.Args[0])
+	fmt.Fprintf(out, "  export          Write every archive to a backup bundle (see %s export -h)\n", os.Args[0])
+	fmt.Fprintf(out, "  restore         Restore the archives of a bundle written by export\n\n")
+	fmt.Fprintf(out, "Client commands, talking to a running server (see %s <command> -h):\n", os.Args[0])
+	for _, name := range clientCommandNames {
+		cmd := clientCommands[name]
+		fmt.Fprintf(out, "  %-15s %s\n", name, cmd.help)
+	}
+	fmt.Fprintf(out, "The server URL and token are read from %s,\n", clientConfigPath(os.Getenv))
+	fmt.Fprintf(out, "%s and %s.\n\n", clientURLEnv, clientTokenEnv)
+	fmt.Fprintf(out, "Every config key can be set with a flag named like the key (e.g.\n")
+	fmt.Fprintf(out, "-oidc.client_id) or a %s* environment variable (e.g. %s).\n", config.EnvPrefix, config.EnvName("oidc.client_id"))
+	fmt.Fprintf(out, "Flags override the environment, which overrides the config file.\n\n")
+	fmt.Fprintf(out, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// registerConfigFlags adds a flag for every config key to fs and returns the
+// overrides collected from the flags that were set.
+func registerConfigFlags(fs *flag.FlagSet) map[string]string {
+	overrides := make(map[string]string)
+
+	set := func(name string) func(string) error {
+		return func(value string) error {
+			overrides[name] = value
+			return nil
+		}
+	}
+
+	for _, key := range config.Keys() {
+		usage := fmt.Sprintf("Override %s (env %s)", key.Name, config.EnvName(key.Name))
+		if key.Type.Kind() == reflect.Bool {
+			fs.BoolFunc(key.Name, usage, set(key.Name))
+		} else {
+			fs.Func(key.Name, usage, set(key.Name))
+		}
+
+		if key.Secret {
+			name := key.Name + "_file"
+			fs.Func(name, fmt.Sprintf("Read %s from a file (env %s)", key.Name, config.EnvName(name)), set(name))
+		}
+	}
+
+	return overrides
+}
+
+// runConfigCommand runs a "locara config" subcommand and returns the exit code.
+func runConfigCommand(args []string, path string, overrides map[string]string) int {
+	switch {
+	case len(args) == 1 && args[0] == "print":
+		return printConfig(path, overrides)
+	case len(args) == 1 && args[0] == "check":
+		return checkConfig(path, overrides)
+	default:
+		fmt.Fprintf(os.Stderr, "usage: %s config print|check\n", os.Args[0])
+		return 2
+	}
+}
+
+// printConfig prints the effective configuration with secrets redacted,
+// without creating the uploads directory.
+func printConfig(path string, overrides map[string]string) int {
+	cfg, err := config.Read(path, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+
+	if err := toml.NewEncoder(os.Stdout).Encode(config.Redact(cfg)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print config: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// checkConfig validates the configuration and template overrides without
+// changing anything on disk, printing one line per problem.
+func checkConfig(path string, overrides map[string]string) int {
+	cfg, problems := config.Check(path, overrides)
+
+	if cfg != nil && !slices.ContainsFunc(problems, func(p config.Problem) bool { return p.Key == "templates_dir" }) {
+		if _, err := templates.ParseTemplatesWithOverrides(templatesDir(cfg)); err != nil {
+			problems = append(problems, config.Problem{Key: "templates_dir", Message: err.Error()})
+		}
+	}
+
+	name := path
+	if name == "" {
+		name = "(environment)"
+	}
+
+	nErrors, nWarnings := 0, 0
+	for _, p := range problems {
+		level := "error"
+		if p.Warning {
+			level = "warning"
+			nWarnings++
+		} else {
+			nErrors++
+		}
+
+		location := name
+		if p.Line > 0 {
+			location = fmt.Sprintf("%s:%d", name, p.Line)
+		}
+		fmt.Printf("%s: %s: %s\n", location, level, p.Error())
+	}
+
+	if nErrors > 0 {
+		fmt.Printf("%d error(s), %d warning(s)\n", nErrors, nWarnings)
+		return 1
+	}
+	fmt.Printf("%s: OK (%d warning(s))\n", name, nWarnings)
+
+	return 0
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
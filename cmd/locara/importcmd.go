@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/importer"
+)
+
+// runImportCommand runs "locara import", which turns the files below a
+// directory into archives, and returns the exit code.
+func runImportCommand(args []string, path string, overrides map[string]string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	manifest := fs.String("manifest", "", "CSV or JSON `file` with the path, name, dated_on, type and author of files")
+	template := fs.String("template", "", "Derive metadata from paths, e.g. \"{year}/{type}/{author} - {name}.{ext}\"")
+	var defaults importer.Fields
+	fs.StringVar(&defaults.DatedOn, "dated", "", "Date of files with none in the manifest or path")
+	fs.StringVar(&defaults.Type, "type", "", "Type of files with none in the manifest or path")
+	fs.StringVar(&defaults.Author, "author", "", "Author of files with none in the manifest or path")
+	uploader := fs.String("uploader", "import", "Uploader recorded for the archives")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be imported")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s import [flags] <dir>\n\n", os.Args[0])
+		fmt.Fprintf(stderr, "Create an archive for every file below dir, skipping hidden files and files\n")
+		fmt.Fprintf(stderr, "whose content is already archived. Fields are taken from the manifest, then\n")
+		fmt.Fprintf(stderr, "the template, then the flags; the name defaults to the file name. Template\n")
+		fmt.Fprintf(stderr, "placeholders are {name}, {type}, {author}, {date}, {year}, {month}, {day},\n")
+		fmt.Fprintf(stderr, "{ext} and {any}.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+
+	opts := importer.Options{Defaults: defaults, Uploader: *uploader, DryRun: *dryRun}
+	if *manifest != "" {
+		m, err := importer.LoadManifest(*manifest)
+		if err != nil {
+			fmt.Fprintf(stderr, "import: %v\n", err)
+			return 2
+		}
+		opts.Manifest = m
+		opts.Exclude = []string{*manifest}
+	}
+	if *template != "" {
+		t, err := importer.ParseTemplate(*template)
+		if err != nil {
+			fmt.Fprintf(stderr, "import: %v\n", err)
+			return 2
+		}
+		opts.Template = t
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(stderr, "import: %s is not a directory\n", dir)
+		return 2
+	}
+
+	cfg, err := config.LoadWithOverrides(path, overrides)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	verbs := map[string]string{
+		importer.StatusImported:  "Imported",
+		importer.StatusDuplicate: "Skipped duplicate",
+		importer.StatusSkipped:   "Skipped",
+		importer.StatusFailed:    "Failed",
+	}
+	if *dryRun {
+		verbs[importer.StatusImported] = "Would import"
+	}
+
+	results := []importer.Result{}
+	counts := make(map[string]int)
+	err = importer.Run(ctx, cfg.UseDirectory, dir, opts, func(r importer.Result) {
+		results = append(results, r)
+		counts[r.Status]++
+		if *asJSON {
+			return
+		}
+		switch {
+		case r.Status == importer.StatusImported && r.ID != 0:
+			fmt.Fprintf(stdout, "%s %s as archive #%d (%s)\n", verbs[r.Status], r.Path, r.ID, r.Detail)
+		case r.Detail != "":
+			fmt.Fprintf(stdout, "%s %s: %s\n", verbs[r.Status], r.Path, r.Detail)
+		default:
+			fmt.Fprintf(stdout, "%s %s\n", verbs[r.Status], r.Path)
+		}
+	})
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(stderr, "import: interrupted, run it again to continue\n")
+	} else if err != nil {
+		fmt.Fprintf(stderr, "import: %v\n", err)
+	}
+
+	if *asJSON {
+		if err := printJSON(stdout, struct {
+			DryRun  bool              `json:"dry_run"`
+			Results []importer.Result `json:"results"`
+		}{*dryRun, results}); err != nil {
+			fmt.Fprintf(stderr, "import: %v\n", err)
+			return 1
+		}
+	} else {
+		fmt.Fprintf(stdout, "%s %d file(s), %d duplicate(s), %d skipped, %d failed\n", verbs[importer.StatusImported],
+			counts[importer.StatusImported], counts[importer.StatusDuplicate], counts[importer.StatusSkipped], counts[importer.StatusFailed])
+	}
+
+	if err != nil || counts[importer.StatusSkipped]+counts[importer.StatusFailed] > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/importer"
+)
+
+func TestImportCommand(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeOfflineConfig(t)
+	overrides := map[string]string{"use_directory": dir}
+
+	run := func(wantCode int, args ...string) string {
+		t.Helper()
+
+		var stdout, stderr bytes.Buffer
+		if code := runImportCommand(args, configPath, overrides, &stdout, &stderr); code != wantCode {
+			t.Fatalf("locara import %s exited with %d, want %d; stderr: %s", strings.Join(args, " "), code, wantCode, stderr.String())
+		}
+		return stdout.String()
+	}
+
+	src := t.TempDir()
+	for name, content := range map[string]string{
+		"2003/video/Jan - Summer tape.mp4": "tape",
+		"2003/video/Jan - Copy.mp4":        "tape",
+		"notes.txt":                        "notes",
+		"manifest.csv":                     "path,name,dated_on,type,author\nnotes.txt,Notes,1999,document,Ann\n",
+	} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	args := []string{"-manifest", filepath.Join(src, "manifest.csv"), "-template", "{year}/{type}/{author} - {name}.{ext}"}
+
+	if out := run(0, append(append([]string{"-dry-run"}, args...), src)...); !strings.Contains(out, "Would import 2 file(s), 1 duplicate(s), 0 skipped, 0 failed") {
+		t.Errorf("import -dry-run printed:\n%s", out)
+	}
+	if out := run(0, append(args, src)...); !strings.Contains(out, "Imported notes.txt as archive #2 (Notes, 1999, document, Ann)") {
+		t.Errorf("import printed:\n%s", out)
+	}
+	var result struct {
+		Results []importer.Result `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(run(0, append([]string{"-json"}, append(args, src)...)...)), &result); err != nil {
+		t.Fatalf("import -json printed invalid JSON: %v", err)
+	}
+	for _, r := range result.Results {
+		if r.Status != importer.StatusDuplicate {
+			t.Errorf("import again reported %+v, want only duplicates", r)
+		}
+	}
+
+	// Without the manifest the notes lack a date, type and author.
+	run(1, src)
+	run(2, "-template", "{title}", src)
+	run(2, filepath.Join(src, "missing"))
+}
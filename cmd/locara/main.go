@@ -1,143 +1,285 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Firstbober/locara/internal/buildinfo"
+	"github.com/Firstbober/locara/internal/clientip"
 	"github.com/Firstbober/locara/internal/config"
-	"github.com/Firstbober/locara/internal/handlers"
+	"github.com/Firstbober/locara/internal/listen"
+	"github.com/Firstbober/locara/internal/logging"
 	"github.com/Firstbober/locara/internal/templates"
 )
 
 func main() {
-	configPath := flag.String("config", config.DefaultConfigPath, "Path to configuration file")
-	port := flag.Int("port", 0, "Server port (overrides config file)")
+	configPath := flag.String("config", envOr(configPathEnv, config.DefaultConfigPath), "Path to configuration file (env "+configPathEnv+")")
+	dev := flag.Bool("dev", false, "Serve static assets and templates from disk for live editing (same as -dev_mode)")
+	watch := flag.Bool("watch", false, "Reload the config file when it changes, in addition to on SIGHUP")
+	version := flag.Bool("version", false, "Print the version and exit")
+	flagOverrides := registerConfigFlags(flag.CommandLine)
+	flag.Usage = usage
 	flag.Parse()
 
-	cfg, err := config.Load(*configPath)
-	if err != nil {
-		log.Fatalf("[ERROR] Failed to load config: %v", err)
+	if *version {
+		info := buildinfo.Get()
+		fmt.Printf("locara %s (commit %s, %s)\n", info.Version, info.Commit, info.GoVersion)
+		return
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		if _, ok := clientCommands[args[0]]; ok {
+			os.Exit(runClientCommand(args[0], args[1:], os.Stdout, os.Stderr, os.Getenv))
+		}
 	}
 
-	if *port > 0 {
-		if *port < 1 || *port > 65535 {
-			log.Fatalf("[ERROR] Invalid port number: %d", *port)
+	path := *configPath
+	if !isFlagSet("config") && os.Getenv(configPathEnv) == "" {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			slog.Info("No config file, using environment and flags only", "path", path)
+			path = ""
 		}
-		cfg.Port = *port
 	}
 
-	log.Printf("[INFO] Starting Locara server on port %d", cfg.Port)
-	log.Printf("[INFO] Using uploads directory: %s", cfg.UseDirectory)
-	log.Printf("[INFO] Configured %d user(s)", len(cfg.Users))
+	// Precedence: defaults < config file < LOCARA_* environment < flags.
+	overrides := func() map[string]string {
+		overrides := config.EnvOverrides(os.LookupEnv)
+		if *dev {
+			overrides["dev_mode"] = "true"
+		}
+		maps.Copy(overrides, flagOverrides)
+		return overrides
+	}
+	loadConfig := func() (*config.Config, error) {
+		return config.LoadWithOverrides(path, overrides())
+	}
 
-	setupReverseProxy()
+	switch args := flag.Args(); {
+	case len(args) == 0:
+	case args[0] == "config":
+		os.Exit(runConfigCommand(args[1:], path, overrides()))
+	case args[0] == "admin":
+		os.Exit(runAdminCommand(args[1:], path, overrides(), os.Stdout, os.Stderr))
+	case args[0] == "import":
+		os.Exit(runImportCommand(args[1:], path, overrides(), os.Stdout, os.Stderr))
+	case args[0] == "export":
+		os.Exit(runExportCommand(args[1:], path, overrides(), os.Stdout, os.Stderr))
+	case args[0] == "restore":
+		os.Exit(runRestoreCommand(args[1:], path, overrides(), os.Stdin, os.Stdout, os.Stderr))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	tmpl, err := templates.ParseTemplatesFromFS()
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to parse templates: %v", err)
-	}
-
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("GET /", loggingMiddleware(handlers.IndexHandler(tmpl, cfg)))
-	mux.HandleFunc("GET /upload", loggingMiddleware(handlers.UploadHandler(tmpl, cfg)))
-	mux.HandleFunc("GET /error", loggingMiddleware(handlers.ErrorHandler(tmpl, cfg)))
-	mux.HandleFunc("POST /api/archive/create", loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.CreateArchiveHandler(w, r, cfg)
-	}))
-	mux.HandleFunc("GET /api/archives", loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.ListArchivesHandler(w, r, cfg)
-	}))
-	mux.HandleFunc("GET /api/archive/{id}", loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.DownloadArchiveHandler(w, r, cfg)
-	}))
-	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      loggingMiddlewareAll(mux),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		fatal("Failed to load config", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		fatal("Failed to set up logging", err)
 	}
 
-	go func() {
-		log.Printf("[INFO] Server listening on %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("[ERROR] Server failed: %v", err)
-		}
-	}()
+	slog.Info("Starting Locara server", "version", buildinfo.Get().Version)
+	slog.Info("Using uploads directory", "path", cfg.UseDirectory)
+	slog.Info("Configured users", "count", len(cfg.Users))
+	if cfg.DevMode {
+		slog.Info("Dev mode enabled, serving static assets from disk", "path", "./"+devStaticDir)
+	}
 
-	gracefulShutdown(server)
-}
+	resolver, err := setupReverseProxy(cfg)
+	if err != nil {
+		fatal("Failed to configure trusted proxies", err)
+	}
 
-func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+	if dir := templatesDir(cfg); dir != "" {
+		slog.Info("Using template overrides", "path", dir)
+	}
 
-		clientIP := getClientIP(r)
-		log.Printf("[INFO] %s %s from %s", r.Method, r.URL.Path, clientIP)
+	tmpl, err := templates.ParseTemplatesWithOverrides(templatesDir(cfg))
+	if err != nil {
+		fatal("Failed to parse templates", err)
+	}
 
-		next(w, r)
+	a, err := newApp(cfg, tmpl, resolver)
+	if err != nil {
+		fatal("Failed to start", err)
+	}
 
-		log.Printf("[INFO] %s %s completed in %v", r.Method, r.URL.Path, time.Since(start))
+	if cfg.BaseUrl != "" {
+		slog.Info("Serving under base URL", "base_url", cfg.BaseUrl)
 	}
-}
 
-func loggingMiddlewareAll(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+	rl := newReloader(a, loadConfig)
+	go rl.handleSIGHUP()
+	if *watch && path != "" {
+		slog.Info("Watching config file for changes", "path", path)
+		go rl.watch(path, 2*time.Second)
+	}
 
-		clientIP := getClientIP(r)
-		log.Printf("[INFO] %s %s from %s", r.Method, r.URL.Path, clientIP)
+	listeners, err := openListeners(cfg)
+	if err != nil {
+		fatal("Failed to listen", err)
+	}
 
-		next.ServeHTTP(w, r)
+	server := newServer(rl.live)
+	servers := []*http.Server{server}
 
-		log.Printf("[INFO] %s %s completed in %v", r.Method, r.URL.Path, time.Since(start))
-	})
-}
+	if cfg.TLSEnabled() {
+		server.TLSConfig, err = setupTLS(cfg)
+		if err != nil {
+			fatal("Failed to configure TLS", err)
+		}
+		slog.Info("Serving HTTPS", "certificate", cfg.TLSCert)
+		if cfg.ClientCertsEnabled() {
+			slog.Info("Client certificates enabled", "mode", cfg.TLSClientAuth, "ca", cfg.TLSClientCA)
+		}
+	}
+
+	if cfg.HTTPRedirectAddr != "" {
+		redirect := newServer(httpsRedirectHandler(httpsPort(cfg)))
+		redirect.Addr = cfg.HTTPRedirectAddr
+		servers = append(servers, redirect)
 
-func getClientIP(r *http.Request) string {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		return forwarded
+		go func() {
+			slog.Info("Redirecting HTTP to HTTPS", "addr", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("HTTP redirect server failed", err)
+			}
+		}()
 	}
-	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-		return realIP
+
+	if cfg.Metrics.Enabled && cfg.Metrics.Listen != "" {
+		ml, err := listen.Open([]string{cfg.Metrics.Listen}, listen.SocketOptions{})
+		if err != nil {
+			fatal("Failed to listen for metrics", err)
+		}
+		metricsServer := newServer(rl.metrics)
+		servers = append(servers, metricsServer)
+
+		go func() {
+			slog.Info("Serving metrics", "addr", listenerName(ml[0]))
+			if err := metricsServer.Serve(ml[0]); err != nil && err != http.ErrServerClosed {
+				fatal("Metrics server failed", err)
+			}
+		}()
+	}
+
+	useTLS := server.TLSConfig != nil
+	for _, l := range listeners {
+		go func() {
+			slog.Info("Server listening", "addr", listenerName(l))
+			var err error
+			if useTLS {
+				err = server.ServeTLS(l, "", "")
+			} else {
+				err = server.Serve(l)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				fatal("Server failed", err)
+			}
+		}()
 	}
-	return r.RemoteAddr
+
+	gracefulShutdown(rl, servers...)
 }
 
-func gracefulShutdown(server *http.Server) {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// newServer returns a server for h with finite timeouts. The upload and
+// download handlers lift them for their own requests, see
+// transfer.Transfer.KeepAlive.
+func newServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      h,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+}
+
+// fatal logs err and exits, for errors the server cannot run with.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// openListeners takes over the sockets passed by systemd socket activation,
+// or listens on the configured addresses.
+func openListeners(cfg *config.Config) ([]net.Listener, error) {
+	listeners, err := listen.Systemd()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		slog.Info("Using sockets passed by systemd, ignoring listen and port", "count", len(listeners))
+		return listeners, nil
+	}
+
+	opts := listen.SocketOptions{Group: cfg.ListenSocketGroup}
+	if cfg.ListenSocketMode != "" {
+		if opts.Mode, err = listen.ParseSocketMode(cfg.ListenSocketMode); err != nil {
+			return nil, err
+		}
+	}
 
-	sig := <-sigChan
-	log.Printf("[INFO] Received signal %v, shutting down...", sig)
+	return listen.Open(cfg.ListenAddrs(), opts)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+func listenerName(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return listen.UnixPrefix + l.Addr().String()
+	}
+	return l.Addr().String()
+}
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("[ERROR] Server shutdown error: %v", err)
-		os.Exit(1)
+// httpsPort returns the port HTTP requests are redirected to: that of the
+// first TCP listen address, or the configured port.
+func httpsPort(cfg *config.Config) int {
+	for _, addr := range cfg.Listen {
+		if network, address, err := listen.ParseAddr(addr); err == nil && network == "tcp" {
+			_, port, _ := net.SplitHostPort(address)
+			if n, err := strconv.Atoi(port); err == nil && n > 0 {
+				return n
+			}
+		}
 	}
+	return cfg.Port
+}
 
-	log.Printf("[INFO] Server stopped gracefully")
+// templatesDir returns the directory template overrides are read from, which
+// defaults to the template sources in dev mode.
+func templatesDir(cfg *config.Config) string {
+	if cfg.TemplatesDir == "" && cfg.DevMode {
+		return templates.DevDir
+	}
+	return cfg.TemplatesDir
 }
 
-func setupReverseProxy() {
-	if len(os.Getenv("TRUSTED_PROXIES")) > 0 {
-		log.Printf("[INFO] Running behind reverse proxy, TRUSTED_PROXIES=%s", os.Getenv("TRUSTED_PROXIES"))
+// setupReverseProxy builds the client IP resolver from the trusted proxies in
+// the config, overridden by the legacy comma separated TRUSTED_PROXIES
+// variable unless LOCARA_TRUSTED_PROXIES is set.
+func setupReverseProxy(cfg *config.Config) (*clientip.Resolver, error) {
+	proxies := cfg.TrustedProxies
+	_, overridden := os.LookupEnv(config.EnvName("trusted_proxies"))
+	if env := os.Getenv("TRUSTED_PROXIES"); env != "" && !overridden {
+		proxies = strings.Split(env, ",")
 	}
 
-	if os.Getenv("X_FORWARDED_FOR") != "" {
-		log.Printf("[INFO] X-Forwarded-For detected, proxy mode enabled")
+	trusted, err := clientip.ParseTrusted(proxies)
+	if err != nil {
+		return nil, err
 	}
+
+	if len(trusted) > 0 {
+		slog.Info("Running behind reverse proxy, trusting forwarding headers", "trusted_proxies", fmt.Sprint(trusted))
+	}
+
+	return clientip.NewResolver(trusted), nil
 }
@@ -0,0 +1,849 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Firstbober/locara/internal/clientip"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/models"
+	"github.com/Firstbober/locara/internal/share"
+	"github.com/Firstbober/locara/internal/storage"
+	"github.com/Firstbober/locara/internal/templates"
+)
+
+const testAuthCode = "test-code"
+
+func newTestServer(t *testing.T, baseURL string) *httptest.Server {
+	t.Helper()
+
+	return newTestServerWithConfig(t, &config.Config{BaseUrl: baseURL})
+}
+
+// newTestServerWithConfig serves the app with cfg, filling in an uploads
+// directory, the test user and disabled login throttling.
+func newTestServerWithConfig(t *testing.T, cfg *config.Config) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(newTestApp(t, cfg).handler())
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestApp(t *testing.T, cfg *config.Config) *app {
+	t.Helper()
+
+	cfg.UseDirectory = t.TempDir()
+	cfg.Users = []config.User{{Name: "tester", Auth: testAuthCode, Role: config.RoleUploader}}
+	cfg.LoginThrottle.Disabled = true
+
+	tmpl, err := templates.ParseTemplates()
+	if err != nil {
+		t.Fatalf("ParseTemplates() failed: %v", err)
+	}
+
+	a, err := newApp(cfg, tmpl, clientip.NewResolver(nil))
+	if err != nil {
+		t.Fatalf("newApp() failed: %v", err)
+	}
+
+	return a
+}
+
+func noRedirectClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func uploadRequest(t *testing.T, url string, fields map[string]string, content string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if content != "" {
+		fw, err := mw.CreateFormFile("ar_file", "test.txt")
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
+}
+
+func TestRoutingUnderBaseURL(t *testing.T) {
+	for _, base := range []string{"", "/locara"} {
+		t.Run("base="+base, func(t *testing.T) {
+			server := newTestServer(t, base)
+			client := noRedirectClient()
+			root := server.URL + base
+
+			for _, path := range []string{"/", "/upload", "/error"} {
+				resp, err := client.Get(root + path)
+				if err != nil {
+					t.Fatalf("GET %s failed: %v", path, err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("GET %s status = %d, want %d", path, resp.StatusCode, http.StatusOK)
+				}
+				if !strings.Contains(string(body), `href="`+base+`/upload"`) {
+					t.Errorf("GET %s does not link to %s/upload", path, base)
+				}
+			}
+
+			fields := map[string]string{
+				"ar_auth_code": testAuthCode,
+				"ar_name":      "Test",
+				"ar_dated":     "2024-01-01",
+				"ar_type":      "other",
+				"ar_author":    "Author",
+			}
+
+			tests := []struct {
+				name     string
+				fields   map[string]string
+				content  string
+				location string
+			}{
+				{"missing file", fields, "", base + "/"},
+				{"invalid auth code", map[string]string{"ar_auth_code": "wrong"}, "data", base + "/error"},
+				{"success", fields, "archive content", base + "/"},
+			}
+
+			for _, tt := range tests {
+				resp, err := client.Do(uploadRequest(t, root+"/api/archive/create", tt.fields, tt.content))
+				if err != nil {
+					t.Fatalf("%s: upload failed: %v", tt.name, err)
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusSeeOther {
+					t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, http.StatusSeeOther)
+				}
+				if got := resp.Header.Get("Location"); got != tt.location {
+					t.Errorf("%s: Location = %q, want %q", tt.name, got, tt.location)
+				}
+			}
+
+			resp, err := client.Get(root + "/api/archive/1")
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK || string(body) != "archive content" {
+				t.Errorf("Download = %d %q, want %d %q", resp.StatusCode, body, http.StatusOK, "archive content")
+			}
+
+			resp, err = client.Get(root + "/api/archive/42")
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("Location"); got != base+"/" {
+				t.Errorf("Missing archive Location = %q, want %q", got, base+"/")
+			}
+		})
+	}
+}
+
+func TestEmbeddedStaticAssets(t *testing.T) {
+	for _, base := range []string{"", "/locara"} {
+		server := newTestServer(t, base)
+
+		resp, err := http.Get(server.URL + base + "/")
+		if err != nil {
+			t.Fatalf("GET / failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		match := regexp.MustCompile(`href="(` + regexp.QuoteMeta(base) + `/static/css/style\.[0-9a-f]+\.css)"`).FindSubmatch(body)
+		if match == nil {
+			t.Fatalf("base=%q: index does not link a fingerprinted stylesheet", base)
+		}
+
+		resp, err = http.Get(server.URL + string(match[1]))
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", match[1], err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s status = %d, want %d", match[1], resp.StatusCode, http.StatusOK)
+		}
+		if !strings.Contains(resp.Header.Get("Cache-Control"), "immutable") {
+			t.Errorf("GET %s Cache-Control = %q, want immutable", match[1], resp.Header.Get("Cache-Control"))
+		}
+	}
+}
+
+func TestRoutesOutsideBaseURL(t *testing.T) {
+	server := newTestServer(t, "/locara")
+	client := noRedirectClient()
+
+	resp, err := client.Get(server.URL + "/upload")
+	if err != nil {
+		t.Fatalf("GET /upload failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /upload outside base status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	resp, err = client.Get(server.URL + "/locara")
+	if err != nil {
+		t.Fatalf("GET /locara failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Location"); got != "/locara/" {
+		t.Errorf("GET /locara Location = %q, want %q", got, "/locara/")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	uploads := filepath.Join(dir, "uploads")
+
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	writeConfig(fmt.Sprintf(`
+use_directory = %q
+
+[[users]]
+name = "tester"
+auth = "test-code"
+`, uploads))
+
+	load := func() (*config.Config, error) { return config.Load(path) }
+	cfg, err := load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	tmpl, err := templates.ParseTemplates()
+	if err != nil {
+		t.Fatalf("ParseTemplates() failed: %v", err)
+	}
+	a, err := newApp(cfg, tmpl, clientip.NewResolver(nil))
+	if err != nil {
+		t.Fatalf("newApp() failed: %v", err)
+	}
+
+	rl := newReloader(a, load)
+	server := httptest.NewServer(rl.live)
+	t.Cleanup(server.Close)
+	client := noRedirectClient()
+
+	upload := func(code string) string {
+		t.Helper()
+		fields := map[string]string{
+			"ar_auth_code": code,
+			"ar_name":      "Test",
+			"ar_dated":     "2024-01-01",
+			"ar_type":      "other",
+			"ar_author":    "Author",
+		}
+		resp, err := client.Do(uploadRequest(t, server.URL+"/api/archive/create", fields, "data"))
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Location")
+	}
+
+	if got := upload("new-code"); got != "/error" {
+		t.Fatalf("Upload with unknown code Location = %q, want /error", got)
+	}
+
+	writeConfig(fmt.Sprintf(`
+use_directory = %q
+port = 9999
+
+[[users]]
+name = "tester"
+auth = "test-code"
+
+[[users]]
+name = "newcomer"
+auth = "new-code"
+`, uploads))
+	if err := rl.reload(); err != nil {
+		t.Fatalf("reload() failed: %v", err)
+	}
+	if got := upload("new-code"); got != "/" {
+		t.Errorf("Upload after reload Location = %q, want /", got)
+	}
+	if rl.app.cfg.Port != cfg.Port {
+		t.Errorf("Port after reload = %d, want it kept at %d", rl.app.cfg.Port, cfg.Port)
+	}
+
+	writeConfig(`use_directory = ""`)
+	if err := rl.reload(); err == nil {
+		t.Fatalf("reload() of an invalid config succeeded")
+	}
+	if got := upload("new-code"); got != "/" {
+		t.Errorf("Upload after rejected reload Location = %q, want /", got)
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		port     int
+		host     string
+		target   string
+		location string
+	}{
+		{443, "example.org", "/locara/upload?x=1", "https://example.org/locara/upload?x=1"},
+		{443, "example.org:80", "/", "https://example.org/"},
+		{8443, "example.org:8080", "/", "https://example.org:8443/"},
+		{443, "[::1]:80", "/", "https://[::1]/"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+
+		httpsRedirectHandler(tt.port).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.location {
+			t.Errorf("Redirect of %s%s = %d %q, want %q", tt.host, tt.target, rec.Code, rec.Header().Get("Location"), tt.location)
+		}
+	}
+}
+
+func TestHSTSHeader(t *testing.T) {
+	cfg := &config.Config{HSTSMaxAge: 24 * time.Hour, HSTSIncludeSubdomains: true}
+	h := hstsMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.org/", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=86400; includeSubDomains" {
+		t.Errorf("Strict-Transport-Security = %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.org/", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Strict-Transport-Security over plain HTTP = %q, want none", got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := newTestServerWithConfig(t, &config.Config{
+		Metrics: config.Metrics{Enabled: true, Token: "scrape"},
+	})
+	client := noRedirectClient()
+
+	fields := map[string]string{
+		"ar_auth_code": testAuthCode,
+		"ar_name":      "Test",
+		"ar_dated":     "2024-01-01",
+		"ar_type":      "other",
+		"ar_author":    "Author",
+	}
+	resp, err := client.Do(uploadRequest(t, server.URL+"/api/archive/create", fields, "archive content"))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = client.Get(server.URL + "/api/archive/1")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = client.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /metrics without token status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	for _, want := range []string{
+		`locara_http_requests_total{method="GET",route="/api/archive/{id}",status="200"}`,
+		`locara_http_requests_total{method="POST",route="/api/archive/create",status="303"}`,
+		`locara_http_request_duration_seconds_bucket{method="GET",route="/api/archive/{id}",status="200",le="+Inf"}`,
+		"locara_archives 1\n",
+		"locara_archive_stored_bytes 15\n",
+		`locara_active_transfers{direction="upload"} 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+
+	// The archive scan is cached between scrapes.
+	resp, err = client.Do(uploadRequest(t, server.URL+"/api/archive/create", fields, "more content"))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "locara_archives 1\n") {
+		t.Errorf("metrics scraped again within a minute rescanned the archives")
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	a := newTestApp(t, &config.Config{})
+	server := httptest.NewServer(a.handler())
+	t.Cleanup(server.Close)
+
+	get := func(path string, v any) int {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s returned invalid JSON: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+
+	var health map[string]string
+	if code := get("/healthz", &health); code != http.StatusOK || health["status"] != "ok" {
+		t.Errorf("/healthz = %d %v, want 200 ok", code, health)
+	}
+
+	var ready struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if code := get("/readyz", &ready); code != http.StatusOK || ready.Status != "ready" {
+		t.Errorf("/readyz = %d %+v, want 200 ready", code, ready)
+	}
+	for _, name := range []string{"storage", "writable"} {
+		if ready.Checks[name] != "ok" {
+			t.Errorf("/readyz check %s = %q, want ok", name, ready.Checks[name])
+		}
+	}
+
+	a.stopping.Store(true)
+	if code := get("/readyz", &ready); code != http.StatusServiceUnavailable || ready.Checks["shutdown"] == "" {
+		t.Errorf("/readyz during shutdown = %d %+v, want 503 with shutdown check", code, ready)
+	}
+	if code := get("/healthz", &health); code != http.StatusOK {
+		t.Errorf("/healthz during shutdown = %d, want 200", code)
+	}
+
+	var version map[string]string
+	if code := get("/version", &version); code != http.StatusOK || version["version"] == "" || !strings.HasPrefix(version["go_version"], "go") {
+		t.Errorf("/version = %d %v, want version and Go version", code, version)
+	}
+}
+
+func TestUploadRefusedDuringShutdown(t *testing.T) {
+	a := newTestApp(t, &config.Config{})
+	server := httptest.NewServer(a.handler())
+	t.Cleanup(server.Close)
+
+	a.stopping.Store(true)
+
+	fields := map[string]string{
+		"ar_auth_code": testAuthCode,
+		"ar_name":      "Test",
+		"ar_dated":     "2024-01-01",
+		"ar_type":      "other",
+		"ar_author":    "Author",
+	}
+	resp, err := noRedirectClient().Do(uploadRequest(t, server.URL+"/api/archive/create", fields, "archive content"))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+	if entries, _ := os.ReadDir(a.cfg.UseDirectory); slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == "1" }) {
+		t.Error("refused upload was stored")
+	}
+}
+
+func TestShutdownDrainsLongTransfers(t *testing.T) {
+	a := newTestApp(t, &config.Config{})
+	server := newServer(a.handler())
+	if server.ReadTimeout <= 0 || server.WriteTimeout <= 0 {
+		t.Fatalf("server timeouts = %v read, %v write, want finite defaults", server.ReadTimeout, server.WriteTimeout)
+	}
+	// Shortened so both transfers outlast them.
+	server.ReadTimeout, server.WriteTimeout = 200*time.Millisecond, 200*time.Millisecond
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	url := "http://" + l.Addr().String()
+
+	content := strings.Repeat("0123456789abcdef", 1<<19)
+	header := &multipart.FileHeader{Filename: "large.bin", Size: int64(len(content))}
+	meta := &models.Archive{Name: "large", FileName: header.Filename, SizeBytes: header.Size, DatedOn: "2024-01-01", Type: "other", Uploader: "tester"}
+	if err := storage.SaveArchive(context.Background(), a.cfg.UseDirectory, strings.NewReader(content), header, meta); err != nil {
+		t.Fatalf("SaveArchive() failed: %v", err)
+	}
+
+	download, err := http.Get(url + "/api/archive/1")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	defer download.Body.Close()
+
+	// The upload body trickles in while the server shuts down.
+	body, bodyWriter := io.Pipe()
+	mw := multipart.NewWriter(bodyWriter)
+	req, _ := http.NewRequest(http.MethodPost, url+"/api/archive/create", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	go func() {
+		for k, v := range map[string]string{"ar_auth_code": testAuthCode, "ar_name": "Slow", "ar_dated": "2024-01-01", "ar_type": "other", "ar_author": "Author"} {
+			mw.WriteField(k, v)
+		}
+		fw, _ := mw.CreateFormFile("ar_file", "slow.txt")
+		for range 10 {
+			fw.Write([]byte(strings.Repeat("x", 1024)))
+			time.Sleep(100 * time.Millisecond)
+		}
+		mw.Close()
+		bodyWriter.Close()
+	}()
+	uploaded := make(chan *http.Response, 1)
+	go func() {
+		resp, err := noRedirectClient().Do(req)
+		if err != nil {
+			t.Errorf("Upload failed: %v", err)
+		}
+		uploaded <- resp
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); a.transfers.Len() < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d transfer(s) running, want the download and the upload", a.transfers.Len())
+		}
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		stopped <- server.Shutdown(ctx)
+	}()
+
+	// Read the download slowly, so it spans the shutdown.
+	var received int
+	buf := make([]byte, 256<<10)
+	for {
+		n, err := download.Body.Read(buf)
+		received += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Download interrupted after %d bytes: %v", received, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if received != len(content) {
+		t.Errorf("Download received %d bytes, want %d", received, len(content))
+	}
+
+	if resp := <-uploaded; resp != nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+			t.Errorf("Upload during shutdown = %d to %q, want a redirect to /", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown() = %v, want the transfers drained", err)
+	}
+}
+
+func TestShareDownloadCounting(t *testing.T) {
+	a := newTestApp(t, &config.Config{})
+	server := httptest.NewServer(a.handler())
+	t.Cleanup(server.Close)
+
+	content := strings.Repeat("0123456789abcdef", 1024)
+	header := &multipart.FileHeader{Filename: "data.bin", Size: int64(len(content))}
+	meta := &models.Archive{Name: "data", FileName: header.Filename, SizeBytes: header.Size, DatedOn: "2024-01-01", Type: "other", Uploader: "tester"}
+	if err := storage.SaveArchive(context.Background(), a.cfg.UseDirectory, strings.NewReader(content), header, meta); err != nil {
+		t.Fatalf("SaveArchive() failed: %v", err)
+	}
+	link, err := a.shares.Create(1, "tester", time.Now().Add(time.Hour), 3, "")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	get := func(link *share.Link, rangeHeader string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+a.shares.URL(link), nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := noRedirectClient().Do(req)
+		if err != nil {
+			t.Errorf("GET share link failed: %v", err)
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	downloads := func(link *share.Link) int {
+		t.Helper()
+		l, err := a.shares.Get(link.ID)
+		if err != nil {
+			t.Fatalf("Get() failed: %v", err)
+		}
+		return l.Downloads
+	}
+
+	// A range request on a limited link counts and gets the whole file.
+	status, body := get(link, "bytes=1024-")
+	if status != http.StatusOK || body != content {
+		t.Errorf("ranged download = %d with %d bytes, want %d with the whole file", status, len(body), http.StatusOK)
+	}
+	if n := downloads(link); n != 1 {
+		t.Errorf("Downloads after ranged download = %d, want 1", n)
+	}
+
+	// Concurrent requests cannot go over the limit.
+	var wg sync.WaitGroup
+	var served atomic.Int32
+	for range 10 {
+		wg.Go(func() {
+			if status, _ := get(link, ""); status == http.StatusOK {
+				served.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if n := served.Load(); n != 2 {
+		t.Errorf("%d concurrent downloads served, want 2", n)
+	}
+	if n := downloads(link); n != 3 {
+		t.Errorf("Downloads after concurrent downloads = %d, want 3", n)
+	}
+
+	// A download that fails before sending anything gives its count back.
+	single, err := a.shares.Create(1, "tester", time.Now().Add(time.Hour), 1, "")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if err := os.Remove(filepath.Join(a.cfg.UseDirectory, "1", "data.bin")); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	if status, _ := get(single, ""); status == http.StatusOK {
+		t.Errorf("download of missing file succeeded")
+	}
+	if n := downloads(single); n != 0 {
+		t.Errorf("Downloads after missing file = %d, want 0", n)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	server := newTestServerWithConfig(t, &config.Config{MaxUploadSize: 4096})
+	client := noRedirectClient()
+
+	fields := map[string]string{
+		"ar_name":   "Test",
+		"ar_dated":  "2024-01-01",
+		"ar_type":   "other",
+		"ar_author": "Author",
+	}
+
+	do := func(req *http.Request) (int, map[string]any) {
+		t.Helper()
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", req.Method, req.URL.Path, err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s Content-Type = %q, want application/json", req.Method, req.URL.Path, ct)
+		}
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Errorf("%s %s returned invalid JSON: %v", req.Method, req.URL.Path, err)
+		}
+		return resp.StatusCode, body
+	}
+
+	upload := func(auth string, fields map[string]string, content string) *http.Request {
+		req := uploadRequest(t, server.URL+"/api/archive/create", fields, content)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		return req
+	}
+
+	chunked := upload(testAuthCode, fields, strings.Repeat("x", 8192))
+	chunked.ContentLength = -1
+	chunked.Body = io.NopCloser(struct{ io.Reader }{chunked.Body})
+
+	// Without a bearer token the client asks for JSON explicitly.
+	noAuth := upload("", fields, "data")
+	noAuth.Header.Set("Accept", "application/json")
+	noFields := upload("", map[string]string{"ar_auth_code": testAuthCode}, "data")
+	noFields.Header.Set("Accept", "application/json")
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"missing auth", noAuth, http.StatusUnauthorized, "auth_required"},
+		{"invalid auth", upload("wrong", fields, "data"), http.StatusUnauthorized, "invalid_auth"},
+		{"missing file", upload(testAuthCode, fields, ""), http.StatusBadRequest, "missing_field"},
+		{"missing fields", noFields, http.StatusBadRequest, "missing_field"},
+		{"too large", upload(testAuthCode, fields, strings.Repeat("x", 8192)), http.StatusRequestEntityTooLarge, "upload_too_large"},
+		{"too large chunked", chunked, http.StatusRequestEntityTooLarge, "upload_too_large"},
+	}
+	for _, tt := range tests {
+		status, body := do(tt.req)
+		if status != tt.status || body["code"] != tt.code || body["error"] == "" {
+			t.Errorf("%s: %d %v, want %d with code %q", tt.name, status, body, tt.status, tt.code)
+		}
+	}
+
+	status, body := do(upload(testAuthCode, fields, "archive content"))
+	if status != http.StatusCreated || body["id"] != float64(1) {
+		t.Errorf("upload = %d %v, want %d with id 1", status, body, http.StatusCreated)
+	}
+
+	for path, want := range map[string]int{
+		"/api/archive/42":  http.StatusNotFound,
+		"/api/archive/abc": http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Accept", "application/json")
+		if status, body := do(req); status != want {
+			t.Errorf("GET %s = %d %v, want %d", path, status, body, want)
+		}
+	}
+}
+
+func TestFlashMessages(t *testing.T) {
+	server := newTestServer(t, "/locara")
+	root := server.URL + "/locara"
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New() failed: %v", err)
+	}
+	client := &http.Client{Jar: jar}
+
+	page := func(resp *http.Response, err error) string {
+		t.Helper()
+
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(body)
+	}
+
+	fields := map[string]string{
+		"ar_auth_code": testAuthCode,
+		"ar_name":      "Test <1>",
+		"ar_dated":     "2024-01-01",
+		"ar_type":      "other",
+	}
+	body := page(client.Do(uploadRequest(t, root+"/api/archive/create", fields, "archive content")))
+	if !strings.Contains(body, `class="flash flash-error"`) || !strings.Contains(body, "Missing required fields: ar_author") {
+		t.Errorf("Index after failed upload does not explain the failure:\n%s", body)
+	}
+	if body := page(client.Get(root + "/")); strings.Contains(body, `class="flash`) {
+		t.Error("Flash message shown more than once")
+	}
+
+	fields["ar_author"] = "Author"
+	body = page(client.Do(uploadRequest(t, root+"/api/archive/create", fields, "archive content")))
+	if !strings.Contains(body, `class="flash flash-success"`) || !strings.Contains(body, `href="/locara/api/archive/1"`) {
+		t.Errorf("Index after upload does not link to the new archive:\n%s", body)
+	}
+	if strings.Contains(body, "Test <1>") {
+		t.Error("Flash message is not HTML-escaped")
+	}
+
+	fields["ar_auth_code"] = "wrong"
+	body = page(client.Do(uploadRequest(t, root+"/api/archive/create", fields, "archive content")))
+	if !strings.Contains(body, "Invalid auth code") || strings.Contains(body, "An error occurred") {
+		t.Errorf("Error page does not show the reason:\n%s", body)
+	}
+	if body := page(client.Get(root + "/error")); !strings.Contains(body, "An error occurred") {
+		t.Error("Error page without a flash message lost its generic text")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/logging"
+	"github.com/Firstbober/locara/internal/templates"
+)
+
+// liveHandler serves every request with the most recently built handler, so
+// a reload takes effect for new requests while running ones finish with the
+// config they started with.
+type liveHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func newLiveHandler(h http.Handler) *liveHandler {
+	live := &liveHandler{}
+	live.current.Store(&h)
+	return live
+}
+
+func (l *liveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*l.current.Load()).ServeHTTP(w, r)
+}
+
+// reloader re-reads the configuration and swaps in handlers built from it.
+type reloader struct {
+	load    func() (*config.Config, error)
+	live    *liveHandler
+	metrics *liveHandler
+
+	mu  sync.Mutex
+	app *app
+}
+
+func newReloader(a *app, load func() (*config.Config, error)) *reloader {
+	return &reloader{
+		load:    load,
+		live:    newLiveHandler(a.handler()),
+		metrics: newLiveHandler(a.metricsHandler()),
+		app:     a,
+	}
+}
+
+// reload loads the configuration again and applies it. An invalid config is
+// rejected and the running one stays active.
+func (rl *reloader) reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cfg, err := rl.load()
+	if err != nil {
+		return err
+	}
+
+	old := rl.app.cfg
+	for _, key := range keepRestartOnly(old, cfg) {
+		slog.Warn("Changing this key requires a restart, keeping the current value", "key", key)
+	}
+
+	next, err := rl.app.reconfigure(cfg)
+	if err != nil {
+		return err
+	}
+
+	h := next.handler()
+	rl.live.current.Store(&h)
+	m := next.metricsHandler()
+	rl.metrics.current.Store(&m)
+	rl.app = next
+
+	changes := config.Diff(old, cfg)
+	if len(changes) == 0 {
+		slog.Info("Config reloaded, no changes")
+	}
+	for _, change := range changes {
+		slog.Info("Config reloaded", "change", change)
+	}
+
+	return nil
+}
+
+// current returns the app built from the active configuration.
+func (rl *reloader) current() *app {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.app
+}
+
+// handleSIGHUP reloads the configuration whenever the process receives SIGHUP.
+func (rl *reloader) handleSIGHUP() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	for range sigChan {
+		slog.Info("Received SIGHUP, reloading config")
+		if err := rl.reload(); err != nil {
+			slog.Error("Config reload failed, keeping the current config", "error", err)
+		}
+	}
+}
+
+// watch polls the config file and reloads it when its size or modification
+// time changes.
+func (rl *reloader) watch(path string, interval time.Duration) {
+	last, _ := os.Stat(path)
+
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+
+		slog.Info("Config file changed, reloading")
+		if err := rl.reload(); err != nil {
+			slog.Error("Config reload failed, keeping the current config", "error", err)
+		}
+	}
+}
+
+// reconfigure returns a copy of the app using cfg, keeping the stores and
+// login throttling state of the running one.
+func (a *app) reconfigure(cfg *config.Config) (*app, error) {
+	tmpl, err := templates.ParseTemplatesWithOverrides(templatesDir(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse templates: %w", err)
+	}
+	templates.SetAssetFunc(tmpl, a.assets.Path)
+
+	resolver, err := setupReverseProxy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure trusted proxies: %w", err)
+	}
+
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	a.throttle.Reconfigure(cfg.LoginThrottle)
+
+	next := *a
+	next.cfg = cfg
+	next.tmpl = tmpl
+	next.resolver = resolver
+
+	return &next, nil
+}
+
+// keepRestartOnly copies the settings that cannot change while the server is
+// running from old into cfg and returns the keys that differed.
+func keepRestartOnly(old, cfg *config.Config) []string {
+	var kept []string
+
+	keep := func(key string, changed bool) {
+		if changed {
+			kept = append(kept, key)
+		}
+	}
+
+	keep("use_directory", old.UseDirectory != cfg.UseDirectory)
+	cfg.UseDirectory = old.UseDirectory
+	keep("port", old.Port != cfg.Port)
+	cfg.Port = old.Port
+	keep("listen", !slices.Equal(old.Listen, cfg.Listen))
+	cfg.Listen = old.Listen
+	keep("listen_socket_mode", old.ListenSocketMode != cfg.ListenSocketMode)
+	cfg.ListenSocketMode = old.ListenSocketMode
+	keep("listen_socket_group", old.ListenSocketGroup != cfg.ListenSocketGroup)
+	cfg.ListenSocketGroup = old.ListenSocketGroup
+	keep("base_url", old.BaseUrl != cfg.BaseUrl)
+	cfg.BaseUrl = old.BaseUrl
+	keep("secret_key", old.SecretKey != cfg.SecretKey)
+	cfg.SecretKey = old.SecretKey
+	keep("dev_mode", old.DevMode != cfg.DevMode)
+	cfg.DevMode = old.DevMode
+	keep("log_format", old.LogFormat != cfg.LogFormat)
+	cfg.LogFormat = old.LogFormat
+	keep("tls_cert", old.TLSCert != cfg.TLSCert)
+	cfg.TLSCert = old.TLSCert
+	keep("tls_key", old.TLSKey != cfg.TLSKey)
+	cfg.TLSKey = old.TLSKey
+	keep("tls_client_ca", old.TLSClientCA != cfg.TLSClientCA)
+	cfg.TLSClientCA = old.TLSClientCA
+	keep("tls_client_auth", old.TLSClientAuth != cfg.TLSClientAuth)
+	cfg.TLSClientAuth = old.TLSClientAuth
+	keep("http_redirect_addr", old.HTTPRedirectAddr != cfg.HTTPRedirectAddr)
+	cfg.HTTPRedirectAddr = old.HTTPRedirectAddr
+	keep("metrics.listen", old.Metrics.Listen != cfg.Metrics.Listen)
+	cfg.Metrics.Listen = old.Metrics.Listen
+
+	return kept
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/Firstbober/locara/internal/assets"
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/clientip"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/handlers"
+	"github.com/Firstbober/locara/internal/logging"
+	"github.com/Firstbober/locara/internal/metrics"
+	"github.com/Firstbober/locara/internal/share"
+	"github.com/Firstbober/locara/internal/storage"
+	"github.com/Firstbober/locara/internal/templates"
+	"github.com/Firstbober/locara/internal/transfer"
+	"github.com/Firstbober/locara/static"
+)
+
+// devStaticDir is where static assets are read from in dev mode, relative to
+// the repository root.
+const devStaticDir = "static"
+
+// app holds the state shared by all HTTP routes.
+type app struct {
+	cfg      *config.Config
+	tmpl     *template.Template
+	resolver *clientip.Resolver
+	shares   *share.Store
+	sessions *auth.Sessions
+	users    *auth.UserStore
+	throttle *auth.Throttle
+	assets   *assets.Assets
+	// stopping is set once shutdown starts so /readyz fails and uploads
+	// are refused.
+	stopping  *atomic.Bool
+	transfers *transfer.Tracker
+}
+
+// newApp opens the stores kept in the uploads directory and prepares the
+// state the routes are built from.
+func newApp(cfg *config.Config, tmpl *template.Template, resolver *clientip.Resolver) (*app, error) {
+	secret, err := loadSecret(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := share.Open(cfg.UseDirectory, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open share link store: %w", err)
+	}
+
+	users, err := auth.OpenUserStore(cfg.UseDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user store: %w", err)
+	}
+
+	var staticFS fs.FS = static.FS
+	if cfg.DevMode {
+		staticFS = os.DirFS(devStaticDir)
+	}
+	staticAssets, err := assets.New(staticFS, cfg.DevMode)
+	if err != nil {
+		return nil, err
+	}
+	templates.SetAssetFunc(tmpl, staticAssets.Path)
+
+	return &app{
+		cfg:       cfg,
+		tmpl:      tmpl,
+		resolver:  resolver,
+		shares:    shares,
+		sessions:  auth.NewSessions(secret, cfg.BaseUrl+"/"),
+		users:     users,
+		throttle:  auth.NewThrottle(cfg.LoginThrottle),
+		assets:    staticAssets,
+		stopping:  new(atomic.Bool),
+		transfers: transfer.NewTracker(),
+	}, nil
+}
+
+// loadSecret returns the configured secret key, or the one generated in the
+// uploads directory when none is configured.
+func loadSecret(cfg *config.Config) ([]byte, error) {
+	if cfg.SecretKey != "" {
+		return []byte(cfg.SecretKey), nil
+	}
+	secret, err := storage.LoadOrCreateSecret(cfg.UseDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret key: %w", err)
+	}
+	return secret, nil
+}
+
+// handler returns the complete HTTP handler with every route mounted below
+// the configured base URL.
+func (a *app) handler() http.Handler {
+	var h http.Handler = metrics.Middleware(a.routes())
+
+	if base := a.cfg.BaseUrl; base != "" {
+		root := http.NewServeMux()
+		root.Handle(base+"/", http.StripPrefix(base, h))
+		root.Handle(base, http.RedirectHandler(base+"/", http.StatusMovedPermanently))
+		h = root
+	}
+
+	if a.cfg.HSTSMaxAge > 0 {
+		h = hstsMiddleware(a.cfg, h)
+	}
+	if a.cfg.ClientCertsEnabled() {
+		h = auth.CertMiddleware(a.cfg, h)
+	}
+
+	return a.resolver.Middleware(logging.Middleware(a.sessions.Middleware(a.cfg, a.users, h)))
+}
+
+// routes registers the application routes relative to the base URL.
+func (a *app) routes() *http.ServeMux {
+	cfg, tmpl := a.cfg, a.tmpl
+	shares, sessions, users, throttle := a.shares, a.sessions, a.users, a.throttle
+	transfers := a.transfers
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /", handlers.IndexHandler(tmpl, cfg, sessions))
+	mux.HandleFunc("GET /healthz", handlers.HealthzHandler)
+	mux.HandleFunc("GET /readyz", handlers.ReadyzHandler(cfg, a.stopping))
+	mux.HandleFunc("GET /version", handlers.VersionHandler)
+	mux.HandleFunc("GET /upload", handlers.UploadHandler(tmpl, cfg, sessions))
+	mux.HandleFunc("GET /error", handlers.ErrorHandler(tmpl, cfg, sessions))
+	// Deprecated in favour of the versioned API, still used by the web
+	// interface.
+	mux.HandleFunc("POST /api/archive/create", handlers.Deprecated(cfg, "/archives", handlers.RefuseWhileStopping(a.stopping, func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateArchiveHandler(w, r, cfg, sessions, throttle, transfers)
+	})))
+	mux.HandleFunc("GET /api/archives", handlers.Deprecated(cfg, "/archives", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListArchivesHandler(w, r, cfg)
+	}))
+	mux.HandleFunc("GET /api/archive/{id}", handlers.Deprecated(cfg, "/archives/{id}/file", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DownloadArchiveHandler(w, r, cfg, sessions, transfers)
+	}))
+	mux.HandleFunc("POST /api/archive/{id}/share", func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateShareHandler(w, r, cfg, shares, throttle)
+	})
+	mux.HandleFunc("GET /api/shares", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListSharesHandler(w, r, cfg, shares, throttle)
+	})
+	mux.HandleFunc("DELETE /api/share/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeShareHandler(w, r, cfg, shares, throttle)
+	})
+	a.apiRoutes(mux)
+	mux.HandleFunc("GET /share/{id}", handlers.ShareDownloadHandler(tmpl, cfg, sessions, shares, throttle, transfers))
+	mux.HandleFunc("POST /share/{id}", handlers.ShareDownloadHandler(tmpl, cfg, sessions, shares, throttle, transfers))
+	if cfg.LoginEnabled() {
+		mux.HandleFunc("GET /login", handlers.LoginHandler(tmpl, cfg, sessions))
+		mux.HandleFunc("GET /logout", func(w http.ResponseWriter, r *http.Request) {
+			handlers.LogoutHandler(w, r, cfg, sessions)
+		})
+	}
+	if cfg.OIDC.Enabled() {
+		slog.Info("OIDC login enabled", "issuer", cfg.OIDC.Issuer)
+		oidc := auth.NewOIDCProvider(cfg.OIDC)
+		mux.HandleFunc("GET /auth/oidc/login", func(w http.ResponseWriter, r *http.Request) {
+			handlers.OIDCLoginHandler(w, r, cfg, oidc, sessions)
+		})
+		mux.HandleFunc("GET /auth/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+			handlers.OIDCCallbackHandler(w, r, cfg, oidc, sessions, users)
+		})
+	}
+	if cfg.LDAP.Enabled() {
+		slog.Info("LDAP login enabled", "server", cfg.LDAP.URL)
+		ldap := auth.NewLDAPProvider(cfg.LDAP)
+		mux.HandleFunc("POST /auth/ldap/login", func(w http.ResponseWriter, r *http.Request) {
+			handlers.LDAPLoginHandler(w, r, cfg, ldap, sessions, users, throttle)
+		})
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Listen == "" {
+		mux.HandleFunc("GET /metrics", handlers.MetricsHandler(cfg))
+	}
+	mux.Handle("GET "+assets.URLPrefix, http.StripPrefix(assets.URLPrefix, a.assets))
+
+	return mux
+}
+
+// apiRoutes registers the versioned API below handlers.APIPrefix.
+func (a *app) apiRoutes(mux *http.ServeMux) {
+	cfg, shares, throttle, transfers := a.cfg, a.shares, a.throttle, a.transfers
+	prefix := handlers.APIPrefix
+
+	mux.HandleFunc("GET "+prefix+"/openapi.json", handlers.OpenAPIHandler(cfg))
+	mux.HandleFunc("GET "+prefix+"/archives", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListArchivesHandler(w, r, cfg)
+	})
+	mux.HandleFunc("POST "+prefix+"/archives", handlers.RefuseWhileStopping(a.stopping, func(w http.ResponseWriter, r *http.Request) {
+		handlers.UploadArchiveHandler(w, r, cfg, throttle, transfers)
+	}))
+	mux.HandleFunc("GET "+prefix+"/archives/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetArchiveHandler(w, r, cfg)
+	})
+	mux.HandleFunc("PATCH "+prefix+"/archives/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateArchiveHandler(w, r, cfg, throttle)
+	})
+	mux.HandleFunc("DELETE "+prefix+"/archives/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteArchiveHandler(w, r, cfg, shares, throttle)
+	})
+	mux.HandleFunc("GET "+prefix+"/archives/{id}/file", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ArchiveFileHandler(w, r, cfg, transfers)
+	})
+	mux.HandleFunc("GET "+prefix+"/", handlers.APINotFoundHandler)
+}
+
+// metricsHandler returns the handler of the separate metrics listener.
+func (a *app) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	if a.cfg.Metrics.Enabled {
+		mux.HandleFunc("GET /metrics", handlers.MetricsHandler(a.cfg))
+	}
+	return mux
+}
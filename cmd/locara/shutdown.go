@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Firstbober/locara/internal/storage"
+	"github.com/Firstbober/locara/internal/transfer"
+)
+
+const (
+	// drainReportInterval is how often the progress of running transfers is
+	// logged while shutdown waits for them.
+	drainReportInterval = 5 * time.Second
+
+	// abortGrace is how long aborted transfers get to clean up before exit.
+	abortGrace = 5 * time.Second
+)
+
+// gracefulShutdown waits for SIGINT or SIGTERM, fails readiness checks for
+// the configured shutdown delay, refuses new uploads and then stops the
+// servers, waiting up to the shutdown timeout for running transfers. When
+// they do not finish in time, or a second signal arrives, the connections are
+// closed and unfinished uploads removed.
+func gracefulShutdown(rl *reloader, servers ...*http.Server) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-sigChan
+	slog.Info("Received signal, shutting down", "signal", sig.String())
+
+	a := rl.current()
+	a.stopping.Store(true)
+	if delay := a.cfg.ShutdownDelay; delay > 0 {
+		slog.Info("Failing readiness checks before stopping", "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-sigChan:
+			slog.Warn("Received second signal, skipping shutdown delay")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-sigChan:
+			slog.Warn("Received second signal, aborting running transfers")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go reportDrain(ctx, a.transfers)
+
+	forced := false
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			forced = true
+		}
+	}
+
+	if !forced {
+		slog.Info("Server stopped gracefully")
+		return
+	}
+
+	slog.Warn("Shutdown timeout reached, aborting running transfers", "active", a.transfers.Len())
+	for _, server := range servers {
+		server.Close()
+	}
+
+	// Closing the connections makes the running handlers fail, and failed
+	// uploads remove what they have written so far.
+	graceCtx, graceCancel := context.WithTimeout(context.Background(), abortGrace)
+	defer graceCancel()
+	if err := a.transfers.Wait(graceCtx); err != nil {
+		slog.Error("Transfers still running at exit", "active", a.transfers.Len())
+	}
+	if n, err := storage.RemoveStaging(a.cfg.UseDirectory); err != nil {
+		slog.Error("Failed to remove unfinished uploads", "error", err)
+	} else if n > 0 {
+		slog.Info("Removed unfinished uploads", "count", n)
+	}
+
+	os.Exit(1)
+}
+
+// reportDrain logs the progress of running transfers until ctx is done or
+// none are left.
+func reportDrain(ctx context.Context, transfers *transfer.Tracker) {
+	ticker := time.NewTicker(drainReportInterval)
+	defer ticker.Stop()
+
+	for {
+		active := transfers.Active()
+		if len(active) == 0 {
+			return
+		}
+
+		slog.Info("Waiting for transfers to finish", "active", len(active))
+		for _, p := range active {
+			slog.Info("Transfer in progress",
+				"direction", p.Direction,
+				"name", p.Name,
+				"bytes", p.Bytes,
+				"size", p.Size,
+				"elapsed", p.Elapsed.Round(time.Second),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	// URLPrefix is the path static assets are served under.
+	URLPrefix = "/static/"
+
+	hashLength = 12
+
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+)
+
+// Assets serves static files and maps their names to fingerprinted URLs, so
+// browsers can cache them forever and still pick up every change.
+type Assets struct {
+	fsys fs.FS
+	dev  bool
+
+	fingerprinted map[string]string // original name -> fingerprinted name
+	originals     map[string]string // fingerprinted name -> original name
+}
+
+// New indexes the files in fsys. In dev mode nothing is fingerprinted and the
+// files are revalidated on every request, which suits serving from disk
+// while editing.
+func New(fsys fs.FS, dev bool) (*Assets, error) {
+	a := &Assets{
+		fsys:          fsys,
+		dev:           dev,
+		fingerprinted: make(map[string]string),
+		originals:     make(map[string]string),
+	}
+	if dev {
+		return a, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		hashed := fingerprintName(name, hex.EncodeToString(sum[:])[:hashLength])
+		a.fingerprinted[name] = hashed
+		a.originals[hashed] = name
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index static assets: %w", err)
+	}
+
+	return a, nil
+}
+
+// Path returns the URL path of the named asset relative to the base URL,
+// e.g. "/static/css/style.3f2a9c81d0e4.css".
+func (a *Assets) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if hashed, ok := a.fingerprinted[name]; ok {
+		return URLPrefix + hashed
+	}
+	return URLPrefix + name
+}
+
+// ServeHTTP serves the asset named by the request path, which must already
+// have URLPrefix stripped.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+	cacheControl := revalidateCacheControl
+	if original, ok := a.originals[name]; ok {
+		name = original
+		cacheControl = immutableCacheControl
+	}
+
+	info, err := fs.Stat(a.fsys, name)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeFileFS(w, r, a.fsys, name)
+}
+
+// fingerprintName inserts the hash before the file extension.
+func fingerprintName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
@@ -0,0 +1,77 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"css/style.css": {Data: []byte("body { color: red; }")},
+		"js/app.js":     {Data: []byte("console.log('hi');")},
+	}
+}
+
+func serve(t *testing.T, a *Assets, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	http.StripPrefix(URLPrefix, a).ServeHTTP(w, r)
+
+	return w
+}
+
+func TestFingerprintedAssets(t *testing.T) {
+	a, err := New(testFS(), false)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	path := a.Path("css/style.css")
+	if !strings.HasPrefix(path, "/static/css/style.") || !strings.HasSuffix(path, ".css") || path == "/static/css/style.css" {
+		t.Fatalf("Path() = %q, want fingerprinted stylesheet", path)
+	}
+
+	w := serve(t, a, path)
+	if w.Code != http.StatusOK || w.Body.String() != "body { color: red; }" {
+		t.Errorf("GET %s = %d %q", path, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Cache-Control"); got != immutableCacheControl {
+		t.Errorf("GET %s Cache-Control = %q, want %q", path, got, immutableCacheControl)
+	}
+
+	w = serve(t, a, "/static/css/style.css")
+	if w.Code != http.StatusOK {
+		t.Errorf("GET unversioned asset status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Cache-Control"); got != revalidateCacheControl {
+		t.Errorf("GET unversioned asset Cache-Control = %q, want %q", got, revalidateCacheControl)
+	}
+
+	for _, missing := range []string{"/static/css/", "/static/css/nope.css", "/static/../static.go"} {
+		if w := serve(t, a, missing); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want %d", missing, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestDevModeAssets(t *testing.T) {
+	fsys := testFS()
+	a, err := New(fsys, true)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if got := a.Path("js/app.js"); got != "/static/js/app.js" {
+		t.Errorf("Path() in dev mode = %q, want %q", got, "/static/js/app.js")
+	}
+
+	fsys["js/app.js"] = &fstest.MapFile{Data: []byte("edited")}
+	if w := serve(t, a, "/static/js/app.js"); w.Body.String() != "edited" {
+		t.Errorf("GET in dev mode = %q, want live edited content", w.Body.String())
+	}
+}
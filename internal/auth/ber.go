@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Minimal BER encoding as used by the LDAP wire protocol (RFC 4511). Only the
+// definite length form is supported, which is all LDAP allows.
+
+// BER identifier octets used by LDAP.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berBoolean     = 0x01
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	berClassApplication = 0x40
+	berClassContext     = 0x80
+	berConstructed      = 0x20
+)
+
+const berMaxLength = 16 << 20
+
+var errBERTruncated = errors.New("ber: truncated element")
+
+// berElement is one decoded tag-length-value triple.
+type berElement struct {
+	Tag     byte
+	Content []byte
+}
+
+func berAppendLength(dst []byte, n int) []byte {
+	if n < 0x80 {
+		return append(dst, byte(n))
+	}
+
+	var buf [8]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = byte(n)
+		n >>= 8
+	}
+	dst = append(dst, 0x80|byte(len(buf)-i))
+	return append(dst, buf[i:]...)
+}
+
+func berTLV(tag byte, content ...[]byte) []byte {
+	size := 0
+	for _, c := range content {
+		size += len(c)
+	}
+
+	out := make([]byte, 0, size+6)
+	out = append(out, tag)
+	out = berAppendLength(out, size)
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+func berInt(tag byte, v int64) []byte {
+	var buf []byte
+	for {
+		buf = append([]byte{byte(v)}, buf...)
+		v >>= 8
+		if (v == 0 && buf[0]&0x80 == 0) || (v == -1 && buf[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berTLV(tag, buf)
+}
+
+func berString(tag byte, s string) []byte {
+	return berTLV(tag, []byte(s))
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return berTLV(berBoolean, []byte{0xff})
+	}
+	return berTLV(berBoolean, []byte{0x00})
+}
+
+// berParse splits the first element off data.
+func berParse(data []byte) (berElement, []byte, error) {
+	if len(data) < 2 {
+		return berElement{}, nil, errBERTruncated
+	}
+
+	tag := data[0]
+	n, hdr, err := berParseLength(data[1:])
+	if err != nil {
+		return berElement{}, nil, err
+	}
+
+	start := 1 + hdr
+	if len(data)-start < n {
+		return berElement{}, nil, errBERTruncated
+	}
+
+	return berElement{Tag: tag, Content: data[start : start+n]}, data[start+n:], nil
+}
+
+// berChildren decodes all elements contained in a constructed element.
+func berChildren(content []byte) ([]berElement, error) {
+	var children []berElement
+	for len(content) > 0 {
+		el, rest, err := berParse(content)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, el)
+		content = rest
+	}
+	return children, nil
+}
+
+func berParseLength(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errBERTruncated
+	}
+
+	first := data[0]
+	if first < 0x80 {
+		return int(first), 1, nil
+	}
+
+	octets := int(first & 0x7f)
+	if octets == 0 || octets > 4 {
+		return 0, 0, fmt.Errorf("ber: unsupported length encoding")
+	}
+	if len(data) < 1+octets {
+		return 0, 0, errBERTruncated
+	}
+
+	n := 0
+	for _, b := range data[1 : 1+octets] {
+		n = n<<8 | int(b)
+	}
+	if n > berMaxLength {
+		return 0, 0, fmt.Errorf("ber: element too large (%d bytes)", n)
+	}
+
+	return n, 1 + octets, nil
+}
+
+// berReadElement reads one complete top level element from r.
+func berReadElement(r *bufio.Reader) (berElement, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+
+	first, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+
+	lenBytes := []byte{first}
+	if first >= 0x80 {
+		extra := make([]byte, first&0x7f)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return berElement{}, err
+		}
+		lenBytes = append(lenBytes, extra...)
+	}
+
+	n, _, err := berParseLength(lenBytes)
+	if err != nil {
+		return berElement{}, err
+	}
+
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return berElement{}, err
+	}
+
+	return berElement{Tag: tag, Content: content}, nil
+}
+
+func berParseInt(content []byte) int64 {
+	var v int64
+	for i, b := range content {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/metrics"
+)
+
+// ProviderClientCert marks identities authenticated by a TLS client certificate.
+const ProviderClientCert = "client_cert"
+
+// CertIdentity maps the verified client certificate of a TLS connection to
+// the configured user whose cert_subject matches the certificate subject.
+func CertIdentity(cfg *config.Config, state *tls.ConnectionState) (*Identity, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	subject := state.VerifiedChains[0][0].Subject.String()
+	for i := range cfg.Users {
+		user := &cfg.Users[i]
+		if user.CertSubject != "" && strings.EqualFold(user.CertSubject, subject) {
+			id := FromUser(user)
+			id.Provider = ProviderClientCert
+			return id, true
+		}
+	}
+
+	slog.Warn("Client certificate does not map to any user", "audit", true, "subject", subject)
+	metrics.AuthFailures.With(ProviderClientCert).Inc()
+	return nil, false
+}
+
+// CertMiddleware authenticates requests without a login session by their
+// client certificate. Browsers present the certificate on every request,
+// including ones forged by other sites, so cross-origin requests with unsafe
+// methods are left unauthenticated.
+func CertMiddleware(cfg *config.Config, next http.Handler) http.Handler {
+	sameOrigin := http.NewCrossOriginProtection()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := IdentityFromContext(r.Context()); !ok {
+			if err := sameOrigin.Check(r); err != nil {
+				slog.WarnContext(r.Context(), "Ignoring client certificate on cross-origin request", "error", err)
+			} else if id, ok := CertIdentity(cfg, r.TLS); ok {
+				r = r.WithContext(WithIdentity(r.Context(), id))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+func TestCertIdentity(t *testing.T) {
+	cfg := &config.Config{Users: []config.User{
+		{Name: "alice", Role: config.RoleAdmin, CertSubject: "CN=alice,O=Example"},
+		{Name: "bob", Role: config.RoleUploader},
+	}}
+
+	state := func(subject pkix.Name) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+	}
+
+	id, ok := CertIdentity(cfg, state(pkix.Name{CommonName: "alice", Organization: []string{"Example"}}))
+	if !ok || id.Name != "alice" || id.Role != config.RoleAdmin || id.Provider != ProviderClientCert {
+		t.Errorf("CertIdentity() = %+v, %v, want alice as admin", id, ok)
+	}
+
+	if _, ok := CertIdentity(cfg, state(pkix.Name{CommonName: "mallory"})); ok {
+		t.Errorf("CertIdentity() mapped an unknown subject")
+	}
+	if _, ok := CertIdentity(cfg, &tls.ConnectionState{}); ok {
+		t.Errorf("CertIdentity() mapped a connection without a verified certificate")
+	}
+	if _, ok := CertIdentity(cfg, nil); ok {
+		t.Errorf("CertIdentity() mapped a plain HTTP request")
+	}
+}
+
+func TestCertMiddlewareIgnoresCrossOrigin(t *testing.T) {
+	cfg := &config.Config{Users: []config.User{
+		{Name: "alice", Role: config.RoleAdmin, CertSubject: "CN=alice"},
+	}}
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}}}
+
+	serve := func(method string, header map[string]string) bool {
+		t.Helper()
+		var authenticated bool
+		h := CertMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, authenticated = IdentityFromContext(r.Context())
+		}))
+		req := httptest.NewRequest(method, "https://locara.example.org/api/archive/create", nil)
+		req.TLS = state
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return authenticated
+	}
+
+	if !serve(http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site"}) {
+		t.Errorf("cross-site GET was not authenticated")
+	}
+	if !serve(http.MethodPost, nil) {
+		t.Errorf("POST from a non-browser client was not authenticated")
+	}
+	if !serve(http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin"}) {
+		t.Errorf("same-origin POST was not authenticated")
+	}
+	if serve(http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}) {
+		t.Errorf("cross-site POST was authenticated")
+	}
+	if serve(http.MethodPost, map[string]string{"Origin": "https://evil.example.com"}) {
+		t.Errorf("POST from another origin was authenticated")
+	}
+}
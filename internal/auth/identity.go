@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/logging"
+)
+
+// Providers an identity can originate from.
+const (
+	ProviderAuthCode = "auth_code"
+	ProviderOIDC     = "oidc"
+)
+
+var (
+	// ErrNoRole is returned when an external user maps to no Locara role.
+	ErrNoRole = errors.New("user has no Locara role")
+	// ErrNotProvisioned is returned when an unknown external user logs in
+	// while auto-provisioning is disabled.
+	ErrNotProvisioned = errors.New("user is not provisioned")
+	// ErrNameTaken is returned when an external user's name belongs to a
+	// configured user or another external account.
+	ErrNameTaken = errors.New("user name is taken by another account")
+)
+
+// Identity is an authenticated Locara user.
+type Identity struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Provider string `json:"provider"`
+	// Subject is the stable ID of an external account, empty for users
+	// logged in with an auth code or certificate.
+	Subject string `json:"subject,omitempty"`
+	// Credential fingerprints the auth code a session was issued for, so
+	// rotating the code ends the session.
+	Credential string `json:"credential,omitempty"`
+
+	// authCode is the auth code of a configured user, never stored.
+	authCode string
+}
+
+// IsAdmin reports whether the identity holds the admin role.
+func (id *Identity) IsAdmin() bool {
+	return id.Role == config.RoleAdmin
+}
+
+// External describes a user authenticated by an external identity provider.
+type External struct {
+	Provider string
+	Subject  string
+	Name     string
+	Groups   []string
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity, which is also
+// recorded as the user in the request log.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	if id != nil {
+		logging.SetUser(ctx, id.Name)
+	}
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx, if any.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// FromUser builds the identity of a user configured in [[users]].
+func FromUser(user *config.User) *Identity {
+	role := user.Role
+	if role == "" {
+		role = config.RoleUploader
+	}
+	return &Identity{Name: user.Name, Role: role, Provider: ProviderAuthCode, authCode: user.Auth}
+}
+
+// MapRole returns the most privileged role any of the groups maps to,
+// falling back to the default role of the mapping.
+func MapRole(mapping config.RoleMapping, groups []string) string {
+	role := ""
+	for _, group := range groups {
+		role = higherRole(role, mapping.Roles[group])
+	}
+	if role == "" {
+		role = mapping.DefaultRole
+	}
+	return role
+}
+
+// Resolve maps an externally authenticated user onto a Locara identity.
+// Accounts are told apart by provider and subject, never by name. Users
+// linked to the account in [[users]] keep their configured role unless their
+// groups grant a higher one. Other users get the role their groups map to on
+// every login and must either be provisioned already or are provisioned now
+// when the mapping allows it.
+func Resolve(cfg *config.Config, users *UserStore, mapping config.RoleMapping, ext External) (*Identity, error) {
+	if ext.Subject == "" {
+		return nil, errors.New("identity provider returned no subject")
+	}
+	role := MapRole(mapping, ext.Groups)
+
+	if user := LinkedUser(cfg, ext.Provider, ext.Subject); user != nil {
+		role = higherRole(role, FromUser(user).Role)
+		return &Identity{Name: user.Name, Role: role, Provider: ext.Provider, Subject: ext.Subject}, nil
+	}
+
+	_, known := users.Get(ext.Provider, ext.Subject)
+	if role == "" {
+		// Keep the account but take away the role it had.
+		if known {
+			if err := users.Record(ext, ""); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrNoRole
+	}
+	if !known && !mapping.AutoProvision {
+		return nil, ErrNotProvisioned
+	}
+	if nameTaken(cfg, users, ext) {
+		return nil, ErrNameTaken
+	}
+
+	if err := users.Record(ext, role); err != nil {
+		return nil, err
+	}
+
+	return &Identity{Name: ext.Name, Role: role, Provider: ext.Provider, Subject: ext.Subject}, nil
+}
+
+// Current checks a session identity against the current configuration and
+// user store and returns it with the role it holds now. It fails when the
+// user, its login provider or its role no longer exists, so a reload that
+// removes a user or lowers a role applies to running sessions too. Sessions
+// of auth code users are also checked against the current code, see
+// Sessions.Middleware.
+func Current(cfg *config.Config, users *UserStore, id *Identity) (*Identity, bool) {
+	var mapping config.RoleMapping
+	switch {
+	case id.Provider == ProviderAuthCode:
+		for i := range cfg.Users {
+			if cfg.Users[i].Name == id.Name {
+				return FromUser(&cfg.Users[i]), true
+			}
+		}
+		return nil, false
+	case id.Provider == ProviderOIDC && cfg.OIDC.Enabled():
+		mapping = cfg.OIDC.RoleMapping
+	case id.Provider == ProviderLDAP && cfg.LDAP.Enabled():
+		mapping = cfg.LDAP.RoleMapping
+	default:
+		return nil, false
+	}
+	if id.Subject == "" {
+		return nil, false
+	}
+
+	if user := LinkedUser(cfg, id.Provider, id.Subject); user != nil {
+		role := FromUser(user).Role
+		// A higher role granted by groups at login is kept while the
+		// mapping still grants it.
+		if grantsRole(mapping, id.Role) {
+			role = higherRole(role, id.Role)
+		}
+		return &Identity{Name: user.Name, Role: role, Provider: id.Provider, Subject: id.Subject}, true
+	}
+
+	user, ok := users.Get(id.Provider, id.Subject)
+	if !ok || !grantsRole(mapping, user.Role) || nameTaken(cfg, users, External{Provider: user.Provider, Subject: user.Subject, Name: user.Name}) {
+		return nil, false
+	}
+	return &Identity{Name: user.Name, Role: user.Role, Provider: id.Provider, Subject: id.Subject}, true
+}
+
+// grantsRole reports whether some group, or the default, maps to role.
+func grantsRole(mapping config.RoleMapping, role string) bool {
+	if role == "" {
+		return false
+	}
+	if mapping.DefaultRole == role {
+		return true
+	}
+	for _, r := range mapping.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkedUser returns the user in [[users]] linked to the external account
+// with the given provider and subject.
+func LinkedUser(cfg *config.Config, provider, subject string) *config.User {
+	for i := range cfg.Users {
+		if cfg.Users[i].Subject != "" && cfg.Users[i].Provider == provider && cfg.Users[i].Subject == subject {
+			return &cfg.Users[i]
+		}
+	}
+	return nil
+}
+
+// nameTaken reports whether the name of ext belongs to a configured user or
+// another external account, whose archives it would otherwise share.
+func nameTaken(cfg *config.Config, users *UserStore, ext External) bool {
+	for _, user := range cfg.Users {
+		if user.Name == ext.Name {
+			return true
+		}
+	}
+	for _, user := range users.List() {
+		if user.Name == ext.Name && (user.Provider != ext.Provider || user.Subject != ext.Subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func higherRole(a, b string) string {
+	if a == config.RoleAdmin || b == config.RoleAdmin {
+		return config.RoleAdmin
+	}
+	if a == config.RoleUploader || b == config.RoleUploader {
+		return config.RoleUploader
+	}
+	return ""
+}
@@ -0,0 +1,471 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+// ProviderLDAP marks identities authenticated by an LDAP bind.
+const ProviderLDAP = "ldap"
+
+// LDAP protocol operation tags (RFC 4511, section 4.2 onwards).
+const (
+	ldapBindRequest      = berClassApplication | berConstructed | 0
+	ldapBindResponse     = berClassApplication | berConstructed | 1
+	ldapUnbindRequest    = berClassApplication | 2
+	ldapSearchRequest    = berClassApplication | berConstructed | 3
+	ldapSearchEntry      = berClassApplication | berConstructed | 4
+	ldapSearchDone       = berClassApplication | berConstructed | 5
+	ldapSearchReference  = berClassApplication | berConstructed | 19
+	ldapExtendedRequest  = berClassApplication | berConstructed | 23
+	ldapExtendedResponse = berClassApplication | berConstructed | 24
+
+	ldapSimpleAuth   = berClassContext | 0
+	ldapExtendedName = berClassContext | 0
+
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+
+	ldapTimeout = 10 * time.Second
+
+	// ldapStartTLSOID names the StartTLS extended operation (RFC 4511,
+	// section 4.14).
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// ErrInvalidCredentials is returned when the directory rejects the password
+// or the user does not exist.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// LDAPProvider authenticates users by binding to an LDAP directory with
+// their credentials and maps their group membership to Locara roles.
+type LDAPProvider struct {
+	cfg config.LDAP
+}
+
+// ldapConn is a synchronous LDAP client connection handling one request at
+// a time.
+type ldapConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID int64
+}
+
+// ldapEntry is a search result with its attributes keyed by lowercase name.
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// LDAPError is a non-success result returned by the directory.
+type LDAPError struct {
+	Code    int64
+	Message string
+}
+
+func (e *LDAPError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.Code)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.Code, e.Message)
+}
+
+// NewLDAPProvider creates a provider for the given configuration.
+func NewLDAPProvider(cfg config.LDAP) *LDAPProvider {
+	return &LDAPProvider{cfg: cfg}
+}
+
+// Authenticate verifies the username and password against the directory and
+// returns the user with the groups they belong to.
+func (p *LDAPProvider) Authenticate(ctx context.Context, username, password string) (*External, error) {
+	// An empty password would turn the bind into an unauthenticated bind,
+	// which most servers accept.
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	var dn string
+	var entry *ldapEntry
+
+	if p.cfg.UserDN != "" {
+		dn = fmt.Sprintf(p.cfg.UserDN, escapeDNValue(username))
+		if err := conn.bind(dn, password); err != nil {
+			return nil, bindError(err)
+		}
+		entries, err := conn.search(dn, ldapScopeBase, "(objectClass=*)", p.userAttributes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user entry: %w", err)
+		}
+		if len(entries) != 1 {
+			return nil, errors.New("user entry not found after bind")
+		}
+		// The DN built from the typed username differs in case or
+		// escaping between logins; the directory's own form does not.
+		entry = &entries[0]
+		dn = entry.DN
+	} else {
+		if p.cfg.BindDN != "" {
+			if err := conn.bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+				return nil, fmt.Errorf("service account bind failed: %w", err)
+			}
+		}
+
+		filter := fmt.Sprintf(p.cfg.UserFilter, escapeFilterValue(username))
+		entries, err := conn.search(p.cfg.BaseDN, ldapScopeSubtree, filter, p.userAttributes())
+		if err != nil {
+			return nil, fmt.Errorf("user search failed: %w", err)
+		}
+		if len(entries) != 1 {
+			return nil, ErrInvalidCredentials
+		}
+		entry = &entries[0]
+		dn = entry.DN
+
+		if err := conn.bind(dn, password); err != nil {
+			return nil, bindError(err)
+		}
+	}
+
+	name := username
+	if v := entry.first(p.cfg.UsernameAttribute); v != "" {
+		name = v
+	}
+	var groups []string
+	for _, groupDN := range entry.Attributes[strings.ToLower(p.cfg.GroupAttribute)] {
+		groups = append(groups, groupNames(groupDN)...)
+	}
+
+	if p.cfg.GroupFilter != "" {
+		filter := fmt.Sprintf(p.cfg.GroupFilter, escapeFilterValue(dn))
+		base := p.cfg.GroupBaseDN
+		if base == "" {
+			base = p.cfg.BaseDN
+		}
+		entries, err := conn.search(base, ldapScopeSubtree, filter, []string{"cn"})
+		if err != nil {
+			return nil, fmt.Errorf("group search failed: %w", err)
+		}
+		for _, group := range entries {
+			groups = append(groups, groupNames(group.DN)...)
+		}
+	}
+
+	return &External{
+		Provider: ProviderLDAP,
+		Subject:  dn,
+		Name:     name,
+		Groups:   groups,
+	}, nil
+}
+
+func (p *LDAPProvider) userAttributes() []string {
+	return []string{p.cfg.UsernameAttribute, p.cfg.GroupAttribute}
+}
+
+func (p *LDAPProvider) dial(ctx context.Context) (*ldapConn, error) {
+	u, err := url.Parse(p.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: p.cfg.InsecureSkipVerify,
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+
+	deadline := time.Now().Add(ldapTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+	if u.Scheme == "ldap" && p.cfg.StartTLS {
+		if err := c.startTLS(ctx, tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// startTLS upgrades the connection to TLS before any credentials are sent.
+func (c *ldapConn) startTLS(ctx context.Context, config *tls.Config) error {
+	id, err := c.send(berTLV(ldapExtendedRequest, berString(ldapExtendedName, ldapStartTLSOID)))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.Tag != ldapExtendedResponse {
+		return fmt.Errorf("unexpected LDAP response tag 0x%02x", op.Tag)
+	}
+	if err := parseLDAPResult(op.Content); err != nil {
+		return fmt.Errorf("LDAP server refused StartTLS: %w", err)
+	}
+	if c.r.Buffered() > 0 {
+		return errors.New("unexpected LDAP data before TLS handshake")
+	}
+
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("LDAP StartTLS handshake failed: %w", err)
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+
+	return nil
+}
+
+func (c *ldapConn) close() {
+	c.nextID++
+	c.conn.Write(berTLV(berSequence, berInt(berInteger, c.nextID), berTLV(ldapUnbindRequest)))
+	c.conn.Close()
+}
+
+func (c *ldapConn) send(op []byte) (int64, error) {
+	c.nextID++
+	msg := berTLV(berSequence, berInt(berInteger, c.nextID), op)
+	if _, err := c.conn.Write(msg); err != nil {
+		return 0, fmt.Errorf("failed to send LDAP request: %w", err)
+	}
+	return c.nextID, nil
+}
+
+// receive reads the next message for the given request and returns its
+// protocol operation.
+func (c *ldapConn) receive(id int64) (berElement, error) {
+	for {
+		msg, err := berReadElement(c.r)
+		if err != nil {
+			return berElement{}, fmt.Errorf("failed to read LDAP response: %w", err)
+		}
+		if msg.Tag != berSequence {
+			return berElement{}, fmt.Errorf("unexpected LDAP message tag 0x%02x", msg.Tag)
+		}
+
+		parts, err := berChildren(msg.Content)
+		if err != nil || len(parts) < 2 {
+			return berElement{}, fmt.Errorf("malformed LDAP message")
+		}
+		if berParseInt(parts[0].Content) != id {
+			continue
+		}
+
+		return parts[1], nil
+	}
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(berTLV(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapSimpleAuth, password),
+	))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.Tag != ldapBindResponse {
+		return fmt.Errorf("unexpected LDAP response tag 0x%02x", op.Tag)
+	}
+
+	return parseLDAPResult(op.Content)
+}
+
+func (c *ldapConn) search(base string, scope int64, filter string, attributes []string) ([]ldapEntry, error) {
+	encodedFilter, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make([][]byte, 0, len(attributes))
+	for _, attr := range attributes {
+		if attr != "" {
+			attrs = append(attrs, berString(berOctetString, attr))
+		}
+	}
+
+	id, err := c.send(berTLV(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, 0),
+		berInt(berInteger, 0),
+		berInt(berInteger, int64(ldapTimeout/time.Second)),
+		berBool(false),
+		encodedFilter,
+		berTLV(berSequence, attrs...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Tag {
+		case ldapSearchEntry:
+			entry, err := parseSearchEntry(op.Content)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchReference:
+			continue
+		case ldapSearchDone:
+			if err := parseLDAPResult(op.Content); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("unexpected LDAP response tag 0x%02x", op.Tag)
+		}
+	}
+}
+
+func parseLDAPResult(content []byte) error {
+	parts, err := berChildren(content)
+	if err != nil || len(parts) < 3 {
+		return fmt.Errorf("malformed LDAP result")
+	}
+
+	code := berParseInt(parts[0].Content)
+	if code == ldapResultSuccess {
+		return nil
+	}
+
+	return &LDAPError{Code: code, Message: string(parts[2].Content)}
+}
+
+func parseSearchEntry(content []byte) (ldapEntry, error) {
+	parts, err := berChildren(content)
+	if err != nil || len(parts) < 2 {
+		return ldapEntry{}, fmt.Errorf("malformed LDAP search entry")
+	}
+
+	entry := ldapEntry{
+		DN:         string(parts[0].Content),
+		Attributes: make(map[string][]string),
+	}
+
+	attrs, err := berChildren(parts[1].Content)
+	if err != nil {
+		return ldapEntry{}, fmt.Errorf("malformed LDAP attributes: %w", err)
+	}
+	for _, attr := range attrs {
+		fields, err := berChildren(attr.Content)
+		if err != nil || len(fields) < 2 {
+			return ldapEntry{}, fmt.Errorf("malformed LDAP attribute")
+		}
+		values, err := berChildren(fields[1].Content)
+		if err != nil {
+			return ldapEntry{}, fmt.Errorf("malformed LDAP attribute values: %w", err)
+		}
+		name := strings.ToLower(string(fields[0].Content))
+		for _, v := range values {
+			entry.Attributes[name] = append(entry.Attributes[name], string(v.Content))
+		}
+	}
+
+	return entry, nil
+}
+
+func (e *ldapEntry) first(attr string) string {
+	if values := e.Attributes[strings.ToLower(attr)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func bindError(err error) error {
+	var ldapErr *LDAPError
+	if errors.As(err, &ldapErr) && ldapErr.Code == ldapResultInvalidCredentials {
+		return ErrInvalidCredentials
+	}
+	return fmt.Errorf("LDAP bind failed: %w", err)
+}
+
+// groupNames returns the names a group can be referred to by in the role
+// mapping: its full DN and, when present, its first CN.
+func groupNames(dn string) []string {
+	names := []string{dn}
+	first, _, _ := strings.Cut(dn, ",")
+	if key, value, ok := strings.Cut(first, "="); ok && strings.EqualFold(strings.TrimSpace(key), "cn") {
+		names = append(names, strings.TrimSpace(value))
+	}
+	return names
+}
+
+// escapeDNValue escapes a value for use as an attribute value in a DN
+// (RFC 4514).
+func escapeDNValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString("\\00")
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(s)-1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,402 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+// testLDAPEntry is an entry in the in-memory directory of testLDAPServer.
+type testLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// testLDAPServer is a minimal LDAP server supporting simple binds and
+// searches over a fixed in-memory directory. With tls set it supports
+// StartTLS and refuses binds before it.
+type testLDAPServer struct {
+	t        *testing.T
+	listener net.Listener
+	entries  []testLDAPEntry
+	tls      *tls.Config
+}
+
+func newTestLDAPServer(t *testing.T, entries []testLDAPEntry) *testLDAPServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	s := &testLDAPServer{t: t, listener: l, entries: entries}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+
+	return s
+}
+
+func (s *testLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testLDAPServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	secure := false
+
+	for {
+		msg, err := berReadElement(r)
+		if err != nil {
+			return
+		}
+		parts, err := berChildren(msg.Content)
+		if err != nil || len(parts) < 2 {
+			return
+		}
+		id := berParseInt(parts[0].Content)
+		op := parts[1]
+
+		reply := func(op []byte) {
+			conn.Write(berTLV(berSequence, berInt(berInteger, id), op))
+		}
+		result := func(tag byte, code int64) {
+			reply(berTLV(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, "")))
+		}
+
+		switch op.Tag {
+		case ldapUnbindRequest:
+			return
+		case ldapExtendedRequest:
+			fields, _ := berChildren(op.Content)
+			if s.tls == nil || secure || string(fields[0].Content) != ldapStartTLSOID {
+				result(ldapExtendedResponse, ldapResultProtocolError)
+				continue
+			}
+			result(ldapExtendedResponse, ldapResultSuccess)
+			conn = tls.Server(conn, s.tls)
+			r = bufio.NewReader(conn)
+			secure = true
+		case ldapBindRequest:
+			if s.tls != nil && !secure {
+				result(ldapBindResponse, ldapResultConfidentialityRequired)
+				continue
+			}
+			fields, _ := berChildren(op.Content)
+			dn, password := string(fields[1].Content), string(fields[2].Content)
+			if dn == "" && password == "" {
+				result(ldapBindResponse, ldapResultSuccess)
+				continue
+			}
+			code := int64(ldapResultInvalidCredentials)
+			for _, e := range s.entries {
+				if strings.EqualFold(e.dn, dn) && e.password != "" && e.password == password {
+					code = ldapResultSuccess
+				}
+			}
+			result(ldapBindResponse, code)
+		case ldapSearchRequest:
+			fields, _ := berChildren(op.Content)
+			base := strings.ToLower(string(fields[0].Content))
+			scope := berParseInt(fields[1].Content)
+			for _, e := range s.entries {
+				dn := strings.ToLower(e.dn)
+				inScope := dn == base
+				if scope == ldapScopeSubtree {
+					inScope = inScope || strings.HasSuffix(dn, ","+base)
+				}
+				if !inScope || !matchTestFilter(fields[6], e) {
+					continue
+				}
+				var attrs [][]byte
+				for name, values := range e.attrs {
+					var vals [][]byte
+					for _, v := range values {
+						vals = append(vals, berString(berOctetString, v))
+					}
+					attrs = append(attrs, berTLV(berSequence, berString(berOctetString, name), berTLV(berSet, vals...)))
+				}
+				reply(berTLV(ldapSearchEntry, berString(berOctetString, e.dn), berTLV(berSequence, attrs...)))
+			}
+			result(ldapSearchDone, ldapResultSuccess)
+		default:
+			return
+		}
+	}
+}
+
+// Result codes only the test server sends.
+const (
+	ldapResultProtocolError           = 2
+	ldapResultConfidentialityRequired = 13
+)
+
+// testTLSConfig returns a server config with a self-signed certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() failed: %v", err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func matchTestFilter(filter berElement, e testLDAPEntry) bool {
+	values := func(attr string) []string {
+		for name, v := range e.attrs {
+			if strings.EqualFold(name, attr) {
+				return v
+			}
+		}
+		return nil
+	}
+
+	switch filter.Tag {
+	case filterAnd, filterOr:
+		children, _ := berChildren(filter.Content)
+		for _, child := range children {
+			matched := matchTestFilter(child, e)
+			if filter.Tag == filterOr && matched {
+				return true
+			}
+			if filter.Tag == filterAnd && !matched {
+				return false
+			}
+		}
+		return filter.Tag == filterAnd
+	case filterNot:
+		children, _ := berChildren(filter.Content)
+		return !matchTestFilter(children[0], e)
+	case filterPresent:
+		return len(values(string(filter.Content))) > 0
+	case filterEqualityMatch:
+		fields, _ := berChildren(filter.Content)
+		want := string(fields[1].Content)
+		return slices.ContainsFunc(values(string(fields[0].Content)), func(v string) bool {
+			return strings.EqualFold(v, want)
+		})
+	default:
+		return false
+	}
+}
+
+func testDirectory() []testLDAPEntry {
+	return []testLDAPEntry{
+		{
+			dn:       "uid=alice,ou=people,dc=example,dc=org",
+			password: "wonderland",
+			attrs: map[string][]string{
+				"objectClass": {"inetOrgPerson"},
+				"uid":         {"alice"},
+				"memberOf":    {"cn=archivists,ou=groups,dc=example,dc=org"},
+			},
+		},
+		{
+			dn:       "uid=bob,ou=people,dc=example,dc=org",
+			password: "builder",
+			attrs: map[string][]string{
+				"objectClass": {"inetOrgPerson"},
+				"uid":         {"bob"},
+			},
+		},
+		{
+			dn:       "cn=service,dc=example,dc=org",
+			password: "service-secret",
+			attrs:    map[string][]string{"objectClass": {"person"}},
+		},
+		{
+			dn: "cn=it,ou=groups,dc=example,dc=org",
+			attrs: map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"cn":          {"it"},
+				"member":      {"uid=bob,ou=people,dc=example,dc=org"},
+			},
+		},
+	}
+}
+
+func TestLDAPAuthenticateDirectBind(t *testing.T) {
+	server := newTestLDAPServer(t, testDirectory())
+
+	provider := NewLDAPProvider(config.LDAP{
+		URL:               server.url(),
+		UserDN:            "uid=%s,ou=people,dc=example,dc=org",
+		UsernameAttribute: config.DefaultLDAPUsernameAttribute,
+		GroupAttribute:    config.DefaultLDAPGroupAttribute,
+	})
+
+	ext, err := provider.Authenticate(context.Background(), "alice", "wonderland")
+	if err != nil {
+		t.Fatalf("Authenticate() failed: %v", err)
+	}
+
+	if ext.Name != "alice" || ext.Provider != ProviderLDAP {
+		t.Errorf("Authenticate() = %+v, want alice via ldap", ext)
+	}
+	if !slices.Contains(ext.Groups, "archivists") {
+		t.Errorf("Authenticate().Groups = %v, want it to contain archivists", ext.Groups)
+	}
+
+	// The subject is the DN the directory returns, not the one built from
+	// what was typed.
+	upper, err := provider.Authenticate(context.Background(), "ALICE", "wonderland")
+	if err != nil {
+		t.Fatalf("Authenticate() with upper case username failed: %v", err)
+	}
+	if upper.Subject != "uid=alice,ou=people,dc=example,dc=org" || upper.Subject != ext.Subject {
+		t.Errorf("Authenticate().Subject = %q, want alice's DN", upper.Subject)
+	}
+
+	if _, err := provider.Authenticate(context.Background(), "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() with wrong password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := provider.Authenticate(context.Background(), "alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() with empty password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestLDAPAuthenticateSearchBind(t *testing.T) {
+	server := newTestLDAPServer(t, testDirectory())
+
+	provider := NewLDAPProvider(config.LDAP{
+		URL:               server.url(),
+		BindDN:            "cn=service,dc=example,dc=org",
+		BindPassword:      "service-secret",
+		BaseDN:            "dc=example,dc=org",
+		UserFilter:        "(&(objectClass=inetOrgPerson)(uid=%s))",
+		UsernameAttribute: config.DefaultLDAPUsernameAttribute,
+		GroupAttribute:    config.DefaultLDAPGroupAttribute,
+		GroupBaseDN:       "ou=groups,dc=example,dc=org",
+		GroupFilter:       "(&(objectClass=groupOfNames)(member=%s))",
+	})
+
+	ext, err := provider.Authenticate(context.Background(), "bob", "builder")
+	if err != nil {
+		t.Fatalf("Authenticate() failed: %v", err)
+	}
+
+	if ext.Subject != "uid=bob,ou=people,dc=example,dc=org" {
+		t.Errorf("Authenticate().Subject = %q, want bob's DN", ext.Subject)
+	}
+	if !slices.Contains(ext.Groups, "it") {
+		t.Errorf("Authenticate().Groups = %v, want it to contain it", ext.Groups)
+	}
+
+	if _, err := provider.Authenticate(context.Background(), "*", "builder"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() with wildcard user error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := provider.Authenticate(context.Background(), "nobody", "builder"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() for unknown user error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	mapping := config.RoleMapping{Roles: map[string]string{"it": config.RoleAdmin}, AutoProvision: true}
+	users, err := OpenUserStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenUserStore() failed: %v", err)
+	}
+
+	id, err := Resolve(&config.Config{}, users, mapping, *ext)
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+	if id.Name != "bob" || id.Role != config.RoleAdmin {
+		t.Errorf("Resolve() = %+v, want bob as admin", id)
+	}
+}
+
+func TestLDAPStartTLS(t *testing.T) {
+	server := newTestLDAPServer(t, testDirectory())
+	server.tls = testTLSConfig(t)
+
+	cfg := config.LDAP{
+		URL:                server.url(),
+		UserDN:             "uid=%s,ou=people,dc=example,dc=org",
+		UsernameAttribute:  config.DefaultLDAPUsernameAttribute,
+		GroupAttribute:     config.DefaultLDAPGroupAttribute,
+		InsecureSkipVerify: true,
+	}
+
+	var ldapErr *LDAPError
+	if _, err := NewLDAPProvider(cfg).Authenticate(context.Background(), "alice", "wonderland"); !errors.As(err, &ldapErr) || ldapErr.Code != ldapResultConfidentialityRequired {
+		t.Errorf("Authenticate() without StartTLS error = %v, want confidentiality required", err)
+	}
+
+	cfg.StartTLS = true
+	ext, err := NewLDAPProvider(cfg).Authenticate(context.Background(), "alice", "wonderland")
+	if err != nil {
+		t.Fatalf("Authenticate() with StartTLS failed: %v", err)
+	}
+	if ext.Name != "alice" {
+		t.Errorf("Authenticate() = %+v, want alice", ext)
+	}
+
+	cfg.InsecureSkipVerify = false
+	if _, err := NewLDAPProvider(cfg).Authenticate(context.Background(), "alice", "wonderland"); err == nil {
+		t.Error("Authenticate() with StartTLS to an untrusted server succeeded")
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	valid := []string{
+		"(uid=alice)",
+		"uid=alice",
+		"(&(objectClass=person)(|(uid=a*)(cn=*b*c)))",
+		"(!(uid=*))",
+		"(uidNumber>=1000)",
+		"(cn=a\\2ab)",
+	}
+	for _, f := range valid {
+		if _, err := compileFilter(f); err != nil {
+			t.Errorf("compileFilter(%q) failed: %v", f, err)
+		}
+	}
+
+	invalid := []string{"(uid=alice", "(&(uid=a)", "(=x)", "(uid=a)(uid=b)", "(cn=\\zz)"}
+	for _, f := range invalid {
+		if _, err := compileFilter(f); err == nil {
+			t.Errorf("compileFilter(%q) succeeded, want error", f)
+		}
+	}
+
+	if got := escapeFilterValue("a*(b)\\"); got != "a\\2a\\28b\\29\\5c" {
+		t.Errorf("escapeFilterValue() = %q", got)
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// LDAP filter choice tags (RFC 4511, section 4.5.1).
+const (
+	filterAnd            = berClassContext | berConstructed | 0
+	filterOr             = berClassContext | berConstructed | 1
+	filterNot            = berClassContext | berConstructed | 2
+	filterEqualityMatch  = berClassContext | berConstructed | 3
+	filterSubstrings     = berClassContext | berConstructed | 4
+	filterGreaterOrEqual = berClassContext | berConstructed | 5
+	filterLessOrEqual    = berClassContext | berConstructed | 6
+	filterPresent        = berClassContext | 7
+	filterApproxMatch    = berClassContext | berConstructed | 8
+
+	substringInitial = berClassContext | 0
+	substringAny     = berClassContext | 1
+	substringFinal   = berClassContext | 2
+)
+
+// escapeFilterValue escapes a value for safe use inside an LDAP filter
+// (RFC 4515), so user input cannot change the filter structure.
+func escapeFilterValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter converts the string representation of an LDAP filter into
+// its BER encoding.
+func compileFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+
+	encoded, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP filter %q: %w", filter, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid LDAP filter %q: trailing %q", filter, rest)
+	}
+
+	return encoded, nil
+}
+
+func parseFilter(s string) ([]byte, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("expected '('")
+	}
+	s = s[1:]
+
+	var encoded []byte
+	var err error
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		var children [][]byte
+		s = s[1:]
+		for len(s) > 0 && s[0] == '(' {
+			var child []byte
+			child, s, err = parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+		}
+		encoded = berTLV(tag, children...)
+	case '!':
+		var child []byte
+		child, s, err = parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		encoded = berTLV(filterNot, child)
+	default:
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return nil, "", fmt.Errorf("missing ')'")
+		}
+		encoded, err = parseFilterItem(s[:end])
+		if err != nil {
+			return nil, "", err
+		}
+		s = s[end:]
+	}
+
+	if len(s) == 0 || s[0] != ')' {
+		return nil, "", fmt.Errorf("missing ')'")
+	}
+
+	return encoded, s[1:], nil
+}
+
+func parseFilterItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+
+	attr := item[:eq]
+	value := item[eq+1:]
+
+	tag := byte(filterEqualityMatch)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApproxMatch, attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return berString(filterPresent, attr), nil
+	}
+
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		var subs [][]byte
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			unescaped, err := unescapeFilterValue(part)
+			if err != nil {
+				return nil, err
+			}
+			subTag := byte(substringAny)
+			switch i {
+			case 0:
+				subTag = substringInitial
+			case len(parts) - 1:
+				subTag = substringFinal
+			}
+			subs = append(subs, berString(subTag, unescaped))
+		}
+		return berTLV(filterSubstrings, berString(berOctetString, attr), berTLV(berSequence, subs...)), nil
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return berTLV(tag, berString(berOctetString, attr), berString(berOctetString, unescaped)), nil
+}
+
+func unescapeFilterValue(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		decoded, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider issuing RS256 signed
+// ID tokens for whatever claims the test hands it.
+type mockOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	m := &mockOIDCProvider{t: t, key: key, codes: make(map[string]mockAuthorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		authz, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authz.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(authz.claims),
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+// authorize simulates the user approving the login at the authorization
+// endpoint and returns the code the provider redirects back with.
+func (m *mockOIDCProvider) authorize(authURL string, claims map[string]any) (string, url.Values) {
+	m.t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatalf("Failed to parse authorization URL: %v", err)
+	}
+	query := u.Query()
+
+	full := map[string]any{
+		"iss":   m.server.URL,
+		"aud":   query.Get("client_id"),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	code := "code-" + query.Get("state")
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), claims: full}
+	m.mu.Unlock()
+
+	return code, query
+}
+
+func (m *mockOIDCProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (m *mockOIDCProvider) config() config.OIDC {
+	return config.OIDC{
+		Issuer:        m.server.URL,
+		ClientID:      "locara",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://locara.test/auth/oidc/callback",
+		UsernameClaim: config.DefaultOIDCUsernameClaim,
+		GroupsClaim:   config.DefaultOIDCGroupsClaim,
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(mock.config())
+	ctx := context.Background()
+
+	login, err := NewOIDCLogin()
+	if err != nil {
+		t.Fatalf("NewOIDCLogin() failed: %v", err)
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, login)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() failed: %v", err)
+	}
+
+	code, query := mock.authorize(authURL, map[string]any{
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"groups":             []string{"staff", "archivists"},
+	})
+
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want %q", query.Get("code_challenge_method"), "S256")
+	}
+	if !strings.Contains(query.Get("scope"), "openid") {
+		t.Errorf("scope = %q, want it to contain openid", query.Get("scope"))
+	}
+
+	ext, err := provider.Exchange(ctx, code, login)
+	if err != nil {
+		t.Fatalf("Exchange() failed: %v", err)
+	}
+
+	if ext.Name != "alice" || ext.Subject != "1234" || ext.Provider != ProviderOIDC {
+		t.Errorf("Exchange() = %+v, want alice/1234/oidc", ext)
+	}
+	if len(ext.Groups) != 2 || ext.Groups[1] != "archivists" {
+		t.Errorf("Exchange().Groups = %v, want [staff archivists]", ext.Groups)
+	}
+}
+
+func TestOIDCExchangeRejectsBadTokens(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := NewOIDCProvider(mock.config())
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+	}{
+		{"wrong audience", map[string]any{"aud": "someone-else"}},
+		{"wrong nonce", map[string]any{"nonce": "replayed"}},
+		{"expired", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{"wrong issuer", map[string]any{"iss": "https://evil.test"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, err := NewOIDCLogin()
+			if err != nil {
+				t.Fatalf("NewOIDCLogin() failed: %v", err)
+			}
+
+			authURL, err := provider.AuthCodeURL(ctx, login)
+			if err != nil {
+				t.Fatalf("AuthCodeURL() failed: %v", err)
+			}
+
+			claims := map[string]any{"sub": "1234", "preferred_username": "alice"}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			code, _ := mock.authorize(authURL, claims)
+
+			if _, err := provider.Exchange(ctx, code, login); err == nil {
+				t.Errorf("Exchange() succeeded, want error")
+			}
+		})
+	}
+
+	login, _ := NewOIDCLogin()
+	authURL, _ := provider.AuthCodeURL(ctx, login)
+	code, _ := mock.authorize(authURL, map[string]any{"sub": "1234", "preferred_username": "alice"})
+	login.Verifier = "tampered"
+	if _, err := provider.Exchange(ctx, code, login); err == nil {
+		t.Errorf("Exchange() with wrong PKCE verifier succeeded, want error")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cfg := &config.Config{
+		Users: []config.User{{Name: "bob", Auth: "code", Role: config.RoleUploader, Provider: ProviderOIDC, Subject: "sub-bob"}},
+	}
+	mapping := config.RoleMapping{
+		Roles: map[string]string{"archivists": config.RoleUploader, "it": config.RoleAdmin},
+	}
+
+	users, err := OpenUserStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenUserStore() failed: %v", err)
+	}
+
+	id, err := Resolve(cfg, users, mapping, External{Provider: ProviderOIDC, Subject: "sub-bob", Name: "robert", Groups: []string{"it"}})
+	if err != nil {
+		t.Fatalf("Resolve() for linked user failed: %v", err)
+	}
+	if id.Name != "bob" || id.Role != config.RoleAdmin {
+		t.Errorf("Resolve() = %+v, want bob as %q", id, config.RoleAdmin)
+	}
+
+	alice := External{Provider: ProviderOIDC, Subject: "sub-alice", Name: "alice", Groups: []string{"archivists"}}
+	if _, err := Resolve(cfg, users, mapping, alice); !errors.Is(err, ErrNotProvisioned) {
+		t.Errorf("Resolve() without auto-provisioning error = %v, want %v", err, ErrNotProvisioned)
+	}
+
+	mapping.AutoProvision = true
+	id, err = Resolve(cfg, users, mapping, alice)
+	if err != nil {
+		t.Fatalf("Resolve() with auto-provisioning failed: %v", err)
+	}
+	if id.Role != config.RoleUploader {
+		t.Errorf("Resolve().Role = %q, want %q", id.Role, config.RoleUploader)
+	}
+	if _, ok := users.Get(ProviderOIDC, "sub-alice"); !ok {
+		t.Errorf("Resolve() did not provision alice")
+	}
+
+	// Names are not identities: neither a configured user's name nor
+	// another account's name can be taken over.
+	for _, ext := range []External{
+		{Provider: ProviderOIDC, Subject: "sub-impostor", Name: "bob", Groups: []string{"it"}},
+		{Provider: ProviderLDAP, Subject: "uid=alice,dc=example,dc=org", Name: "alice", Groups: []string{"it"}},
+	} {
+		if _, err := Resolve(cfg, users, mapping, ext); !errors.Is(err, ErrNameTaken) {
+			t.Errorf("Resolve() for %s account named %s error = %v, want %v", ext.Provider, ext.Name, err, ErrNameTaken)
+		}
+	}
+
+	carol := External{Provider: ProviderOIDC, Subject: "sub-carol", Name: "carol", Groups: []string{"visitors"}}
+	if _, err := Resolve(cfg, users, mapping, carol); !errors.Is(err, ErrNoRole) {
+		t.Errorf("Resolve() for unmapped groups error = %v, want %v", err, ErrNoRole)
+	}
+
+	alice.Groups = nil
+	if _, err := Resolve(cfg, users, mapping, alice); !errors.Is(err, ErrNoRole) {
+		t.Errorf("Resolve() after leaving every group error = %v, want %v", err, ErrNoRole)
+	}
+	if user, _ := users.Get(ProviderOIDC, "sub-alice"); user.Role != "" {
+		t.Errorf("stored role after leaving every group = %q, want none", user.Role)
+	}
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+const (
+	sessionCookieName = "locara_session"
+	// DefaultSessionTTL is how long a login session stays valid.
+	DefaultSessionTTL = 12 * time.Hour
+)
+
+var errInvalidCookie = errors.New("invalid signed cookie")
+
+// Sessions issues and verifies HMAC-signed cookies holding the logged in
+// identity, so no server-side session state is needed.
+type Sessions struct {
+	key  []byte
+	path string
+	ttl  time.Duration
+}
+
+type signedValue struct {
+	Expires int64           `json:"exp"`
+	Value   json.RawMessage `json:"v"`
+}
+
+// NewSessions creates a session manager signing cookies with key and scoping
+// them to path.
+func NewSessions(key []byte, path string) *Sessions {
+	if path == "" {
+		path = "/"
+	}
+	return &Sessions{key: key, path: path, ttl: DefaultSessionTTL}
+}
+
+// Issue stores the identity in the session cookie. Identities of auth code
+// users are bound to the current code.
+func (s *Sessions) Issue(w http.ResponseWriter, r *http.Request, id *Identity) error {
+	stored := *id
+	if stored.Provider == ProviderAuthCode {
+		stored.Credential = s.credential(id.authCode)
+	}
+	return s.SetSigned(w, r, sessionCookieName, &stored, s.ttl)
+}
+
+// Clear removes the session cookie.
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	s.Delete(w, sessionCookieName)
+}
+
+// Identity returns the identity from a valid session cookie.
+func (s *Sessions) Identity(r *http.Request) (*Identity, bool) {
+	var id Identity
+	if err := s.ReadSigned(r, sessionCookieName, &id); err != nil {
+		return nil, false
+	}
+	return &id, true
+}
+
+// Middleware attaches the session identity, when present, to the request
+// context. The identity is checked against cfg and users on every request,
+// see Current, and sessions of auth code users against the current code;
+// sessions that no longer resolve are cleared.
+func (s *Sessions) Middleware(cfg *config.Config, users *UserStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := s.Identity(r); ok {
+			if current, ok := s.current(cfg, users, id); ok {
+				r = r.WithContext(WithIdentity(r.Context(), current))
+			} else {
+				s.Clear(w)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// current resolves a session identity like Current and rejects sessions of
+// auth code users whose code changed since the session was issued.
+func (s *Sessions) current(cfg *config.Config, users *UserStore, id *Identity) (*Identity, bool) {
+	current, ok := Current(cfg, users, id)
+	if !ok {
+		return nil, false
+	}
+	if current.Provider == ProviderAuthCode && !hmac.Equal([]byte(id.Credential), []byte(s.credential(current.authCode))) {
+		return nil, false
+	}
+	return current, true
+}
+
+// credential fingerprints an auth code without revealing it to whoever
+// reads the cookie.
+func (s *Sessions) credential(code string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("auth code\x00" + code))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SetSigned stores value JSON-encoded in a signed, HTTP-only cookie that
+// expires after ttl.
+func (s *Sessions) SetSigned(w http.ResponseWriter, r *http.Request, name string, value any, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode cookie value: %w", err)
+	}
+
+	expires := time.Now().Add(ttl)
+	payload, err := json.Marshal(signedValue{Expires: expires.Unix(), Value: raw})
+	if err != nil {
+		return fmt.Errorf("failed to encode cookie: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded + "." + s.sign(name, encoded),
+		Path:     s.path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// ReadSigned decodes the value of a signed cookie into dst, failing when the
+// cookie is missing, tampered with or expired.
+func (s *Sessions) ReadSigned(r *http.Request, name string, dst any) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+
+	encoded, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(name, encoded))) {
+		return errInvalidCookie
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errInvalidCookie
+	}
+
+	var value signedValue
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return errInvalidCookie
+	}
+	if time.Now().Unix() >= value.Expires {
+		return errInvalidCookie
+	}
+
+	return json.Unmarshal(value.Value, dst)
+}
+
+// Delete expires the named cookie.
+func (s *Sessions) Delete(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     s.path,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+func (s *Sessions) sign(name, encoded string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(name + "=" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+func TestSessionMiddlewareFollowsConfig(t *testing.T) {
+	sessions := NewSessions([]byte("test-key"), "/")
+	users, err := OpenUserStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenUserStore() failed: %v", err)
+	}
+
+	alice := External{Provider: ProviderOIDC, Subject: "sub-alice", Name: "alice"}
+	if err := users.Record(alice, config.RoleUploader); err != nil {
+		t.Fatalf("Record() failed: %v", err)
+	}
+
+	newConfig := func() *config.Config {
+		return &config.Config{
+			Users: []config.User{{Name: "bob", Role: config.RoleUploader, Provider: ProviderOIDC, Subject: "sub-bob"}},
+			OIDC: config.OIDC{
+				Issuer:      "https://id.example.org",
+				RoleMapping: config.RoleMapping{Roles: map[string]string{"archivists": config.RoleUploader, "it": config.RoleAdmin}},
+			},
+		}
+	}
+
+	cookie := func(id *Identity) *http.Cookie {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := sessions.Issue(rec, httptest.NewRequest(http.MethodGet, "/", nil), id); err != nil {
+			t.Fatalf("Issue() failed: %v", err)
+		}
+		return rec.Result().Cookies()[0]
+	}
+	aliceCookie := cookie(&Identity{Name: "alice", Role: config.RoleUploader, Provider: ProviderOIDC, Subject: "sub-alice"})
+	bobCookie := cookie(&Identity{Name: "bob", Role: config.RoleAdmin, Provider: ProviderOIDC, Subject: "sub-bob"})
+
+	resolve := func(cfg *config.Config, c *http.Cookie) *Identity {
+		t.Helper()
+		var got *Identity
+		h := sessions.Middleware(cfg, users, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = IdentityFromContext(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(c)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	cfg := newConfig()
+	if id := resolve(cfg, aliceCookie); id == nil || id.Role != config.RoleUploader {
+		t.Errorf("alice's session = %+v, want uploader", id)
+	}
+	if id := resolve(cfg, bobCookie); id == nil || id.Role != config.RoleAdmin {
+		t.Errorf("bob's session = %+v, want admin", id)
+	}
+
+	// The group mapping no longer grants admin: bob falls back to his
+	// configured role.
+	cfg = newConfig()
+	delete(cfg.OIDC.Roles, "it")
+	if id := resolve(cfg, bobCookie); id == nil || id.Role != config.RoleUploader {
+		t.Errorf("bob's session without the admin mapping = %+v, want uploader", id)
+	}
+
+	// bob is removed from [[users]] and alice's role from the mapping.
+	cfg = newConfig()
+	cfg.Users = nil
+	delete(cfg.OIDC.Roles, "archivists")
+	if id := resolve(cfg, bobCookie); id != nil {
+		t.Errorf("session of removed user = %+v, want none", id)
+	}
+	if id := resolve(cfg, aliceCookie); id != nil {
+		t.Errorf("session with removed role = %+v, want none", id)
+	}
+
+	// OIDC login is turned off.
+	cfg = newConfig()
+	cfg.OIDC = config.OIDC{}
+	if id := resolve(cfg, aliceCookie); id != nil {
+		t.Errorf("session of disabled provider = %+v, want none", id)
+	}
+
+	// carol logs in with an auth code that is rotated later.
+	cfg = newConfig()
+	cfg.Users = append(cfg.Users, config.User{Name: "carol", Role: config.RoleUploader, Auth: "old-code"})
+	carolCookie := cookie(FromUser(&cfg.Users[1]))
+	if id := resolve(cfg, carolCookie); id == nil || id.Name != "carol" {
+		t.Errorf("carol's session = %+v, want carol", id)
+	}
+	cfg.Users[1].Auth = "new-code"
+	if id := resolve(cfg, carolCookie); id != nil {
+		t.Errorf("session after rotating the auth code = %+v, want none", id)
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+// Throttle tracks failed authentication attempts per key (client IP, user
+// name, ...) and delays further attempts with exponential backoff, locking
+// the key out entirely after too many failures.
+type Throttle struct {
+	cfg config.LoginThrottle
+	now func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*failureRecord
+}
+
+type failureRecord struct {
+	count        int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// NewThrottle creates a throttle with the given policy.
+func NewThrottle(cfg config.LoginThrottle) *Throttle {
+	return &Throttle{
+		cfg:      cfg,
+		now:      time.Now,
+		failures: make(map[string]*failureRecord),
+	}
+}
+
+// IPKey returns the throttle key for a client IP.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// UserKey returns the throttle key for a user name.
+func UserKey(name string) string {
+	return "user:" + name
+}
+
+// ShareKey returns the throttle key for the password of a share link.
+func ShareKey(id string) string {
+	return "share:" + id
+}
+
+// Check reports how long the caller must wait before another attempt is
+// allowed for any of the keys. A zero duration means the attempt may proceed.
+func (t *Throttle) Check(keys ...string) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cfg.Disabled {
+		return 0
+	}
+
+	now := t.now()
+	var wait time.Duration
+	for _, key := range keys {
+		rec, ok := t.failures[key]
+		if !ok {
+			continue
+		}
+		if d := rec.blockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// Failure records a failed attempt for every key and returns the longest
+// resulting delay before the next attempt is allowed.
+func (t *Throttle) Failure(keys ...string) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cfg.Disabled {
+		return 0
+	}
+
+	now := t.now()
+	t.pruneLocked(now)
+
+	var wait time.Duration
+	for _, key := range keys {
+		rec, ok := t.failures[key]
+		if !ok {
+			rec = &failureRecord{}
+			t.failures[key] = rec
+		}
+		rec.count++
+		rec.lastFailure = now
+
+		delay := t.delayFor(rec.count)
+		if delay > 0 {
+			rec.blockedUntil = now.Add(delay)
+		}
+		if delay > wait {
+			wait = delay
+		}
+
+		switch {
+		case rec.count == t.cfg.LockoutThreshold:
+			slog.Warn("Locked out after failed authentication attempts", "audit", true, "key", key, "failures", rec.count, "duration", delay)
+		case rec.count > t.cfg.Free():
+			slog.Warn("Repeated authentication failure", "audit", true, "key", key, "failures", rec.count, "next_attempt_in", delay)
+		}
+	}
+
+	return wait
+}
+
+// Success clears the failure history of every key.
+func (t *Throttle) Success(keys ...string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		delete(t.failures, key)
+	}
+}
+
+// Reconfigure replaces the throttling policy. Recorded failures are kept and
+// judged by the new policy from the next attempt on.
+func (t *Throttle) Reconfigure(cfg config.LoginThrottle) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cfg = cfg
+}
+
+// delayFor returns the backoff after the given number of consecutive failures.
+func (t *Throttle) delayFor(count int) time.Duration {
+	if count >= t.cfg.LockoutThreshold {
+		return t.cfg.LockoutDuration
+	}
+	free := t.cfg.Free()
+	if count <= free {
+		return 0
+	}
+
+	delay := t.cfg.BaseDelay
+	for i := free + 1; i < count && delay < t.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, t.cfg.MaxDelay)
+}
+
+// pruneLocked forgets keys whose last failure is older than the reset window.
+func (t *Throttle) pruneLocked(now time.Time) {
+	for key, rec := range t.failures {
+		if now.Sub(rec.lastFailure) > t.cfg.ResetAfter && now.After(rec.blockedUntil) {
+			delete(t.failures, key)
+		}
+	}
+}
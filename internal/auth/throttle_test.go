@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+func newTestThrottle() (*Throttle, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	free := 2
+	t := NewThrottle(config.LoginThrottle{
+		FreeAttempts:     &free,
+		BaseDelay:        time.Second,
+		MaxDelay:         8 * time.Second,
+		LockoutThreshold: 6,
+		LockoutDuration:  time.Hour,
+		ResetAfter:       2 * time.Hour,
+	})
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	throttle, _ := newTestThrottle()
+	key := IPKey("192.0.2.1")
+
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, time.Hour}
+	for i, w := range want {
+		if got := throttle.Failure(key); got != w {
+			t.Errorf("Failure() #%d = %v, want %v", i+1, got, w)
+		}
+	}
+
+	if got := throttle.Check(key); got != time.Hour {
+		t.Errorf("Check() after lockout = %v, want %v", got, time.Hour)
+	}
+	if got := throttle.Check(IPKey("192.0.2.2")); got != 0 {
+		t.Errorf("Check() for other key = %v, want 0", got)
+	}
+
+	throttle.Success(key)
+	if got := throttle.Check(key); got != 0 {
+		t.Errorf("Check() after success = %v, want 0", got)
+	}
+}
+
+func TestThrottleCapsAndResets(t *testing.T) {
+	throttle, now := newTestThrottle()
+	throttle.cfg.LockoutThreshold = 100
+	key := UserKey("alice")
+
+	var last time.Duration
+	for range 10 {
+		last = throttle.Failure(key)
+	}
+	if last != 8*time.Second {
+		t.Errorf("Failure() after many attempts = %v, want capped %v", last, 8*time.Second)
+	}
+
+	*now = now.Add(3 * time.Hour)
+	throttle.Failure(UserKey("someone-else"))
+
+	if got := throttle.Failure(key); got != 0 {
+		t.Errorf("Failure() after reset window = %v, want 0", got)
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const usersFileName = ".users.json"
+
+// ProvisionedUser is a user created on first login through an external
+// identity provider. An empty role marks a user whose groups no longer map
+// to one.
+type ProvisionedUser struct {
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedOn time.Time `json:"created_on"`
+	LastLogin time.Time `json:"last_login"`
+}
+
+// UserStore keeps provisioned users persisted as JSON in the uploads directory.
+type UserStore struct {
+	mu    sync.Mutex
+	path  string
+	users map[string]*ProvisionedUser // by userKey
+}
+
+// userKey identifies an external account, whose name may change or be
+// reused by another provider.
+func userKey(provider, subject string) string {
+	return provider + "\x00" + subject
+}
+
+// OpenUserStore loads the provisioned user store from baseDir.
+func OpenUserStore(baseDir string) (*UserStore, error) {
+	s := &UserStore{
+		path:  filepath.Join(baseDir, usersFileName),
+		users: make(map[string]*ProvisionedUser),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read user store: %w", err)
+	}
+
+	var users []*ProvisionedUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse user store: %w", err)
+	}
+	for _, user := range users {
+		s.users[userKey(user.Provider, user.Subject)] = user
+	}
+
+	return s, nil
+}
+
+// Get returns the provisioned user for the account with the given provider
+// and subject.
+func (s *UserStore) Get(provider, subject string) (ProvisionedUser, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userKey(provider, subject)]
+	if !ok {
+		return ProvisionedUser{}, false
+	}
+	return *user, true
+}
+
+// List returns all provisioned users sorted by name.
+func (s *UserStore) List() []ProvisionedUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]ProvisionedUser, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
+	return users
+}
+
+// Record creates or updates the provisioned user for a successful login.
+func (s *UserStore) Record(ext External, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	key := userKey(ext.Provider, ext.Subject)
+	previous, existed := s.users[key]
+
+	user := &ProvisionedUser{
+		Name:      ext.Name,
+		Role:      role,
+		Provider:  ext.Provider,
+		Subject:   ext.Subject,
+		CreatedOn: now,
+		LastLogin: now,
+	}
+	if existed {
+		user.CreatedOn = previous.CreatedOn
+	}
+
+	s.users[key] = user
+	if err := s.save(); err != nil {
+		if existed {
+			s.users[key] = previous
+		} else {
+			delete(s.users, key)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserStore) save() error {
+	users := make([]*ProvisionedUser, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode user store: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write user store: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace user store: %w", err)
+	}
+
+	return nil
+}
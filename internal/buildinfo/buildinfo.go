@@ -0,0 +1,66 @@
+// Package buildinfo reports which build of Locara is running. Version and
+// Commit are injected at build time:
+//
+//	go build -ldflags "-X github.com/Firstbober/locara/internal/buildinfo.Version=1.2.0 \
+//		-X github.com/Firstbober/locara/internal/buildinfo.Commit=$(git rev-parse HEAD)" ./cmd/locara
+//
+// Without them the module version and VCS revision recorded by the Go
+// toolchain are used where available.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags "-X ...".
+var (
+	Version string
+	Commit  string
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information, falling back to "dev" for builds
+// without a version.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		if info.Commit == "" {
+			info.Commit = vcsRevision(bi.Settings)
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// vcsRevision returns the commit recorded by go build, marked when the
+// working tree had uncommitted changes.
+func vcsRevision(settings []debug.BuildSetting) string {
+	var revision string
+	var modified bool
+	for _, s := range settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
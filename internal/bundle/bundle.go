@@ -0,0 +1,553 @@
+// Package bundle exports the archives of an uploads directory to a portable
+// bundle, a tar file or directory, and restores them from one.
+//
+// A bundle holds manifest.json followed by archives/<id>/info.json and
+// archives/<id>/<file> for every archive. The manifest lists the archives
+// with the MD5 sums of both files.
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Firstbober/locara/internal/models"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+const (
+	// ManifestName is the name of the manifest in a bundle.
+	ManifestName = "manifest.json"
+	// Format is the version of the bundle layout written by Export.
+	Format = 1
+
+	// maxInfoSize bounds the info.json files read from a bundle.
+	maxInfoSize = 1 << 20
+)
+
+// Manifest describes the archives in a bundle.
+type Manifest struct {
+	Format     int       `json:"format"`
+	ExportedOn time.Time `json:"exported_on"`
+	Archives   []Entry   `json:"archives"`
+}
+
+// Entry is an archive in a bundle.
+type Entry struct {
+	ID int `json:"id"`
+	// Info and File are the paths of the info.json and the archive file in
+	// the bundle.
+	Info    string `json:"info"`
+	InfoMD5 string `json:"info_md5"`
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	MD5     string `json:"md5"`
+}
+
+// Writer adds files to a bundle.
+type Writer interface {
+	// Add writes a file of size bytes read from r.
+	Add(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+// Reader reads files from a bundle. Tar bundles are read as a stream, so
+// files must be opened in the order they were added and each one is only
+// readable until the next is opened.
+type Reader interface {
+	Open(name string) (io.ReadCloser, error)
+	Close() error
+}
+
+// IsTar reports whether a bundle path names a tar file rather than a
+// directory: "-" for standard input or output, or a name ending in .tar,
+// .tar.gz or .tgz.
+func IsTar(name string) bool {
+	return name == "-" || strings.HasSuffix(name, ".tar") || isGzip(name)
+}
+
+func isGzip(name string) bool {
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// Create creates a bundle, writing to stdout for "-". Tar files are
+// compressed when named .tar.gz or .tgz. Existing files are not replaced and
+// existing directories must be empty.
+func Create(name string, stdout io.Writer) (Writer, error) {
+	if !IsTar(name) {
+		if err := os.MkdirAll(name, 0o755); err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			return nil, fmt.Errorf("%s is not empty", name)
+		}
+		return &dirWriter{dir: name}, nil
+	}
+
+	w := &tarWriter{}
+	out := stdout
+	if name != "-" {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		w.file = f
+		out = f
+	}
+	if isGzip(name) {
+		w.gz = gzip.NewWriter(out)
+		out = w.gz
+	}
+	w.tw = tar.NewWriter(out)
+	return w, nil
+}
+
+// Open opens a bundle, reading from stdin for "-". Tar files may be
+// compressed with gzip.
+func Open(name string, stdin io.Reader) (Reader, error) {
+	in := stdin
+	var file *os.File
+	if name != "-" {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return &dirReader{dir: name}, nil
+		}
+		if file, err = os.Open(name); err != nil {
+			return nil, err
+		}
+		in = file
+	}
+
+	r := &tarReader{file: file}
+	buffered := bufio.NewReader(in)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.tr = tar.NewReader(gz)
+	} else {
+		r.tr = tar.NewReader(buffered)
+	}
+	return r, nil
+}
+
+type tarWriter struct {
+	tw   *tar.Writer
+	gz   *gzip.Writer
+	file *os.File
+}
+
+func (w *tarWriter) Add(name string, size int64, r io.Reader) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(w.tw, r, size)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	errs := []error{w.tw.Close()}
+	if w.gz != nil {
+		errs = append(errs, w.gz.Close())
+	}
+	if w.file != nil {
+		errs = append(errs, w.file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type dirWriter struct {
+	dir string
+}
+
+func (w *dirWriter) Add(name string, size int64, r io.Reader) error {
+	dst := filepath.Join(w.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (w *dirWriter) Close() error {
+	return nil
+}
+
+type tarReader struct {
+	tr   *tar.Reader
+	file *os.File
+}
+
+func (r *tarReader) Open(name string) (io.ReadCloser, error) {
+	for {
+		hdr, err := r.tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in bundle: %w", name, fs.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == name && hdr.Typeflag == tar.TypeReg {
+			return io.NopCloser(r.tr), nil
+		}
+	}
+}
+
+func (r *tarReader) Close() error {
+	if r.file != nil {
+		return r.file.Close()
+	}
+	return nil
+}
+
+type dirReader struct {
+	dir string
+}
+
+func (r *dirReader) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(r.dir, filepath.FromSlash(name)))
+}
+
+func (r *dirReader) Close() error {
+	return nil
+}
+
+// Export writes every archive in baseDir to w and returns the manifest.
+// Archive files are hashed before and while they are copied; one whose
+// content does not match its recorded checksum or changes during the export
+// fails it. report, if not nil, is called for every exported archive.
+func Export(ctx context.Context, baseDir string, w Writer, report func(Entry)) (*Manifest, error) {
+	archives, err := storage.ListArchives(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Format: Format, ExportedOn: time.Now().UTC(), Archives: []Entry{}}
+	infos := make([][]byte, len(archives))
+	files := make([]string, len(archives))
+	for i := range archives {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		a := &archives[i]
+		file, err := storage.GetArchiveFilePath(baseDir, a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %w", a.ID, err)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %w", a.ID, err)
+		}
+		sum, err := storage.FileMD5(file)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %w", a.ID, err)
+		}
+		if a.MD5Sum != "" && !storage.MD5Matches(a.MD5Sum, sum) {
+			return nil, fmt.Errorf("archive %d does not match its recorded checksum, check it with locara admin verify", a.ID)
+		}
+
+		if infos[i], err = json.MarshalIndent(a, "", "  "); err != nil {
+			return nil, err
+		}
+		dir := "archives/" + strconv.Itoa(a.ID) + "/"
+		files[i] = file
+		m.Archives = append(m.Archives, Entry{
+			ID:      a.ID,
+			Info:    dir + "info.json",
+			InfoMD5: md5Hex(infos[i]),
+			File:    dir + filepath.Base(file),
+			Size:    info.Size(),
+			MD5:     sum,
+		})
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(ManifestName, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	for i, e := range m.Archives {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := w.Add(e.Info, int64(len(infos[i])), bytes.NewReader(infos[i])); err != nil {
+			return nil, fmt.Errorf("archive %d: %w", e.ID, err)
+		}
+		if err := exportFile(w, e, files[i]); err != nil {
+			return nil, fmt.Errorf("archive %d: %w", e.ID, err)
+		}
+		if report != nil {
+			report(e)
+		}
+	}
+	return m, nil
+}
+
+func exportFile(w Writer, e Entry, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if err := w.Add(e.File, e.Size, io.TeeReader(f, h)); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != e.MD5 {
+		return errors.New("file changed during the export")
+	}
+	return nil
+}
+
+// ReadManifest reads and checks the manifest of a bundle, which must be
+// opened before any other file.
+func ReadManifest(r Reader) (*Manifest, error) {
+	f, err := r.Open(ManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	defer f.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Format != Format {
+		return nil, fmt.Errorf("unsupported bundle format %d", m.Format)
+	}
+
+	ids := make(map[int]bool, len(m.Archives))
+	for _, e := range m.Archives {
+		dir := "archives/" + strconv.Itoa(e.ID) + "/"
+		switch {
+		case e.ID < 1 || ids[e.ID]:
+			return nil, fmt.Errorf("manifest lists invalid or duplicate archive ID %d", e.ID)
+		case e.Info != dir+"info.json" || path.Dir(e.File) != path.Clean(dir) || !filepath.IsLocal(e.File) || path.Base(e.File) == "info.json":
+			return nil, fmt.Errorf("manifest lists invalid paths for archive %d", e.ID)
+		}
+		ids[e.ID] = true
+	}
+	return &m, nil
+}
+
+// Statuses of restored archives.
+const (
+	// StatusRestored is an archive saved to the uploads directory, or that
+	// would be in a dry run.
+	StatusRestored = "restored"
+	// StatusPresent is an archive whose content is already in the uploads
+	// directory, under the same ID unless remapping.
+	StatusPresent = "present"
+	// StatusConflict is an archive whose ID is taken by another one.
+	StatusConflict = "conflict"
+	// StatusFailed is an archive missing from the bundle or not matching
+	// its checksums.
+	StatusFailed = "failed"
+)
+
+// Result is what happened to an archive of a bundle.
+type Result struct {
+	// ID is the ID in the bundle and NewID the one in the uploads
+	// directory, unknown for new IDs in a dry run.
+	ID     int    `json:"id"`
+	NewID  int    `json:"new_id,omitempty"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RestoreOptions configure a restore.
+type RestoreOptions struct {
+	// Remap gives the archives new IDs following those in the uploads
+	// directory instead of keeping theirs.
+	Remap bool
+	// DryRun checks the bundle and reports what would be restored without
+	// saving anything.
+	DryRun bool
+}
+
+// Restore saves the archives of a bundle to the uploads directory in
+// baseDir, verifying the checksums of every file. Archives already present
+// with the same content are skipped, so an interrupted restore continues
+// where it stopped when run again. report is called for every archive;
+// Restore only returns an error when the restore cannot continue, including
+// ctx being cancelled.
+func Restore(ctx context.Context, baseDir string, r Reader, opts RestoreOptions, report func(Result)) error {
+	m, err := ReadManifest(r)
+	if err != nil {
+		return err
+	}
+
+	archives, err := storage.ListArchives(baseDir)
+	if err != nil {
+		return err
+	}
+	sums := make(map[int]string, len(archives))
+	ids := make(map[string]int, len(archives))
+	for _, a := range archives {
+		sum := storage.HexMD5(a.MD5Sum)
+		sums[a.ID] = sum
+		if sum != "" {
+			ids[sum] = a.ID
+		}
+	}
+
+	for _, e := range m.Archives {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result := restoreArchive(ctx, baseDir, r, e, opts, sums, ids)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report(result)
+	}
+	return nil
+}
+
+func restoreArchive(ctx context.Context, baseDir string, r Reader, e Entry, opts RestoreOptions, sums map[int]string, ids map[string]int) Result {
+	result := Result{ID: e.ID}
+	done := func(status, format string, args ...any) Result {
+		result.Status = status
+		result.Detail = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	meta, err := readInfo(r, e)
+	if err != nil {
+		return done(StatusFailed, "%v", err)
+	}
+
+	if opts.Remap {
+		if id, ok := ids[e.MD5]; ok {
+			result.NewID = id
+			return done(StatusPresent, "same content as archive #%d", id)
+		}
+	} else if sum, ok := sums[e.ID]; ok {
+		switch sum {
+		case e.MD5:
+			result.NewID = e.ID
+			return done(StatusPresent, "")
+		case "":
+			return done(StatusConflict, "archive #%d already exists", e.ID)
+		}
+		return done(StatusConflict, "archive #%d already exists with other content", e.ID)
+	}
+
+	f, err := r.Open(e.File)
+	if err != nil {
+		return done(StatusFailed, "%v", err)
+	}
+	defer f.Close()
+
+	result.Status = StatusRestored
+	if opts.DryRun {
+		h := md5.New()
+		n, err := io.Copy(h, f)
+		if err != nil {
+			return done(StatusFailed, "%v", err)
+		}
+		if n != e.Size || hex.EncodeToString(h.Sum(nil)) != e.MD5 {
+			return done(StatusFailed, "file does not match its checksum")
+		}
+		if !opts.Remap {
+			result.NewID = e.ID
+		}
+		return result
+	}
+
+	meta.MD5Sum = e.MD5
+	if opts.Remap {
+		header := &multipart.FileHeader{Filename: meta.FileName, Size: e.Size}
+		err = storage.SaveArchive(ctx, baseDir, f, header, meta)
+	} else {
+		err = storage.SaveArchiveAs(ctx, baseDir, e.ID, f, meta)
+	}
+	switch {
+	case errors.Is(err, storage.ErrChecksumMismatch):
+		return done(StatusFailed, "file does not match its checksum")
+	case errors.Is(err, fs.ErrExist):
+		return done(StatusConflict, "archive #%d already exists", e.ID)
+	case err != nil:
+		return done(StatusFailed, "%v", err)
+	}
+
+	result.NewID = meta.ID
+	sums[meta.ID] = e.MD5
+	ids[e.MD5] = meta.ID
+	return result
+}
+
+// readInfo reads and checks the info.json of an archive in a bundle.
+func readInfo(r Reader, e Entry) (*models.Archive, error) {
+	f, err := r.Open(e.Info)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxInfoSize))
+	if err != nil {
+		return nil, err
+	}
+	if md5Hex(data) != e.InfoMD5 {
+		return nil, errors.New("info.json does not match its checksum")
+	}
+
+	var meta models.Archive
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse info.json: %w", err)
+	}
+	if meta.FileName != path.Base(e.File) {
+		return nil, fmt.Errorf("info.json names file %q, bundle holds %q", meta.FileName, path.Base(e.File))
+	}
+	return &meta, nil
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
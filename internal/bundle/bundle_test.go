@@ -0,0 +1,204 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/models"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+func saveArchive(t *testing.T, dir, name, content string) *models.Archive {
+	t.Helper()
+
+	meta := &models.Archive{Name: name, FileName: name + ".txt", SizeBytes: int64(len(content)), DatedOn: "2024"}
+	header := &multipart.FileHeader{Filename: meta.FileName, Size: meta.SizeBytes}
+	if err := storage.SaveArchive(context.Background(), dir, strings.NewReader(content), header, meta); err != nil {
+		t.Fatalf("SaveArchive() failed: %v", err)
+	}
+	return meta
+}
+
+func export(t *testing.T, baseDir, name string) *Manifest {
+	t.Helper()
+
+	w, err := Create(name, nil)
+	if err != nil {
+		t.Fatalf("Create(%s) failed: %v", name, err)
+	}
+	m, err := Export(context.Background(), baseDir, w, nil)
+	if err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	return m
+}
+
+func restore(t *testing.T, baseDir, name string, opts RestoreOptions) map[int]Result {
+	t.Helper()
+
+	r, err := Open(name, nil)
+	if err != nil {
+		t.Fatalf("Open(%s) failed: %v", name, err)
+	}
+	defer r.Close()
+
+	results := make(map[int]Result)
+	if err := Restore(context.Background(), baseDir, r, opts, func(res Result) { results[res.ID] = res }); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	return results
+}
+
+func TestExportAndRestore(t *testing.T) {
+	src := t.TempDir()
+	saveArchive(t, src, "first", "first content")
+	saveArchive(t, src, "second", "second content")
+	saveArchive(t, src, "third", "third content")
+	if err := storage.DeleteArchive(src, 2); err != nil {
+		t.Fatalf("DeleteArchive() failed: %v", err)
+	}
+
+	bundles := t.TempDir()
+	for _, name := range []string{"backup.tar", "backup.tar.gz", "backup"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(bundles, name)
+			m := export(t, src, path)
+			if len(m.Archives) != 2 || m.Archives[1].ID != 3 || m.Archives[1].File != "archives/3/third.txt" {
+				t.Fatalf("Export() manifest = %+v", m)
+			}
+			if _, err := Create(path, nil); err == nil {
+				t.Error("Create() replaced an existing bundle")
+			}
+
+			dst := t.TempDir()
+			results := restore(t, dst, path, RestoreOptions{DryRun: true})
+			if results[3].Status != StatusRestored || results[3].NewID != 3 {
+				t.Errorf("dry run result for #3 = %+v", results[3])
+			}
+			if archives, _ := storage.ListArchives(dst); len(archives) != 0 {
+				t.Fatalf("dry run restored %d archives", len(archives))
+			}
+
+			restore(t, dst, path, RestoreOptions{})
+			restored, err := storage.GetArchive(dst, 3)
+			if err != nil || restored.Name != "third" {
+				t.Fatalf("GetArchive(3) after restore = %+v, %v", restored, err)
+			}
+			if checked, findings, err := storage.Verify(dst, true); checked != 2 || len(findings) != 0 || err != nil {
+				t.Errorf("Verify() after restore = %d, %v, %v", checked, findings, err)
+			}
+
+			// Restoring again finds everything present.
+			for id, res := range restore(t, dst, path, RestoreOptions{}) {
+				if res.Status != StatusPresent {
+					t.Errorf("second restore of #%d = %+v, want present", id, res)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreConflictsAndRemap(t *testing.T) {
+	src := t.TempDir()
+	saveArchive(t, src, "first", "first content")
+	saveArchive(t, src, "second", "second content")
+	path := filepath.Join(t.TempDir(), "backup.tar")
+	export(t, src, path)
+
+	dst := t.TempDir()
+	saveArchive(t, dst, "other", "other content")
+	saveArchive(t, dst, "second copy", "second content")
+
+	results := restore(t, dst, path, RestoreOptions{})
+	if results[1].Status != StatusConflict || results[2].Status != StatusPresent {
+		t.Errorf("restore onto taken IDs = %+v, want a conflict for #1 and #2 present", results)
+	}
+
+	results = restore(t, dst, path, RestoreOptions{Remap: true})
+	if r := results[1]; r.Status != StatusRestored || r.NewID != 3 {
+		t.Errorf("remapped result for #1 = %+v, want restored as #3", r)
+	}
+	if r := results[2]; r.Status != StatusPresent || r.NewID != 2 {
+		t.Errorf("remapped result for #2 = %+v, want present as #2", r)
+	}
+	if restored, err := storage.GetArchive(dst, 3); err != nil || restored.Name != "first" || restored.ID != 3 {
+		t.Errorf("GetArchive(3) after remapped restore = %+v, %v", restored, err)
+	}
+}
+
+func TestRestoreCorrupted(t *testing.T) {
+	src := t.TempDir()
+	saveArchive(t, src, "first", "first content")
+	saveArchive(t, src, "second", "second content")
+	path := filepath.Join(t.TempDir(), "backup")
+	export(t, src, path)
+
+	if err := os.WriteFile(filepath.Join(path, "archives", "1", "first.txt"), []byte("first c0ntent"), 0o644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := os.Remove(filepath.Join(path, "archives", "2", "second.txt")); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+
+	dst := t.TempDir()
+	for _, dryRun := range []bool{true, false} {
+		results := restore(t, dst, path, RestoreOptions{DryRun: dryRun})
+		if results[1].Status != StatusFailed || results[2].Status != StatusFailed {
+			t.Errorf("restore (dry run %v) of corrupted bundle = %+v, want failures", dryRun, results)
+		}
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+		t.Errorf("restore of corrupted bundle left %d entries", len(entries))
+	}
+
+	if err := os.WriteFile(filepath.Join(path, ManifestName), []byte(`{"format": 2}`), 0o644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	r, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if err := Restore(context.Background(), dst, r, RestoreOptions{}, func(Result) {}); err == nil {
+		t.Error("Restore() of unknown format succeeded")
+	}
+}
+
+func TestExportRefusesCorruptedArchive(t *testing.T) {
+	src := t.TempDir()
+	saveArchive(t, src, "first", "first content")
+	if err := os.WriteFile(filepath.Join(src, "1", "first.txt"), []byte("first c0ntent"), 0o644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := Create("-", &buf)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if _, err := Export(context.Background(), src, w, nil); err == nil {
+		t.Error("Export() of corrupted archive succeeded")
+	}
+}
+
+func TestReadManifestRejectsUnsafePaths(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"format": 1, "archives": [{"id": 1, "info": "archives/1/info.json", "file": "archives/1/../../../etc/passwd"}]}`
+	if err := os.WriteFile(filepath.Join(dir, ManifestName), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	r, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if _, err := ReadManifest(r); err == nil {
+		t.Error("ReadManifest() accepted a path outside the archive directory")
+	}
+}
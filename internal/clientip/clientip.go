@@ -0,0 +1,211 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Resolver determines the real client IP of a request, honouring forwarding
+// headers only when they were added by a trusted proxy.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// ParseTrusted parses a list of trusted proxies given as CIDR ranges or
+// single addresses.
+func ParseTrusted(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// NewResolver creates a resolver trusting the given proxy ranges.
+func NewResolver(trusted []netip.Prefix) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// Resolve returns the client IP of the request. The chain of addresses from
+// the Forwarded (RFC 7239) or X-Forwarded-For header is walked from right to
+// left, starting at the directly connected peer, and the first address that
+// is not a trusted proxy is the client. Peers connected through a Unix socket
+// are local proxies and always trusted.
+func (res *Resolver) Resolve(r *http.Request) string {
+	peer, ok := parseHost(r.RemoteAddr)
+	switch {
+	case !ok && !isUnixPeer(r.RemoteAddr):
+		return r.RemoteAddr
+	case ok && !res.isTrusted(peer):
+		return peer.String()
+	}
+
+	client := r.RemoteAddr
+	if ok {
+		client = peer.String()
+	}
+
+	chain, ok := forwardedChain(r.Header)
+	if !ok {
+		if realIP, ok := parseHost(r.Header.Get("X-Real-IP")); ok {
+			return realIP.String()
+		}
+		return client
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop, ok := parseHost(chain[i])
+		if !ok {
+			// The trusted proxy recorded something that is not an address
+			// (e.g. "unknown" or an obfuscated identifier).
+			break
+		}
+		client = hop.String()
+		if !res.isTrusted(hop) {
+			break
+		}
+	}
+
+	return client
+}
+
+// Middleware resolves the client IP once per request and stores it in the
+// request context for logging, rate limiting and auditing.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextKey{}, res.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// FromRequest returns the client IP stored by the middleware, falling back
+// to the address of the directly connected peer.
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	if addr, ok := parseHost(r.RemoteAddr); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// isUnixPeer reports whether a remote address belongs to a Unix socket
+// connection, which net/http reports as "@" or an empty string.
+func isUnixPeer(remoteAddr string) bool {
+	return remoteAddr == "@" || remoteAddr == ""
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedChain returns the addresses recorded by proxies, client first.
+// The standard Forwarded header takes precedence over X-Forwarded-For.
+func forwardedChain(h http.Header) ([]string, bool) {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		var chain []string
+		for _, value := range values {
+			for _, element := range splitQuoted(value, ',') {
+				chain = append(chain, forwardedFor(element))
+			}
+		}
+		return chain, len(chain) > 0
+	}
+
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		var chain []string
+		for _, value := range values {
+			for _, hop := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(hop))
+			}
+		}
+		return chain, len(chain) > 0
+	}
+
+	return nil, false
+}
+
+// forwardedFor extracts the for= parameter of one Forwarded element.
+func forwardedFor(element string) string {
+	for _, pair := range splitQuoted(element, ';') {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "for") {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return ""
+}
+
+// splitQuoted splits s on sep, ignoring separators inside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseHost parses an address that may carry a port and IPv6 brackets.
+func parseHost(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,125 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	trusted, err := ParseTrusted([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseTrusted() failed: %v", err)
+	}
+	resolver := NewResolver(trusted)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "198.51.100.7:5000",
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "spoofed header from untrusted peer",
+			remoteAddr: "198.51.100.7:5000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "single trusted proxy",
+			remoteAddr: "10.0.0.2:5000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "client prepends fake hop",
+			remoteAddr: "10.0.0.2:5000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.9, 10.0.0.3"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "forwarded header takes precedence",
+			remoteAddr: "10.0.0.2:5000",
+			headers: map[string]string{
+				"Forwarded":       `for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"`,
+				"X-Forwarded-For": "1.2.3.4",
+			},
+			want: "192.0.2.60",
+		},
+		{
+			name:       "obfuscated forwarded hop",
+			remoteAddr: "10.0.0.2:5000",
+			headers:    map[string]string{"Forwarded": "for=_hidden, for=10.0.0.5"},
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "real ip from trusted proxy",
+			remoteAddr: "[2001:db8::1]:443",
+			headers:    map[string]string{"X-Real-IP": "203.0.113.20"},
+			want:       "203.0.113.20",
+		},
+		{
+			name:       "ipv4 mapped peer",
+			remoteAddr: "[::ffff:10.1.2.3]:80",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "proxy on unix socket",
+			remoteAddr: "@",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "unix socket without headers",
+			remoteAddr: "@",
+			want:       "@",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			if got := resolver.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	trusted, err := ParseTrusted([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("ParseTrusted() failed: %v", err)
+	}
+
+	var got string
+	handler := NewResolver(trusted).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromRequest(r)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got != "203.0.113.9" {
+		t.Errorf("FromRequest() = %q, want %q", got, "203.0.113.9")
+	}
+}
+
+func TestParseTrustedRejectsGarbage(t *testing.T) {
+	if _, err := ParseTrusted([]string{"not-an-ip"}); err == nil {
+		t.Errorf("ParseTrusted() succeeded, want error")
+	}
+}
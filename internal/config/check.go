@@ -0,0 +1,226 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Problem is an issue found while validating a configuration.
+type Problem struct {
+	// Key is the TOML key concerned, e.g. "users[1].auth", or "" for the
+	// file as a whole.
+	Key string
+	// Line is the line in the config file, or 0 when unknown.
+	Line    int
+	Warning bool
+	Message string
+}
+
+func (p Problem) Error() string {
+	if p.Key == "" {
+		return p.Message
+	}
+	return p.Key + ": " + p.Message
+}
+
+type problems []Problem
+
+func (ps *problems) add(key, format string, args ...any) {
+	*ps = append(*ps, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+func (ps *problems) warn(key, format string, args ...any) {
+	*ps = append(*ps, Problem{Key: key, Warning: true, Message: fmt.Sprintf(format, args...)})
+}
+
+// joinProblems combines the errors among ps into a single error.
+func joinProblems(ps []Problem) error {
+	var errs []error
+	for _, p := range ps {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check validates the configuration file at path with overrides applied and
+// returns every problem found, ordered by line, along with the configuration
+// as far as it could be read (nil when the file cannot be parsed). Unlike
+// Load it never touches the filesystem: a missing uploads directory is only
+// checked for whether it could be created.
+func Check(path string, overrides map[string]string) (*Config, []Problem) {
+	var ps problems
+	var cfg Config
+	lines := map[string]int{}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			ps.add("", "failed to read config file: %v", err)
+			return nil, ps
+		}
+
+		md, err := toml.Decode(string(data), &cfg)
+		if err != nil {
+			p := Problem{Message: fmt.Sprintf("failed to parse TOML: %v", err)}
+			var perr toml.ParseError
+			if errors.As(err, &perr) {
+				p.Line = perr.Position.Line
+				p.Message = "failed to parse TOML: " + perr.Message
+			}
+			return nil, append(ps, p)
+		}
+
+		lines = keyLines(string(data))
+		for _, key := range md.Undecoded() {
+			ps.warn(key.String(), "unknown key")
+		}
+	}
+
+	if err := ApplyOverrides(&cfg, overrides); err != nil {
+		ps.add("", "invalid override: %v", err)
+	}
+
+	ps = append(ps, validateConfig(&cfg)...)
+
+	if cfg.UseDirectory != "" {
+		checkWritableDir(&ps, "use_directory", cfg.UseDirectory)
+	}
+
+	for i := range ps {
+		ps[i].Line = lineOf(lines, ps[i].Key)
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return ps[i].Line < ps[j].Line })
+
+	return &cfg, ps
+}
+
+// checkWritableDir reports whether dir is a writable directory, or could be
+// created when it does not exist yet.
+func checkWritableDir(ps *problems, key, dir string) {
+	info, err := os.Stat(dir)
+	switch {
+	case err == nil && !info.IsDir():
+		ps.add(key, "%s is not a directory", dir)
+	case err == nil:
+		if !writable(dir) {
+			ps.add(key, "%s is not writable", dir)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		parent := filepath.Dir(dir)
+		for {
+			if _, err := os.Stat(parent); err == nil || parent == filepath.Dir(parent) {
+				break
+			}
+			parent = filepath.Dir(parent)
+		}
+		if !writable(parent) {
+			ps.add(key, "%s does not exist and cannot be created in %s", dir, parent)
+		} else {
+			ps.warn(key, "%s does not exist and will be created", dir)
+		}
+	default:
+		ps.add(key, "%v", err)
+	}
+}
+
+var (
+	tableHeader = regexp.MustCompile(`^\[\[?\s*([^\]]+?)\s*\]\]?`)
+	keyValue    = regexp.MustCompile(`^("[^"]*"|'[^']*'|[A-Za-z0-9_.-]+)\s*=`)
+)
+
+// keyLines maps the keys defined in a TOML document to their line numbers.
+// Entries of arrays of tables are indexed ("users[1].auth"), and the first
+// entry is also recorded without the index ("users.auth") to place unknown
+// key warnings reported by the decoder.
+func keyLines(data string) map[string]int {
+	lines := make(map[string]int)
+	record := func(key string, line int) {
+		if _, ok := lines[key]; !ok {
+			lines[key] = line
+		}
+	}
+
+	counts := make(map[string]int)
+	table, plainTable := "", ""
+	depth := 0
+	multiline := ""
+
+	for i, line := range strings.Split(data, "\n") {
+		n := i + 1
+		line = strings.TrimSpace(line)
+
+		if multiline != "" {
+			if strings.Contains(line, multiline) {
+				multiline = ""
+			}
+			continue
+		}
+		if depth > 0 {
+			depth += strings.Count(line, "[") - strings.Count(line, "]")
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := tableHeader.FindStringSubmatch(line); m != nil {
+			name := m[1]
+			plainTable = name
+			table = name
+			if strings.HasPrefix(line, "[[") {
+				table = fmt.Sprintf("%s[%d]", name, counts[name])
+				counts[name]++
+			}
+			record(table, n)
+			record(plainTable, n)
+			continue
+		}
+
+		m := keyValue.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key := strings.Trim(m[1], `"'`)
+		if table != "" {
+			record(plainTable+"."+key, n)
+			key = table + "." + key
+		}
+		record(key, n)
+
+		value := strings.TrimSpace(line[len(m[0]):])
+		for _, quote := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(value, quote) && !strings.Contains(value[len(quote):], quote) {
+				multiline = quote
+			}
+		}
+		if strings.HasPrefix(value, "[") {
+			depth = strings.Count(value, "[") - strings.Count(value, "]")
+		}
+	}
+
+	return lines
+}
+
+// lineOf returns the line of key, or of the closest enclosing table.
+func lineOf(lines map[string]int, key string) int {
+	for key != "" {
+		if n, ok := lines[key]; ok {
+			return n
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			return 0
+		}
+		key = key[:i]
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	uploads := filepath.Join(dir, "uploads")
+
+	content := `use_directory = "` + uploads + `"
+port = 70000
+colour = "blue"
+log_level = "loud"
+
+[[users]]
+name = "alice"
+auth = "same"
+
+[[users]]
+name = "alice"
+auth = "same"
+role = "owner"
+provider = "saml"
+
+[login_throttle]
+free_attempts = 5
+lockout_threshold = 2
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, got := Check(path, nil)
+
+	want := []struct {
+		line    int
+		warning bool
+		text    string
+	}{
+		{1, true, "use_directory: " + uploads + " does not exist"},
+		{2, false, "port: 70000 out of range"},
+		{3, true, "colour: unknown key"},
+		{4, false, `log_level: unknown log level "loud"`},
+		{11, false, `users[1].name: duplicate user name "alice"`},
+		{12, false, "users[1].auth: same auth code as users[0]"},
+		{13, false, `users[1].role: unknown role "owner"`},
+		{14, false, `users[1].provider: must be "oidc" or "ldap"`},
+		{18, false, "login_throttle.lockout_threshold: must be greater than free_attempts"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Check() returned %d problems, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		p := got[i]
+		if p.Line != w.line || p.Warning != w.warning || !strings.HasPrefix(p.Error(), w.text) {
+			t.Errorf("problem %d = line %d warning %v %q, want line %d warning %v %q", i, p.Line, p.Warning, p.Error(), w.line, w.warning, w.text)
+		}
+	}
+
+	if _, err := os.Stat(uploads); !os.IsNotExist(err) {
+		t.Errorf("Check() created the uploads directory")
+	}
+
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "port") || !strings.Contains(err.Error(), "role") {
+		t.Errorf("Load() error = %v, want it to report every problem", err)
+	}
+}
+
+func TestCheckParseError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("port = 1\nbroken = [\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, got := Check(path, nil)
+	if len(got) != 1 || got[0].Line == 0 || got[0].Warning {
+		t.Errorf("Check() = %+v, want a single parse error with a line number", got)
+	}
+}
+
+func TestCheckLDAPTransport(t *testing.T) {
+	tests := []struct {
+		ldap string
+		want string
+	}{
+		{`url = "ldap://ldap.example.org"`, "ldap.url: passwords are sent in cleartext"},
+		{`url = "ldap://127.0.0.1:389"`, ""},
+		{`url = "ldap://ldap.example.org"` + "\nstart_tls = true", ""},
+		{`url = "ldaps://ldap.example.org"`, ""},
+		{`url = "ldaps://ldap.example.org"` + "\nstart_tls = true", "ldap.start_tls: only applies to ldap:// URLs"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		content := "use_directory = \"" + t.TempDir() + "\"\n\n[ldap]\nuser_dn = \"uid=%s,dc=example,dc=org\"\n" + tt.ldap + "\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+
+		_, got := Check(path, nil)
+		if tt.want == "" && len(got) != 0 || tt.want != "" && (len(got) != 1 || !strings.HasPrefix(got[0].Error(), tt.want)) {
+			t.Errorf("Check() with %q = %v, want %q", tt.ldap, got, tt.want)
+		}
+	}
+}
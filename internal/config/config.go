@@ -2,36 +2,98 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/Firstbober/locara/internal/clientip"
+	"github.com/Firstbober/locara/internal/listen"
+	"github.com/Firstbober/locara/internal/logging"
 )
 
 const (
 	// DefaultPort is the default server port if not specified in config.
 	DefaultPort = 4000
+	// DefaultShutdownTimeout is how long shutdown waits for running
+	// transfers by default.
+	DefaultShutdownTimeout = 30 * time.Second
 	// DefaultConfigPath is the default configuration file path.
 	DefaultConfigPath = "./config.toml"
+	// DefaultOIDCUsernameClaim is the ID token claim used as the user name.
+	DefaultOIDCUsernameClaim = "preferred_username"
+	// DefaultOIDCGroupsClaim is the ID token claim listing the user's groups.
+	DefaultOIDCGroupsClaim = "groups"
+	// DefaultLDAPUserFilter finds the user entry when no user_dn template is set.
+	DefaultLDAPUserFilter = "(uid=%s)"
+	// DefaultLDAPUsernameAttribute holds the user name on the user entry.
+	DefaultLDAPUsernameAttribute = "uid"
+	// DefaultLDAPGroupAttribute lists the groups on the user entry.
+	DefaultLDAPGroupAttribute = "memberOf"
+)
+
+// Default login throttling policy.
+const (
+	DefaultThrottleFreeAttempts     = 3
+	DefaultThrottleBaseDelay        = time.Second
+	DefaultThrottleMaxDelay         = time.Minute
+	DefaultThrottleLockoutThreshold = 10
+	DefaultThrottleLockoutDuration  = 15 * time.Minute
+	DefaultThrottleResetAfter       = time.Hour
 )
 
 // Load reads and parses the TOML configuration file at the given path.
 func Load(path string) (*Config, error) {
-	if err := validateConfigPath(path); err != nil {
-		return nil, fmt.Errorf("config path validation failed: %w", err)
-	}
+	return LoadWithOverrides(path, nil)
+}
 
-	data, err := os.ReadFile(path)
+// LoadWithOverrides reads the TOML configuration file at the given path and
+// applies overrides on top of it, keyed by TOML key (see ApplyOverrides).
+// An empty path builds the configuration from the overrides alone. The
+// uploads directory is created when missing.
+func LoadWithOverrides(path string, overrides map[string]string) (*Config, error) {
+	cfg, err := Read(path, overrides)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cfg.UseDirectory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create uploads directory: %w", err)
 	}
 
+	return cfg, nil
+}
+
+// Read loads and validates the configuration like LoadWithOverrides without
+// changing anything on disk, for commands that only read.
+func Read(path string, overrides map[string]string) (*Config, error) {
 	var cfg Config
-	if err := toml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse TOML: %w", err)
+
+	if path != "" {
+		if err := validateConfigPath(path); err != nil {
+			return nil, fmt.Errorf("config path validation failed: %w", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		if err := toml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse TOML: %w", err)
+		}
 	}
 
-	if err := validateConfig(&cfg); err != nil {
+	if err := ApplyOverrides(&cfg, overrides); err != nil {
+		return nil, fmt.Errorf("invalid override: %w", err)
+	}
+
+	if err := joinProblems(validateConfig(&cfg)); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
 
@@ -55,37 +117,368 @@ func validateConfigPath(path string) error {
 	return nil
 }
 
-func validateConfig(cfg *Config) error {
+// validateConfig fills in defaults and returns every problem with the
+// configuration. It only reads from the filesystem.
+func validateConfig(cfg *Config) []Problem {
+	var ps problems
+
 	if cfg.UseDirectory == "" {
-		return fmt.Errorf("use_directory cannot be empty")
+		ps.add("use_directory", "cannot be empty")
+	} else if absDir, err := filepath.Abs(cfg.UseDirectory); err != nil {
+		ps.add("use_directory", "failed to get absolute path: %v", err)
+	} else {
+		cfg.UseDirectory = absDir
 	}
 
-	absDir, err := filepath.Abs(cfg.UseDirectory)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path for use_directory: %w", err)
+	if cfg.Port == 0 {
+		cfg.Port = DefaultPort
+	} else if cfg.Port < 1 || cfg.Port > 65535 {
+		ps.add("port", "%d out of range", cfg.Port)
 	}
 
-	if err := os.MkdirAll(absDir, 0755); err != nil {
-		return fmt.Errorf("failed to create uploads directory: %w", err)
+	validateListen(&ps, cfg)
+
+	if cfg.LogFormat != "" && cfg.LogFormat != logging.FormatText && cfg.LogFormat != logging.FormatJSON {
+		ps.add("log_format", "must be %q or %q", logging.FormatText, logging.FormatJSON)
+	}
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		ps.add("log_level", "%v", err)
 	}
-	cfg.UseDirectory = absDir
 
-	if cfg.Port <= 0 || cfg.Port > 65535 {
-		cfg.Port = DefaultPort
+	if baseURL, err := normalizeBaseURL(cfg.BaseUrl); err != nil {
+		ps.add("base_url", "%v", err)
+	} else {
+		cfg.BaseUrl = baseURL
 	}
 
-	if len(cfg.Users) == 0 {
-		return fmt.Errorf("at least one user must be configured")
+	if len(cfg.Users) == 0 && !cfg.LoginEnabled() {
+		ps.add("users", "at least one user or login provider must be configured")
 	}
 
+	names := make(map[string]int)
+	codes := make(map[string]int)
+	subjects := make(map[string]int)
+	accounts := make(map[[2]string]int)
 	for i, user := range cfg.Users {
+		key := fmt.Sprintf("users[%d]", i)
+
 		if user.Name == "" {
-			return fmt.Errorf("user %d: name cannot be empty", i)
+			ps.add(key+".name", "cannot be empty")
+		} else if j, dup := names[user.Name]; dup {
+			ps.add(key+".name", "duplicate user name %q, also used by users[%d]", user.Name, j)
+		} else {
+			names[user.Name] = i
 		}
+
+		if user.AuthFile != "" {
+			if user.Auth != "" {
+				ps.add(key+".auth_file", "auth and auth_file are mutually exclusive")
+			} else if auth, err := readSecretFile(user.AuthFile); err != nil {
+				ps.add(key+".auth_file", "%v", err)
+				continue
+			} else {
+				cfg.Users[i].Auth = auth
+				user.Auth = auth
+			}
+		}
+
 		if user.Auth == "" {
-			return fmt.Errorf("user %d: auth code cannot be empty", i)
+			ps.add(key+".auth", "auth code cannot be empty")
+		} else if j, dup := codes[user.Auth]; dup {
+			ps.add(key+".auth", "same auth code as users[%d]", j)
+		} else {
+			codes[user.Auth] = i
+		}
+
+		if user.CertSubject != "" {
+			if j, dup := subjects[strings.ToLower(user.CertSubject)]; dup {
+				ps.add(key+".cert_subject", "same certificate subject as users[%d]", j)
+			} else {
+				subjects[strings.ToLower(user.CertSubject)] = i
+			}
+		}
+
+		if user.Provider != "" || user.Subject != "" {
+			account := [2]string{user.Provider, user.Subject}
+			if user.Provider != "oidc" && user.Provider != "ldap" {
+				ps.add(key+".provider", "must be \"oidc\" or \"ldap\" to link an external account")
+			} else if user.Subject == "" {
+				ps.add(key+".subject", "cannot be empty with provider set")
+			} else if j, dup := accounts[account]; dup {
+				ps.add(key+".subject", "same external account as users[%d]", j)
+			} else {
+				accounts[account] = i
+			}
+		}
+
+		if user.Role == "" {
+			cfg.Users[i].Role = RoleUploader
+		} else if !ValidRole(user.Role) {
+			ps.add(key+".role", "unknown role %q", user.Role)
 		}
 	}
 
-	return nil
+	if cfg.OIDC.Enabled() {
+		validateOIDC(&ps, &cfg.OIDC)
+	}
+
+	if cfg.LDAP.Enabled() {
+		validateLDAP(&ps, &cfg.LDAP)
+	}
+
+	if cfg.TemplatesDir != "" {
+		if info, err := os.Stat(cfg.TemplatesDir); err != nil {
+			ps.add("templates_dir", "%v", err)
+		} else if !info.IsDir() {
+			ps.add("templates_dir", "%s is not a directory", cfg.TemplatesDir)
+		}
+	}
+
+	if _, err := clientip.ParseTrusted(cfg.TrustedProxies); err != nil {
+		ps.add("trusted_proxies", "%v", err)
+	}
+
+	validateTLS(&ps, cfg, len(subjects) > 0)
+
+	validateLoginThrottle(&ps, &cfg.LoginThrottle)
+	validateMetrics(&ps, &cfg.Metrics)
+
+	if cfg.MaxUploadSize < 0 {
+		ps.add("max_upload_size", "cannot be negative")
+	}
+
+	if cfg.ShutdownDelay < 0 {
+		ps.add("shutdown_delay", "cannot be negative")
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	} else if cfg.ShutdownTimeout < 0 {
+		ps.add("shutdown_timeout", "cannot be negative")
+	}
+
+	return ps
+}
+
+// normalizeBaseURL turns the base URL into the form "/prefix" without a
+// trailing slash, or "" when serving from the root.
+func normalizeBaseURL(base string) (string, error) {
+	base = strings.TrimSpace(base)
+	if strings.ContainsAny(base, "?#{} ") || strings.Contains(base, "://") {
+		return "", fmt.Errorf("must be a path such as /locara, got %q", base)
+	}
+
+	base = strings.Trim(base, "/")
+	if base == "" {
+		return "", nil
+	}
+
+	return "/" + base, nil
+}
+
+func validateListen(ps *problems, cfg *Config) {
+	unix := false
+	for i, addr := range cfg.Listen {
+		network, _, err := listen.ParseAddr(addr)
+		if err != nil {
+			ps.add(fmt.Sprintf("listen[%d]", i), "%v", err)
+		}
+		unix = unix || network == "unix"
+	}
+
+	if cfg.ListenSocketMode != "" {
+		if _, err := listen.ParseSocketMode(cfg.ListenSocketMode); err != nil {
+			ps.add("listen_socket_mode", "%v", err)
+		}
+	}
+	if cfg.ListenSocketGroup != "" {
+		if _, err := listen.LookupGroup(cfg.ListenSocketGroup); err != nil {
+			ps.add("listen_socket_group", "%v", err)
+		}
+	}
+	if !unix && (cfg.ListenSocketMode != "" || cfg.ListenSocketGroup != "") {
+		ps.add("listen", "listen_socket_mode and listen_socket_group require a unix: address")
+	}
+}
+
+func validateMetrics(ps *problems, m *Metrics) {
+	if m.Listen == "" {
+		return
+	}
+	if _, _, err := listen.ParseAddr(m.Listen); err != nil {
+		ps.add("metrics.listen", "%v", err)
+	}
+	if !m.Enabled {
+		ps.warn("metrics.listen", "ignored because metrics.enabled is false")
+	}
+}
+
+func validateTLS(ps *problems, cfg *Config, certUsers bool) {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		ps.add("tls_cert", "tls_cert and tls_key must be set together")
+	}
+	for _, f := range []struct{ key, file string }{
+		{"tls_cert", cfg.TLSCert},
+		{"tls_key", cfg.TLSKey},
+		{"tls_client_ca", cfg.TLSClientCA},
+	} {
+		if f.file == "" {
+			continue
+		}
+		if _, err := os.Stat(f.file); err != nil {
+			ps.add(f.key, "%v", err)
+		}
+	}
+
+	if cfg.TLSClientAuth == "" {
+		cfg.TLSClientAuth = ClientAuthNone
+		if cfg.TLSClientCA != "" {
+			cfg.TLSClientAuth = ClientAuthOptional
+		}
+	}
+	switch cfg.TLSClientAuth {
+	case ClientAuthNone:
+		if certUsers {
+			ps.add("tls_client_auth", "users have cert_subject set, but client certificates are not requested")
+		}
+	case ClientAuthOptional, ClientAuthRequire:
+		if cfg.TLSClientCA == "" {
+			ps.add("tls_client_ca", "required when tls_client_auth is %q", cfg.TLSClientAuth)
+		}
+	default:
+		ps.add("tls_client_auth", "must be %q, %q or %q", ClientAuthNone, ClientAuthOptional, ClientAuthRequire)
+	}
+
+	if !cfg.TLSEnabled() {
+		for _, o := range []struct {
+			key string
+			set bool
+		}{
+			{"tls_client_ca", cfg.TLSClientCA != ""},
+			{"http_redirect_addr", cfg.HTTPRedirectAddr != ""},
+			{"hsts_max_age", cfg.HSTSMaxAge != 0},
+		} {
+			if o.set {
+				ps.add(o.key, "requires tls_cert and tls_key")
+			}
+		}
+	}
+	if cfg.HSTSMaxAge < 0 {
+		ps.add("hsts_max_age", "cannot be negative")
+	}
+}
+
+func validateLoginThrottle(ps *problems, t *LoginThrottle) {
+	if t.FreeAttempts == nil {
+		free := DefaultThrottleFreeAttempts
+		t.FreeAttempts = &free
+	}
+	if t.BaseDelay == 0 {
+		t.BaseDelay = DefaultThrottleBaseDelay
+	}
+	if t.MaxDelay == 0 {
+		t.MaxDelay = DefaultThrottleMaxDelay
+	}
+	if t.LockoutThreshold == 0 {
+		t.LockoutThreshold = DefaultThrottleLockoutThreshold
+	}
+	if t.LockoutDuration == 0 {
+		t.LockoutDuration = DefaultThrottleLockoutDuration
+	}
+	if t.ResetAfter == 0 {
+		t.ResetAfter = DefaultThrottleResetAfter
+	}
+
+	if *t.FreeAttempts < 0 || t.BaseDelay < 0 || t.MaxDelay < t.BaseDelay || t.LockoutDuration < 0 || t.ResetAfter < 0 {
+		ps.add("login_throttle", "delays and attempt counts must be positive, with max_delay >= base_delay")
+	}
+	if t.LockoutThreshold <= *t.FreeAttempts {
+		ps.add("login_throttle.lockout_threshold", "must be greater than free_attempts")
+	}
+}
+
+func validateLDAP(ps *problems, ldap *LDAP) {
+	if !strings.HasPrefix(ldap.URL, "ldap://") && !strings.HasPrefix(ldap.URL, "ldaps://") {
+		ps.add("ldap.url", "must start with ldap:// or ldaps://")
+	} else if strings.HasPrefix(ldap.URL, "ldaps://") {
+		if ldap.StartTLS {
+			ps.add("ldap.start_tls", "only applies to ldap:// URLs")
+		}
+	} else if !ldap.StartTLS && !loopbackURL(ldap.URL) {
+		ps.warn("ldap.url", "passwords are sent in cleartext, use ldaps:// or start_tls")
+	}
+	if ldap.UserDN == "" && ldap.BaseDN == "" {
+		ps.add("ldap", "either user_dn or base_dn must be set")
+	}
+	if ldap.UserDN != "" && strings.Count(ldap.UserDN, "%s") != 1 {
+		ps.add("ldap.user_dn", "must contain exactly one %%s")
+	}
+	if ldap.UserFilter == "" {
+		ldap.UserFilter = DefaultLDAPUserFilter
+	}
+	if strings.Count(ldap.UserFilter, "%s") != 1 {
+		ps.add("ldap.user_filter", "must contain exactly one %%s")
+	}
+	if ldap.GroupFilter != "" && strings.Count(ldap.GroupFilter, "%s") != 1 {
+		ps.add("ldap.group_filter", "must contain exactly one %%s")
+	}
+	if ldap.UsernameAttribute == "" {
+		ldap.UsernameAttribute = DefaultLDAPUsernameAttribute
+	}
+	if ldap.GroupAttribute == "" {
+		ldap.GroupAttribute = DefaultLDAPGroupAttribute
+	}
+
+	validateRoleMapping(ps, "ldap", &ldap.RoleMapping)
+}
+
+// loopbackURL reports whether the host of rawURL is this machine.
+func loopbackURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateOIDC(ps *problems, oidc *OIDC) {
+	if oidc.ClientID == "" {
+		ps.add("oidc.client_id", "cannot be empty")
+	}
+	if oidc.RedirectURL == "" {
+		ps.add("oidc.redirect_url", "cannot be empty")
+	}
+	if oidc.UsernameClaim == "" {
+		oidc.UsernameClaim = DefaultOIDCUsernameClaim
+	}
+	if oidc.GroupsClaim == "" {
+		oidc.GroupsClaim = DefaultOIDCGroupsClaim
+	}
+
+	validateRoleMapping(ps, "oidc", &oidc.RoleMapping)
+}
+
+func validateRoleMapping(ps *problems, section string, mapping *RoleMapping) {
+	groups := make([]string, 0, len(mapping.Roles))
+	for group := range mapping.Roles {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		if role := mapping.Roles[group]; !ValidRole(role) {
+			ps.add(section+".roles."+group, "unknown role %q", role)
+		}
+	}
+	if mapping.DefaultRole != "" && !ValidRole(mapping.DefaultRole) {
+		ps.add(section+".default_role", "unknown role %q", mapping.DefaultRole)
+	}
+}
+
+// ValidRole reports whether role is one of the known user roles.
+func ValidRole(role string) bool {
+	return role == RoleUploader || role == RoleAdmin
 }
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Diff describes what changed between two configurations, one line per
+// setting. Users are compared by name, and secret values are never included.
+func Diff(old, new *Config) []string {
+	var changes []string
+
+	oldUsers := make(map[string]User, len(old.Users))
+	for _, u := range old.Users {
+		oldUsers[u.Name] = u
+	}
+	newUsers := make(map[string]bool, len(new.Users))
+
+	for _, u := range new.Users {
+		newUsers[u.Name] = true
+		prev, ok := oldUsers[u.Name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("user %q added with role %s", u.Name, u.Role))
+		default:
+			changes = diffFields(changes, fmt.Sprintf("user %q ", u.Name), reflect.ValueOf(prev), reflect.ValueOf(u))
+		}
+	}
+	for _, u := range old.Users {
+		if !newUsers[u.Name] {
+			changes = append(changes, fmt.Sprintf("user %q removed", u.Name))
+		}
+	}
+
+	return diffFields(changes, "", reflect.ValueOf(*old), reflect.ValueOf(*new))
+}
+
+// diffFields compares the fields of two structs of the same type, descending
+// into nested sections and naming settings by their TOML keys.
+func diffFields(changes []string, prefix string, old, new reflect.Value) []string {
+	t := old.Type()
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Type == reflect.TypeFor[[]User]() {
+			continue
+		}
+
+		key := prefix
+		if name, _, _ := strings.Cut(field.Tag.Get("toml"), ","); name != "" {
+			key = prefix + name
+		}
+
+		a, b := old.Field(i), new.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			sub := key
+			if !field.Anonymous {
+				sub += "."
+			}
+			changes = diffFields(changes, sub, a, b)
+			continue
+		}
+
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			continue
+		}
+
+		if field.Tag.Get("secret") == "true" {
+			changes = append(changes, key+" changed")
+		} else {
+			changes = append(changes, fmt.Sprintf("%s changed from %s to %s", key, formatValue(a), formatValue(b)))
+		}
+	}
+
+	return changes
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "unset"
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
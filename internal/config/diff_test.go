@@ -0,0 +1,60 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Config{
+		Port:      4000,
+		SecretKey: "old-secret",
+		Users: []User{
+			{Name: "alice", Auth: "a", Role: RoleUploader},
+			{Name: "bob", Auth: "b", Role: RoleUploader},
+			{Name: "dave", Role: RoleUploader, CertSubject: "CN=dave", Provider: "oidc", Subject: "sub-dave"},
+		},
+		OIDC:          OIDC{RoleMapping: RoleMapping{DefaultRole: RoleUploader}},
+		LoginThrottle: LoginThrottle{MaxDelay: time.Minute},
+	}
+	new := &Config{
+		Port:      4000,
+		SecretKey: "new-secret",
+		Users: []User{
+			{Name: "alice", Auth: "a2", Role: RoleAdmin},
+			{Name: "carol", Auth: "c", Role: RoleUploader},
+			{Name: "dave", Role: RoleUploader, CertSubject: "CN=dave,O=Example", Provider: "ldap", Subject: "uid=dave,dc=example,dc=org"},
+		},
+		OIDC:          OIDC{RoleMapping: RoleMapping{DefaultRole: RoleAdmin}},
+		LoginThrottle: LoginThrottle{MaxDelay: 2 * time.Minute},
+	}
+
+	got := Diff(old, new)
+	want := []string{
+		`user "alice" auth changed`,
+		`user "alice" role changed from "uploader" to "admin"`,
+		`user "carol" added with role uploader`,
+		`user "dave" cert_subject changed from "CN=dave" to "CN=dave,O=Example"`,
+		`user "dave" provider changed from "oidc" to "ldap"`,
+		`user "dave" subject changed from "sub-dave" to "uid=dave,dc=example,dc=org"`,
+		`user "bob" removed`,
+		`secret_key changed`,
+		`oidc.default_role changed from "uploader" to "admin"`,
+		`login_throttle.max_delay changed from 1m0s to 2m0s`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Diff() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, line := range got {
+		if strings.Contains(line, "secret\"") || strings.Contains(line, "a2") {
+			t.Errorf("Diff() leaks a secret: %s", line)
+		}
+	}
+
+	if got := Diff(old, old); len(got) != 0 {
+		t.Errorf("Diff() of identical configs = %v, want none", got)
+	}
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix prefixes the environment variables overriding config keys, e.g.
+// LOCARA_BASE_URL for base_url or LOCARA_OIDC_CLIENT_ID for oidc.client_id.
+const EnvPrefix = "LOCARA_"
+
+// fileSuffix marks an override whose value is read from the named file, for
+// secrets mounted by Docker or Kubernetes (e.g. LOCARA_SECRET_KEY_FILE).
+const fileSuffix = "_file"
+
+// Redacted replaces secret values in printed configurations.
+const Redacted = "REDACTED"
+
+// Key describes a config setting that can be overridden from the
+// environment or the command line.
+type Key struct {
+	// Name is the TOML key, with sections separated by dots.
+	Name   string
+	Type   reflect.Type
+	Secret bool
+}
+
+// Keys lists every overridable setting in the order of the Config struct.
+func Keys() []Key {
+	return appendKeys(nil, "", reflect.TypeFor[Config]())
+}
+
+func appendKeys(keys []Key, prefix string, t reflect.Type) []Key {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous {
+			keys = appendKeys(keys, prefix, field.Type)
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if name == "" {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			keys = appendKeys(keys, prefix+name+".", field.Type)
+			continue
+		}
+
+		keys = append(keys, Key{
+			Name:   prefix + name,
+			Type:   field.Type,
+			Secret: field.Tag.Get("secret") == "true",
+		})
+	}
+
+	return keys
+}
+
+// EnvName returns the environment variable overriding a key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// EnvOverrides collects overrides from the LOCARA_* environment variables
+// using lookup, usually os.LookupEnv. Secrets can also be given as the path
+// of a file holding them with a _FILE suffix.
+func EnvOverrides(lookup func(string) (string, bool)) map[string]string {
+	overrides := make(map[string]string)
+
+	for _, key := range Keys() {
+		if v, ok := lookup(EnvName(key.Name)); ok {
+			overrides[key.Name] = v
+		}
+		if !key.Secret {
+			continue
+		}
+		if v, ok := lookup(EnvName(key.Name + fileSuffix)); ok {
+			overrides[key.Name+fileSuffix] = v
+		}
+	}
+
+	return overrides
+}
+
+// ApplyOverrides sets config keys from their string form. Strings and
+// durations are taken as is, numbers and booleans are parsed, lists of
+// strings may be comma separated, and anything else (e.g. users) is read as
+// a TOML value. A secret key with a _file suffix is read from that file.
+func ApplyOverrides(cfg *Config, overrides map[string]string) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	byName := make(map[string]Key)
+	for _, key := range Keys() {
+		byName[key.Name] = key
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	doc := make(map[string]any)
+	for _, name := range names {
+		raw := overrides[name]
+
+		key, ok := byName[name]
+		if base, isFile := strings.CutSuffix(name, fileSuffix); !ok && isFile && byName[base].Secret {
+			secret, err := readSecretFile(raw)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			key, ok, raw = byName[base], true, secret
+		}
+		if !ok {
+			return fmt.Errorf("unknown key %q", name)
+		}
+
+		value, err := overrideValue(key.Type, raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key.Name, err)
+		}
+		setPath(doc, strings.Split(key.Name, "."), value)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+		return err
+	}
+	if _, err := toml.Decode(buf.String(), cfg); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// overrideValue converts the string form of an override to a value of the
+// type the TOML decoder expects for t.
+func overrideValue(t reflect.Type, raw string) (any, error) {
+	if t == reflect.TypeFor[time.Duration]() {
+		return raw, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return raw, nil
+	case reflect.Int, reflect.Int64:
+		return strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(strings.TrimSpace(raw))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			var list []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			return list, nil
+		}
+	}
+
+	var doc map[string]any
+	if err := toml.Unmarshal([]byte("value = "+raw), &doc); err != nil {
+		return nil, fmt.Errorf("invalid TOML value: %w", err)
+	}
+
+	return doc["value"], nil
+}
+
+func setPath(doc map[string]any, path []string, value any) {
+	for _, name := range path[:len(path)-1] {
+		sub, ok := doc[name].(map[string]any)
+		if !ok {
+			sub = make(map[string]any)
+			doc[name] = sub
+		}
+		doc = sub
+	}
+	doc[path[len(path)-1]] = value
+}
+
+// readSecretFile reads a secret from a file, dropping the trailing newline
+// most editors and secret stores add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Redact returns a copy of the configuration with every non-empty secret
+// replaced by Redacted, safe for printing.
+func Redact(cfg *Config) *Config {
+	out := *cfg
+	redactValue(reflect.ValueOf(&out).Elem())
+	return &out
+}
+
+func redactValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if field.Tag.Get("secret") == "true" && v.Field(i).Kind() == reflect.String {
+				if v.Field(i).String() != "" {
+					v.Field(i).SetString(Redacted)
+				}
+				continue
+			}
+			redactValue(v.Field(i))
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+		for i := range copied.Len() {
+			redactValue(copied.Index(i))
+		}
+		v.Set(copied)
+	}
+}
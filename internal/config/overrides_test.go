@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadWithOverrides(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	content := `
+use_directory = "` + filepath.Join(dir, "uploads") + `"
+port = 4000
+base_url = "/from-file"
+
+[[users]]
+name = "alice"
+auth_file = "` + filepath.Join(dir, "alice") + `"
+
+[login_throttle]
+max_delay = "30s"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "alice"), []byte("alice-code\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("file-secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	env := map[string]string{
+		"LOCARA_PORT":                         "5000",
+		"LOCARA_BASE_URL":                     "/from-env",
+		"LOCARA_TRUSTED_PROXIES":              "10.0.0.0/8, 127.0.0.1",
+		"LOCARA_SECRET_KEY_FILE":              filepath.Join(dir, "secret"),
+		"LOCARA_LOGIN_THROTTLE_BASE_DELAY":    "2s",
+		"LOCARA_LOGIN_THROTTLE_FREE_ATTEMPTS": "0",
+		"LOCARA_OIDC_DEFAULT_ROLE":            RoleAdmin,
+		"LOCARA_UNRELATED":                    "ignored",
+	}
+	overrides := EnvOverrides(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	// Flags are applied after the environment and win.
+	overrides["base_url"] = "/from-flag"
+
+	if _, err := Read(path, overrides); err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "uploads")); !os.IsNotExist(err) {
+		t.Errorf("Read() created the uploads directory")
+	}
+
+	cfg, err := LoadWithOverrides(path, overrides)
+	if err != nil {
+		t.Fatalf("LoadWithOverrides() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "uploads")); err != nil {
+		t.Errorf("LoadWithOverrides() did not create the uploads directory: %v", err)
+	}
+
+	if cfg.Port != 5000 {
+		t.Errorf("Port = %d, want 5000 from the environment", cfg.Port)
+	}
+	if cfg.BaseUrl != "/from-flag" {
+		t.Errorf("BaseUrl = %q, want /from-flag", cfg.BaseUrl)
+	}
+	if !slices.Equal(cfg.TrustedProxies, []string{"10.0.0.0/8", "127.0.0.1"}) {
+		t.Errorf("TrustedProxies = %v", cfg.TrustedProxies)
+	}
+	if cfg.SecretKey != "file-secret" {
+		t.Errorf("SecretKey = %q, want it read from file", cfg.SecretKey)
+	}
+	if cfg.LoginThrottle.BaseDelay != 2*time.Second || cfg.LoginThrottle.MaxDelay != 30*time.Second {
+		t.Errorf("LoginThrottle = %+v, want base delay from env and max delay from file", cfg.LoginThrottle)
+	}
+	if free := cfg.LoginThrottle.Free(); free != 0 {
+		t.Errorf("LoginThrottle.Free() = %d, want 0 from the environment", free)
+	}
+	if cfg.OIDC.DefaultRole != RoleAdmin {
+		t.Errorf("OIDC.DefaultRole = %q, want %q", cfg.OIDC.DefaultRole, RoleAdmin)
+	}
+	if len(cfg.Users) != 1 || cfg.Users[0].Auth != "alice-code" {
+		t.Errorf("Users = %+v, want alice with auth code read from file", cfg.Users)
+	}
+
+	redacted := Redact(cfg)
+	if redacted.SecretKey != Redacted || redacted.Users[0].Auth != Redacted {
+		t.Errorf("Redact() left secrets: %+v", redacted)
+	}
+	if cfg.Users[0].Auth != "alice-code" {
+		t.Errorf("Redact() modified the original config")
+	}
+}
+
+func TestApplyOverridesErrors(t *testing.T) {
+	tests := map[string]map[string]string{
+		"unknown key":     {"no_such_key": "1"},
+		"invalid number":  {"port": "eighty"},
+		"invalid boolean": {"dev_mode": "maybe"},
+		"file of public":  {"base_url_file": "/dev/null"},
+		"missing file":    {"secret_key_file": "/nonexistent/secret"},
+		"invalid users":   {"users": "[{name = "},
+	}
+
+	for name, overrides := range tests {
+		if err := ApplyOverrides(&Config{}, overrides); err == nil {
+			t.Errorf("%s: ApplyOverrides() succeeded, want error", name)
+		}
+	}
+
+	cfg := &Config{}
+	err := ApplyOverrides(cfg, map[string]string{"users": `[{name = "bob", auth = "b", role = "admin"}]`})
+	if err != nil {
+		t.Fatalf("ApplyOverrides() failed: %v", err)
+	}
+	if len(cfg.Users) != 1 || cfg.Users[0].Name != "bob" || cfg.Users[0].Role != RoleAdmin {
+		t.Errorf("Users = %+v, want bob as admin", cfg.Users)
+	}
+}
@@ -1,15 +1,189 @@
 package config
 
+import (
+	"fmt"
+	"time"
+)
+
+// Roles a user can hold. Uploaders can add archives and manage their own
+// share links, admins can additionally manage everyone's.
+const (
+	RoleUploader = "uploader"
+	RoleAdmin    = "admin"
+)
+
 // Config represents the application configuration loaded from TOML file.
+// Fields tagged secret:"true" are never logged or printed.
 type Config struct {
 	UseDirectory string `toml:"use_directory"`
 	Port         int    `toml:"port"`
-	BaseUrl      string `toml:"base_url"`
-	Users        []User `toml:"users"`
+	// Listen replaces port with TCP addresses (":4000", "127.0.0.1:4000")
+	// and Unix sockets ("unix:/run/locara/locara.sock").
+	Listen            []string `toml:"listen"`
+	ListenSocketMode  string   `toml:"listen_socket_mode"`
+	ListenSocketGroup string   `toml:"listen_socket_group"`
+	BaseUrl           string   `toml:"base_url"`
+	SecretKey         string   `toml:"secret_key" secret:"true"`
+	DevMode           bool     `toml:"dev_mode"`
+	TemplatesDir      string   `toml:"templates_dir"`
+	LogFormat         string   `toml:"log_format"` // "text" (default) or "json"
+	LogLevel          string   `toml:"log_level"`  // debug, info (default), warn or error
+	Users             []User   `toml:"users"`
+
+	// MaxUploadSize limits the size of an upload request in bytes, 0 for
+	// no limit.
+	MaxUploadSize int64 `toml:"max_upload_size"`
+
+	TrustedProxies []string `toml:"trusted_proxies"`
+
+	// ShutdownDelay keeps serving after a shutdown signal while /readyz
+	// fails, so load balancers stop sending traffic first. ShutdownTimeout
+	// then bounds how long running uploads and downloads may take to finish.
+	ShutdownDelay   time.Duration `toml:"shutdown_delay"`
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+
+	TLSCert               string        `toml:"tls_cert"`
+	TLSKey                string        `toml:"tls_key"`
+	TLSClientCA           string        `toml:"tls_client_ca"`
+	TLSClientAuth         string        `toml:"tls_client_auth"`
+	HTTPRedirectAddr      string        `toml:"http_redirect_addr"`
+	HSTSMaxAge            time.Duration `toml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `toml:"hsts_include_subdomains"`
+
+	OIDC OIDC `toml:"oidc"`
+	LDAP LDAP `toml:"ldap"`
+
+	LoginThrottle LoginThrottle `toml:"login_throttle"`
+
+	Metrics Metrics `toml:"metrics"`
 }
 
+// Client certificate policies for tls_client_auth.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
 // User represents a user with authorization code for uploading archives.
 type User struct {
-	Name string `toml:"name"`
-	Auth string `toml:"auth"`
+	Name     string `toml:"name"`
+	Auth     string `toml:"auth" secret:"true"`
+	AuthFile string `toml:"auth_file"`
+	Role     string `toml:"role"`
+	// CertSubject logs the user in when they present a client certificate
+	// with this subject, e.g. "CN=alice,O=Example".
+	CertSubject string `toml:"cert_subject"`
+	// Provider and Subject link the user to an account of an external
+	// identity provider, "oidc" with its sub claim or "ldap" with its DN,
+	// which then logs in as this user.
+	Provider string `toml:"provider"`
+	Subject  string `toml:"subject"`
+}
+
+// OIDC configures single sign-on against an OpenID Connect identity provider.
+type OIDC struct {
+	Issuer        string   `toml:"issuer"`
+	ClientID      string   `toml:"client_id"`
+	ClientSecret  string   `toml:"client_secret" secret:"true"`
+	RedirectURL   string   `toml:"redirect_url"`
+	Scopes        []string `toml:"scopes"`
+	UsernameClaim string   `toml:"username_claim"`
+	GroupsClaim   string   `toml:"groups_claim"`
+	RoleMapping
+}
+
+// LDAP configures username/password login validated by binding to an LDAP
+// directory. With UserDN set the user's DN is built from the template,
+// otherwise the user is looked up under BaseDN with UserFilter first.
+// StartTLS upgrades ldap:// connections to TLS before anything is sent.
+type LDAP struct {
+	URL                string `toml:"url"`
+	StartTLS           bool   `toml:"start_tls"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+	BindDN             string `toml:"bind_dn"`
+	BindPassword       string `toml:"bind_password" secret:"true"`
+	UserDN             string `toml:"user_dn"`
+	BaseDN             string `toml:"base_dn"`
+	UserFilter         string `toml:"user_filter"`
+	UsernameAttribute  string `toml:"username_attribute"`
+	GroupAttribute     string `toml:"group_attribute"`
+	GroupBaseDN        string `toml:"group_base_dn"`
+	GroupFilter        string `toml:"group_filter"`
+	RoleMapping
+}
+
+// LoginThrottle limits failed authentication attempts. After FreeAttempts
+// failures each further attempt is delayed, starting at BaseDelay and
+// doubling up to MaxDelay; LockoutThreshold failures lock the client or user
+// out for LockoutDuration. Failures are forgotten after ResetAfter.
+// FreeAttempts is a pointer so that 0, delaying from the first failure, can
+// be told apart from unset.
+type LoginThrottle struct {
+	Disabled         bool          `toml:"disabled"`
+	FreeAttempts     *int          `toml:"free_attempts"`
+	BaseDelay        time.Duration `toml:"base_delay"`
+	MaxDelay         time.Duration `toml:"max_delay"`
+	LockoutThreshold int           `toml:"lockout_threshold"`
+	LockoutDuration  time.Duration `toml:"lockout_duration"`
+	ResetAfter       time.Duration `toml:"reset_after"`
+}
+
+// Metrics configures the Prometheus metrics endpoint at /metrics. With
+// Listen set it is served on that address only instead of the main
+// listeners, and with Token set scrapes must send it as a bearer token.
+type Metrics struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"`
+	Token   string `toml:"token" secret:"true"`
+}
+
+// RoleMapping maps groups reported by an external identity provider to
+// Locara roles.
+type RoleMapping struct {
+	Roles         map[string]string `toml:"roles"`
+	DefaultRole   string            `toml:"default_role"`
+	AutoProvision bool              `toml:"auto_provision"`
+}
+
+// Enabled reports whether OIDC login is configured.
+func (o *OIDC) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// Enabled reports whether LDAP login is configured.
+func (l *LDAP) Enabled() bool {
+	return l.URL != ""
+}
+
+// Free returns the number of failures allowed without delay.
+func (t *LoginThrottle) Free() int {
+	if t.FreeAttempts == nil {
+		return DefaultThrottleFreeAttempts
+	}
+	return *t.FreeAttempts
+}
+
+// ListenAddrs returns the addresses to listen on, defaulting to all
+// interfaces on the configured port.
+func (c *Config) ListenAddrs() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{fmt.Sprintf(":%d", c.Port)}
+}
+
+// TLSEnabled reports whether Locara terminates TLS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCert != ""
+}
+
+// ClientCertsEnabled reports whether TLS client certificates are requested.
+func (c *Config) ClientCertsEnabled() bool {
+	return c.TLSClientAuth == ClientAuthOptional || c.TLSClientAuth == ClientAuthRequire
+}
+
+// LoginEnabled reports whether any interactive login provider is configured.
+func (c *Config) LoginEnabled() bool {
+	return c.OIDC.Enabled() || c.LDAP.Enabled()
 }
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package config
+
+import "os"
+
+// writable reports whether dir grants write permission.
+func writable(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.Mode().Perm()&0200 != 0
+}
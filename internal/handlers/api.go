@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/buildinfo"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/metrics"
+	"github.com/Firstbober/locara/internal/models"
+	"github.com/Firstbober/locara/internal/share"
+	"github.com/Firstbober/locara/internal/storage"
+	"github.com/Firstbober/locara/internal/transfer"
+)
+
+// APIPrefix is the path below the base URL the versioned API is served at.
+const APIPrefix = "/api/v1"
+
+// maxPatchSize bounds the JSON body of metadata updates.
+const maxPatchSize = 1 << 20
+
+// legacyAPIDeprecated is when the unversioned API routes were deprecated in
+// favour of APIPrefix, announced in their Deprecation header.
+var legacyAPIDeprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+var errNotAllowed = errors.New("not allowed to change this archive")
+
+// archivePatch is the body of a metadata update. Fields left out keep their
+// value.
+type archivePatch struct {
+	Name    *string `json:"name"`
+	DatedOn *string `json:"dated_on"`
+	Type    *string `json:"type"`
+	Author  *string `json:"author"`
+}
+
+// UploadArchiveHandler creates an archive from a multipart upload and
+// returns its metadata.
+func UploadArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, throttle *auth.Throttle, transfers *transfer.Tracker) {
+	identity, ok := authenticateBearer(w, r, cfg, throttle)
+	if !ok {
+		return
+	}
+
+	upload, rerr := startUpload(w, r, cfg, transfers)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+	defer upload.Finish()
+
+	if rerr := parseUpload(r, cfg); rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+
+	meta, rerr := storeUpload(r, cfg, upload, identity, apiUploadFields)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+
+	w.Header().Set("Location", archiveURL(cfg, meta.ID))
+	writeJSON(w, r, http.StatusCreated, meta)
+}
+
+// GetArchiveHandler returns the metadata of an archive.
+func GetArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	id, rerr := archiveID(r)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+
+	archive, err := storage.GetArchive(cfg.UseDirectory, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get archive metadata", "archive_id", id, "error", err)
+		countStorageError(metrics.OpRead, err)
+		writeRequestError(w, r, archiveLookupError(err))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, archive)
+}
+
+// UpdateArchiveHandler changes the metadata of an archive. Only its uploader
+// and admins may do so.
+func UpdateArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, throttle *auth.Throttle) {
+	user, ok := authenticateBearer(w, r, cfg, throttle)
+	if !ok {
+		return
+	}
+
+	id, rerr := archiveID(r)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != "application/merge-patch+json" {
+		writeJSONError(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Request body must be JSON")
+		return
+	}
+
+	var patch archivePatch
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		writeJSONError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body: unexpected data after the object")
+		return
+	}
+	if field, ok := patch.emptyField(); ok {
+		writeJSONError(w, r, http.StatusBadRequest, codeInvalidRequest, field+" cannot be empty")
+		return
+	}
+
+	archive, err := storage.UpdateArchive(cfg.UseDirectory, id, func(a *models.Archive) error {
+		if !mayChange(user, a) {
+			return errNotAllowed
+		}
+		patch.apply(a)
+		return nil
+	})
+	if err != nil {
+		writeRequestError(w, r, changeError(r, id, err))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Archive updated", "archive_id", id, "user", user.Name)
+	writeJSON(w, r, http.StatusOK, archive)
+}
+
+// DeleteArchiveHandler deletes an archive, its file and every share link to
+// it. Only its uploader and admins may do so. The links are revoked first:
+// IDs are reused, so a link outliving its archive would serve the next one.
+func DeleteArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, shares *share.Store, throttle *auth.Throttle) {
+	user, ok := authenticateBearer(w, r, cfg, throttle)
+	if !ok {
+		return
+	}
+
+	id, rerr := archiveID(r)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+
+	archive, err := storage.GetArchive(cfg.UseDirectory, id)
+	if err == nil && !mayChange(user, archive) {
+		err = errNotAllowed
+	}
+	if err != nil {
+		writeRequestError(w, r, changeError(r, id, err))
+		return
+	}
+
+	n, err := shares.RevokeArchive(id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to revoke share links of archive to delete", "archive_id", id, "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, codeInternal, "Failed to revoke share links")
+		return
+	}
+	if n > 0 {
+		slog.InfoContext(r.Context(), "Share links revoked", "archive_id", id, "count", n)
+	}
+
+	if err := storage.DeleteArchive(cfg.UseDirectory, id); err != nil {
+		writeRequestError(w, r, changeError(r, id, err))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Archive deleted", "archive_id", id, "name", archive.Name, "user", user.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ArchiveFileHandler sends the file of an archive.
+func ArchiveFileHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, transfers *transfer.Tracker) {
+	id, rerr := archiveID(r)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+
+	file, archive, rerr := openArchiveFile(r, cfg, id)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+	defer file.Close()
+
+	sendArchiveFile(w, r, transfers, file, archive)
+}
+
+// APINotFoundHandler answers requests to unknown API routes, which would
+// otherwise fall through to the index page.
+func APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, r, http.StatusNotFound, codeNotFound, "No such API route")
+}
+
+// OpenAPIHandler serves the OpenAPI document of the versioned API, pointing
+// it at the configured base URL.
+func OpenAPIHandler(cfg *config.Config) http.HandlerFunc {
+	var spec map[string]any
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		panic("invalid embedded OpenAPI document: " + err.Error())
+	}
+	spec["servers"] = []map[string]string{{"url": cfg.BaseUrl + APIPrefix}}
+	if info, ok := spec["info"].(map[string]any); ok {
+		info["version"] = buildinfo.Get().Version
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, spec)
+	}
+}
+
+// Deprecated marks responses of an unversioned API route as deprecated,
+// linking to its successor below APIPrefix. "{id}" in successor is replaced
+// by the ID in the request path.
+func Deprecated(cfg *config.Config, successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link := cfg.BaseUrl + APIPrefix + strings.ReplaceAll(successor, "{id}", url.PathEscape(r.PathValue("id")))
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(legacyAPIDeprecated.Unix(), 10))
+		w.Header().Set("Link", "<"+link+`>; rel="successor-version"`)
+		next(w, r)
+	}
+}
+
+// archiveID parses the archive ID in the request path.
+func archiveID(r *http.Request) (int, *requestError) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		return 0, &requestError{http.StatusBadRequest, codeInvalidID, "Invalid archive ID"}
+	}
+	return id, nil
+}
+
+// archiveURL returns the API URL of the archive with the given ID.
+func archiveURL(cfg *config.Config, id int) string {
+	return cfg.BaseUrl + APIPrefix + "/archives/" + strconv.Itoa(id)
+}
+
+// mayChange reports whether the user may update or delete the archive.
+func mayChange(user *auth.Identity, archive *models.Archive) bool {
+	return user.IsAdmin() || user.Name == archive.Uploader
+}
+
+// changeError is the answer to a failed update or deletion of an archive.
+func changeError(r *http.Request, id int, err error) *requestError {
+	switch {
+	case errors.Is(err, errNotAllowed):
+		slog.WarnContext(r.Context(), "Archive change refused", "archive_id", id)
+		return &requestError{http.StatusForbidden, codeForbidden, "Not allowed to change this archive"}
+	case errors.Is(err, fs.ErrNotExist):
+		return errArchiveNotFound
+	default:
+		slog.ErrorContext(r.Context(), "Failed to change archive", "archive_id", id, "error", err)
+		countStorageError(metrics.OpWrite, err)
+		return &requestError{http.StatusInternalServerError, codeInternal, "Failed to change archive"}
+	}
+}
+
+// emptyField returns the JSON name of a field set to an empty value.
+func (p *archivePatch) emptyField() (string, bool) {
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"name", p.Name},
+		{"dated_on", p.DatedOn},
+		{"type", p.Type},
+		{"author", p.Author},
+	} {
+		if f.value != nil && strings.TrimSpace(*f.value) == "" {
+			return f.name, true
+		}
+	}
+	return "", false
+}
+
+func (p *archivePatch) apply(a *models.Archive) {
+	if p.Name != nil {
+		a.Name = *p.Name
+	}
+	if p.DatedOn != nil {
+		a.DatedOn = *p.DatedOn
+	}
+	if p.Type != nil {
+		a.Type = *p.Type
+	}
+	if p.Author != nil {
+		a.Author = *p.Author
+	}
+}
@@ -2,143 +2,340 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Firstbober/locara/internal/auth"
 	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/logging"
+	"github.com/Firstbober/locara/internal/metrics"
 	"github.com/Firstbober/locara/internal/models"
 	"github.com/Firstbober/locara/internal/storage"
+	"github.com/Firstbober/locara/internal/transfer"
 )
 
-// CreateArchiveHandler handles file uploads with metadata.
-func CreateArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		log.Printf("[ERROR] Failed to parse multipart form: %v", err)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+// uploadFields names the multipart fields an upload is read from.
+type uploadFields struct {
+	file, name, datedOn, typ, author string
+}
+
+var (
+	// formUploadFields are the fields of the upload form and the deprecated
+	// upload route.
+	formUploadFields = uploadFields{"ar_file", "ar_name", "ar_dated", "ar_type", "ar_author"}
+	// apiUploadFields are the fields of uploads to the versioned API.
+	apiUploadFields = uploadFields{"file", "name", "dated_on", "type", "author"}
+)
+
+// CreateArchiveHandler handles file uploads with metadata. Browsers are
+// redirected back with a message saying what happened, API clients get the
+// created archive as JSON or an error with a matching status code.
+func CreateArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, sessions *auth.Sessions, throttle *auth.Throttle, transfers *transfer.Tracker) {
+	identity, ok := auth.IdentityFromContext(r.Context())
+
+	ip := clientIP(r)
+	ipKey := auth.IPKey(ip)
+
+	upload, rerr := startUpload(w, r, cfg, transfers)
+	if rerr != nil {
+		failRequest(w, r, cfg, sessions, rerr.status, rerr.code, rerr.message, "/")
 		return
 	}
+	defer upload.Finish()
 
-	authCode := r.FormValue("ar_auth_code")
-	if authCode == "" {
-		log.Printf("[ERROR] Missing auth code")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	if !ok {
+		if wait := throttle.Check(ipKey); wait > 0 {
+			slog.WarnContext(r.Context(), "Upload throttled after failed auth code checks", "client_ip", ip, "wait", wait)
+			setRetryAfter(w, wait)
+			failRequest(w, r, cfg, sessions, http.StatusTooManyRequests, codeThrottled, "Too many failed authentication attempts", "/error")
+			return
+		}
+	}
+
+	if rerr := parseUpload(r, cfg); rerr != nil {
+		failRequest(w, r, cfg, sessions, rerr.status, rerr.code, rerr.message, "/")
 		return
 	}
 
-	if !validateAuthCode(cfg, authCode) {
-		log.Printf("[ERROR] Invalid auth code")
-		http.Redirect(w, r, "/error", http.StatusSeeOther)
+	if !ok {
+		authCode := requestAuthCode(r)
+		if authCode == "" {
+			slog.WarnContext(r.Context(), "Missing auth code")
+			failRequest(w, r, cfg, sessions, http.StatusUnauthorized, codeAuthRequired, "Missing auth code", "/")
+			return
+		}
+
+		user, found := findUser(cfg, authCode)
+		if !found {
+			slog.WarnContext(r.Context(), "Invalid auth code", "client_ip", ip)
+			metrics.AuthFailures.With(auth.ProviderAuthCode).Inc()
+			throttle.Failure(ipKey)
+			failRequest(w, r, cfg, sessions, http.StatusUnauthorized, codeInvalidAuth, "Invalid auth code", "/error")
+			return
+		}
+
+		throttle.Success(ipKey)
+		identity = auth.FromUser(user)
+		logging.SetUser(r.Context(), identity.Name)
+	}
+
+	meta, rerr := storeUpload(r, cfg, upload, identity, formUploadFields)
+	if rerr != nil {
+		failRequest(w, r, cfg, sessions, rerr.status, rerr.code, rerr.message, "/")
 		return
 	}
 
-	file, header, err := r.FormFile("ar_file")
-	if err != nil {
-		log.Printf("[ERROR] Failed to get uploaded file: %v", err)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	location := fmt.Sprintf("%s/api/archive/%d", cfg.BaseUrl, meta.ID)
+	if wantsJSON(r) {
+		w.Header().Set("Location", location)
+		writeJSON(w, r, http.StatusCreated, meta)
 		return
 	}
-	defer file.Close()
+	setFlash(w, r, sessions, flash{
+		Kind:     flashSuccess,
+		Message:  fmt.Sprintf("Uploaded %q as archive #%d.", meta.Name, meta.ID),
+		Link:     location,
+		LinkText: "Download " + meta.FileName,
+	})
+	redirect(w, r, cfg, "/")
+}
+
+// transferIdleTimeout is how long an upload or download may make no progress
+// before the connection is cut.
+const transferIdleTimeout = time.Minute
+
+// startUpload registers the upload in transfers and limits its body to
+// max_upload_size, refusing it when the announced length is larger already.
+// The returned transfer must be finished by the caller.
+func startUpload(w http.ResponseWriter, r *http.Request, cfg *config.Config, transfers *transfer.Tracker) (*transfer.Transfer, *requestError) {
+	if cfg.MaxUploadSize > 0 && r.ContentLength > cfg.MaxUploadSize {
+		slog.WarnContext(r.Context(), "Upload too large", "size", r.ContentLength, "max_upload_size", cfg.MaxUploadSize)
+		return nil, errUploadTooLarge
+	}
 
-	var uploader string
-	for _, user := range cfg.Users {
-		if user.Auth == authCode {
-			uploader = user.Name
-			break
+	// Named by client until the file name is known.
+	upload := transfers.Start(metrics.Upload, clientIP(r), r.ContentLength)
+	upload.KeepAlive(w, transferIdleTimeout)
+	r.Body = upload.Reader(r.Body)
+	if cfg.MaxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadSize)
+	}
+	return upload, nil
+}
+
+// parseUpload reads the multipart body of an upload.
+func parseUpload(r *http.Request, cfg *config.Config) *requestError {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+			slog.WarnContext(r.Context(), "Upload too large", "max_upload_size", cfg.MaxUploadSize)
+			return errUploadTooLarge
 		}
+		slog.ErrorContext(r.Context(), "Failed to parse multipart form", "error", err)
+		return &requestError{http.StatusBadRequest, codeInvalidRequest, "Invalid multipart form"}
 	}
+	return nil
+}
+
+// storeUpload saves the uploaded file as a new archive of identity, with the
+// metadata taken from the given form fields.
+func storeUpload(r *http.Request, cfg *config.Config, upload *transfer.Transfer, identity *auth.Identity, fields uploadFields) (*models.Archive, *requestError) {
+	file, header, err := r.FormFile(fields.file)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to get uploaded file", "error", err)
+		return nil, &requestError{http.StatusBadRequest, codeMissingField, "Missing file in " + fields.file}
+	}
+	defer file.Close()
+	upload.SetName(header.Filename)
 
 	meta := &models.Archive{
-		Uploader:   uploader,
+		Uploader:   identity.Name,
 		FileName:   header.Filename,
 		SizeBytes:  header.Size,
 		MD5Sum:     header.Header.Get("Content-MD5"),
 		UploadedOn: time.Now(),
-		Name:       r.FormValue("ar_name"),
-		DatedOn:    r.FormValue("ar_dated"),
-		Type:       r.FormValue("ar_type"),
-		Author:     r.FormValue("ar_author"),
+		Name:       r.FormValue(fields.name),
+		DatedOn:    r.FormValue(fields.datedOn),
+		Type:       r.FormValue(fields.typ),
+		Author:     r.FormValue(fields.author),
 	}
 
-	if meta.Name == "" || meta.DatedOn == "" || meta.Type == "" || meta.Author == "" {
-		log.Printf("[ERROR] Missing required fields")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+	if missing := missingFields(r, fields.name, fields.datedOn, fields.typ, fields.author); len(missing) > 0 {
+		slog.WarnContext(r.Context(), "Missing required fields", "fields", missing)
+		return nil, &requestError{http.StatusBadRequest, codeMissingField, "Missing required fields: " + strings.Join(missing, ", ")}
 	}
 
-	if err := storage.SaveArchive(cfg.UseDirectory, file, header, meta); err != nil {
-		log.Printf("[ERROR] Failed to save archive: %v", err)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+	if err := storage.SaveArchive(r.Context(), cfg.UseDirectory, file, header, meta); err != nil {
+		if errors.Is(err, storage.ErrChecksumMismatch) {
+			slog.WarnContext(r.Context(), "Upload does not match its Content-MD5", "file_name", header.Filename)
+			return nil, &requestError{http.StatusBadRequest, codeInvalidRequest, "File does not match its Content-MD5"}
+		}
+		slog.ErrorContext(r.Context(), "Failed to save archive", "error", err)
+		countStorageError(metrics.OpSave, err)
+		return nil, &requestError{http.StatusInternalServerError, codeInternal, "Failed to save archive"}
 	}
 
-	log.Printf("[INFO] Archive created: ID=%d, Name=%s", meta.ID, meta.Name)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	slog.InfoContext(r.Context(), "Archive created", "archive_id", meta.ID, "name", meta.Name)
+	return meta, nil
 }
 
-// ListArchivesHandler returns a JSON list of all archives.
+// missingFields returns the names of the form fields that are empty.
+func missingFields(r *http.Request, names ...string) []string {
+	var missing []string
+	for _, name := range names {
+		if r.FormValue(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// ListArchivesHandler returns a JSON list of all archives, narrowed down by
+// the search parameters in the query string.
 func ListArchivesHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	query, rerr := parseArchiveQuery(r)
+	if rerr != nil {
+		writeRequestError(w, r, rerr)
+		return
+	}
+
 	archives, err := storage.ListArchives(cfg.UseDirectory)
 	if err != nil {
-		log.Printf("[ERROR] Failed to list archives: %v", err)
-		writeJSONError(w, http.StatusInternalServerError, "Failed to list archives")
+		slog.ErrorContext(r.Context(), "Failed to list archives", "error", err)
+		countStorageError(metrics.OpList, err)
+		writeJSONError(w, r, http.StatusInternalServerError, codeInternal, "Failed to list archives")
 		return
 	}
+	archives = slices.DeleteFunc(archives, func(a models.Archive) bool { return !query.matches(&a) })
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(archives); err != nil {
-		log.Printf("[ERROR] Failed to encode archives: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to encode archives", "error", err)
 	}
 }
 
+// archiveQuery selects archives by the search parameters of a list request:
+// q for text in the name, author, type or file name, the exact type, author
+// and uploader, and the year the archive is dated in.
+type archiveQuery struct {
+	text, typ, author, uploader, year string
+}
+
+func parseArchiveQuery(r *http.Request) (archiveQuery, *requestError) {
+	values := r.URL.Query()
+	query := archiveQuery{
+		text:     strings.ToLower(strings.TrimSpace(values.Get("q"))),
+		typ:      values.Get("type"),
+		author:   values.Get("author"),
+		uploader: values.Get("uploader"),
+		year:     values.Get("year"),
+	}
+	if _, err := strconv.Atoi(query.year); query.year != "" && (err != nil || len(query.year) != 4) {
+		return query, &requestError{http.StatusBadRequest, codeInvalidRequest, "year must have four digits"}
+	}
+	return query, nil
+}
+
+func (q archiveQuery) matches(a *models.Archive) bool {
+	switch {
+	case q.typ != "" && !strings.EqualFold(a.Type, q.typ),
+		q.author != "" && !strings.EqualFold(a.Author, q.author),
+		q.uploader != "" && a.Uploader != q.uploader,
+		q.year != "" && !strings.HasPrefix(a.DatedOn, q.year):
+		return false
+	case q.text == "":
+		return true
+	}
+	for _, field := range []string{a.Name, a.Author, a.Type, a.FileName} {
+		if strings.Contains(strings.ToLower(field), q.text) {
+			return true
+		}
+	}
+	return false
+}
+
 // DownloadArchiveHandler handles file downloads.
-func DownloadArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+func DownloadArchiveHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, sessions *auth.Sessions, transfers *transfer.Tracker) {
 	idStr := r.PathValue("id")
-	if idStr == "" {
-		log.Printf("[ERROR] Missing archive ID")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Invalid archive ID", "archive_id", idStr)
+		failRequest(w, r, cfg, sessions, http.StatusBadRequest, codeInvalidID, "Invalid archive ID", "/")
 		return
 	}
 
-	var id int
-	if _, err := fmt.Sscanf(idStr, "%d", &id); err != nil {
-		log.Printf("[ERROR] Invalid archive ID: %s", idStr)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	serveArchiveFile(w, r, cfg, sessions, transfers, id)
+}
+
+// serveArchiveFile streams the file of the archive with the given ID as an attachment.
+func serveArchiveFile(w http.ResponseWriter, r *http.Request, cfg *config.Config, sessions *auth.Sessions, transfers *transfer.Tracker, id int) {
+	file, archive, rerr := openArchiveFile(r, cfg, id)
+	if rerr != nil {
+		failRequest(w, r, cfg, sessions, rerr.status, rerr.code, rerr.message, "/")
 		return
 	}
+	defer file.Close()
+
+	sendArchiveFile(w, r, transfers, file, archive)
+}
 
+// openArchiveFile opens the file of the archive with the given ID.
+func openArchiveFile(r *http.Request, cfg *config.Config, id int) (*os.File, *models.Archive, *requestError) {
 	filePath, err := storage.GetArchiveFilePath(cfg.UseDirectory, id)
 	if err != nil {
-		log.Printf("[ERROR] Failed to get archive file: %v", err)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		slog.ErrorContext(r.Context(), "Failed to get archive file", "archive_id", id, "error", err)
+		countStorageError(metrics.OpRead, err)
+		return nil, nil, archiveLookupError(err)
 	}
 
 	archive, err := storage.GetArchive(cfg.UseDirectory, id)
 	if err != nil {
-		log.Printf("[ERROR] Failed to get archive metadata: %v", err)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		slog.ErrorContext(r.Context(), "Failed to get archive metadata", "archive_id", id, "error", err)
+		countStorageError(metrics.OpRead, err)
+		return nil, nil, archiveLookupError(err)
 	}
 
 	file, err := os.Open(filePath)
 	if err != nil {
-		log.Printf("[ERROR] Failed to open file: %v", err)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		slog.ErrorContext(r.Context(), "Failed to open file", "archive_id", id, "error", err)
+		countStorageError(metrics.OpRead, err)
+		return nil, nil, archiveLookupError(err)
 	}
-	defer file.Close()
 
+	return file, archive, nil
+}
+
+// sendArchiveFile streams an opened archive file as an attachment. Range
+// requests are answered with the part asked for so interrupted downloads can
+// be resumed; the upload time serves as Last-Modified for If-Range.
+func sendArchiveFile(w http.ResponseWriter, r *http.Request, transfers *transfer.Tracker, file *os.File, archive *models.Archive) {
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archive.FileName))
 	w.Header().Set("Content-Type", "application/octet-stream")
 
-	if _, err := io.Copy(w, file); err != nil {
-		log.Printf("[ERROR] Failed to send file: %v", err)
+	download := transfers.Start(metrics.Download, archive.FileName, archive.SizeBytes)
+	defer download.Finish()
+	download.KeepAlive(w, transferIdleTimeout)
+	http.ServeContent(download.ResponseWriter(w), r, "", archive.UploadedOn, file)
+	if err := r.Context().Err(); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to send file", "archive_id", archive.ID, "error", err)
 		return
 	}
 
-	log.Printf("[INFO] Archive downloaded: ID=%d", id)
+	slog.InfoContext(r.Context(), "Archive downloaded", "archive_id", archive.ID, "range", r.Header.Get("Range"))
+}
+
+// archiveLookupError is the answer to a failed archive lookup: 404 when the
+// archive does not exist and 500 otherwise.
+func archiveLookupError(err error) *requestError {
+	if errors.Is(err, fs.ErrNotExist) {
+		return errArchiveNotFound
+	}
+	return &requestError{http.StatusInternalServerError, codeInternal, "Failed to read archive"}
 }
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/metrics"
+)
+
+const (
+	oidcLoginCookieName = "locara_oidc"
+	oidcLoginTTL        = 10 * time.Minute
+)
+
+// LoginHandler renders the login page listing the configured providers.
+func LoginHandler(tmpl *template.Template, cfg *config.Config, sessions *auth.Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Cfg   *config.Config
+			User  *auth.Identity
+			Flash *flash
+		}{
+			Cfg:   cfg,
+			User:  requestUser(r),
+			Flash: takeFlash(w, r, sessions),
+		}
+
+		if err := renderTemplate(w, tmpl, "login.html", data); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to render template", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// LogoutHandler ends the login session.
+func LogoutHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, sessions *auth.Sessions) {
+	if user := requestUser(r); user != nil {
+		slog.InfoContext(r.Context(), "User logged out", "user", user.Name)
+	}
+	sessions.Clear(w)
+	redirect(w, r, cfg, "/")
+}
+
+// OIDCLoginHandler starts the authorization code flow by redirecting to the
+// identity provider.
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, provider *auth.OIDCProvider, sessions *auth.Sessions) {
+	login, err := auth.NewOIDCLogin()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start OIDC login", "error", err)
+		redirectError(w, r, cfg, sessions, "/error", "Could not start the login, please try again.")
+		return
+	}
+
+	target, err := provider.AuthCodeURL(r.Context(), login)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start OIDC login", "error", err)
+		redirectError(w, r, cfg, sessions, "/error", "Could not start the login, please try again.")
+		return
+	}
+
+	if err := sessions.SetSigned(w, r, oidcLoginCookieName, login, oidcLoginTTL); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to store OIDC login state", "error", err)
+		redirectError(w, r, cfg, sessions, "/error", "Could not start the login, please try again.")
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallbackHandler completes the authorization code flow, maps the user to
+// a Locara identity and starts a login session.
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, provider *auth.OIDCProvider, sessions *auth.Sessions, users *auth.UserStore) {
+	var login auth.OIDCLogin
+	err := sessions.ReadSigned(r, oidcLoginCookieName, &login)
+	sessions.Delete(w, oidcLoginCookieName)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Missing or invalid OIDC login state", "error", err)
+		redirectError(w, r, cfg, sessions, "/error", "The login expired or was started in another browser, please log in again.")
+		return
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		slog.ErrorContext(r.Context(), "OIDC provider returned error", "error", e, "description", query.Get("error_description"))
+		metrics.AuthFailures.With(auth.ProviderOIDC).Inc()
+		redirectError(w, r, cfg, sessions, "/error", "The identity provider refused the login.")
+		return
+	}
+	if query.Get("state") != login.State {
+		slog.WarnContext(r.Context(), "OIDC state mismatch")
+		redirectError(w, r, cfg, sessions, "/error", "The login expired or was started in another browser, please log in again.")
+		return
+	}
+
+	ext, err := provider.Exchange(r.Context(), query.Get("code"), &login)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "OIDC code exchange failed", "error", err)
+		metrics.AuthFailures.With(auth.ProviderOIDC).Inc()
+		redirectError(w, r, cfg, sessions, "/error", "Could not complete the login, please try again.")
+		return
+	}
+
+	startSession(w, r, cfg, sessions, users, cfg.OIDC.RoleMapping, ext)
+}
+
+// LDAPLoginHandler validates a username and password against the LDAP
+// directory and starts a login session.
+func LDAPLoginHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, provider *auth.LDAPProvider, sessions *auth.Sessions, users *auth.UserStore, throttle *auth.Throttle) {
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	ip := clientIP(r)
+	keys := []string{auth.IPKey(ip), auth.UserKey(username)}
+	if wait := throttle.Check(keys...); wait > 0 {
+		slog.WarnContext(r.Context(), "LDAP login throttled", "username", username, "client_ip", ip, "wait", wait)
+		setRetryAfter(w, wait)
+		redirectError(w, r, cfg, sessions, "/error", "Too many failed logins, please try again later.")
+		return
+	}
+
+	ext, err := provider.Authenticate(r.Context(), username, password)
+	if err != nil {
+		message := "Invalid username or password."
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			slog.WarnContext(r.Context(), "LDAP login failed: invalid credentials", "username", username, "client_ip", ip)
+			metrics.AuthFailures.With(auth.ProviderLDAP).Inc()
+			throttle.Failure(keys...)
+		} else {
+			slog.ErrorContext(r.Context(), "LDAP login failed", "username", username, "error", err)
+			message = "Could not reach the directory server, please try again."
+		}
+		redirectError(w, r, cfg, sessions, "/error", message)
+		return
+	}
+
+	throttle.Success(keys...)
+	startSession(w, r, cfg, sessions, users, cfg.LDAP.RoleMapping, ext)
+}
+
+// startSession resolves an externally authenticated user and logs them in.
+func startSession(w http.ResponseWriter, r *http.Request, cfg *config.Config, sessions *auth.Sessions, users *auth.UserStore, mapping config.RoleMapping, ext *auth.External) {
+	identity, err := auth.Resolve(cfg, users, mapping, *ext)
+	if err != nil {
+		message := "Your account is not allowed to use Locara."
+		if errors.Is(err, auth.ErrNoRole) || errors.Is(err, auth.ErrNotProvisioned) || errors.Is(err, auth.ErrNameTaken) {
+			slog.WarnContext(r.Context(), "Login rejected", "username", ext.Name, "provider", ext.Provider, "error", err)
+			metrics.AuthFailures.With(ext.Provider).Inc()
+		} else {
+			slog.ErrorContext(r.Context(), "Failed to resolve user", "username", ext.Name, "error", err)
+			message = "Could not complete the login, please try again."
+		}
+		redirectError(w, r, cfg, sessions, "/error", message)
+		return
+	}
+
+	if err := sessions.Issue(w, r, identity); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start session", "error", err)
+		redirectError(w, r, cfg, sessions, "/error", "Could not start the login session, please try again.")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "User logged in", "user", identity.Name, "role", identity.Role, "provider", identity.Provider)
+	redirect(w, r, cfg, "/")
+}
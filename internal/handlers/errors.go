@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/config"
+)
+
+// Machine-readable codes of JSON error responses.
+const (
+	codeInvalidRequest = "invalid_request"
+	codeMissingField   = "missing_field"
+	codeInvalidID      = "invalid_id"
+	codeAuthRequired   = "auth_required"
+	codeInvalidAuth    = "invalid_auth"
+	codeForbidden      = "forbidden"
+	codeNotFound       = "not_found"
+	codeTooLarge       = "upload_too_large"
+	codeThrottled      = "too_many_attempts"
+	codeUnavailable    = "unavailable"
+	codeInternal       = "internal_error"
+
+	codeUnsupportedMediaType = "unsupported_media_type"
+)
+
+// requestError is why a request failed, answered to API clients with the
+// status and code and shown to browsers as the message.
+type requestError struct {
+	status  int
+	code    string
+	message string
+}
+
+var (
+	errUploadTooLarge  = &requestError{http.StatusRequestEntityTooLarge, codeTooLarge, "Upload exceeds the maximum size"}
+	errArchiveNotFound = &requestError{http.StatusNotFound, codeNotFound, "Archive not found"}
+)
+
+// errorResponse is the body of JSON error responses.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// writeJSONError writes an HTTP error response as JSON.
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, r, status, errorResponse{Error: message, Code: code})
+}
+
+// writeRequestError answers an API request with the JSON form of e.
+func writeRequestError(w http.ResponseWriter, r *http.Request, e *requestError) {
+	writeJSONError(w, r, e.status, e.code, e.message)
+}
+
+// wantsJSON reports whether the client is an API client expecting JSON
+// rather than a browser following redirects: it calls the versioned API,
+// accepts application/json but not text/html, or calls /api/ with a bearer
+// token.
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, APIPrefix+"/") {
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+
+	json, html := false, false
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			json = true
+		case "text/html":
+			html = true
+		}
+	}
+	return json && !html
+}
+
+// failRequest answers a failed request from a form or link: API clients get
+// the status and a JSON error, browsers are redirected to page showing the
+// message.
+func failRequest(w http.ResponseWriter, r *http.Request, cfg *config.Config, sessions *auth.Sessions, status int, code, message, page string) {
+	if wantsJSON(r) {
+		writeJSONError(w, r, status, code, message)
+		return
+	}
+	redirectError(w, r, cfg, sessions, page, message)
+}
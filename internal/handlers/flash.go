@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/config"
+)
+
+const (
+	flashCookieName = "locara_flash"
+	// flashTTL bounds how long a message waits for the page it was meant for.
+	flashTTL = 5 * time.Minute
+)
+
+// Kinds of flash messages, used as CSS classes by the templates.
+const (
+	flashError   = "error"
+	flashSuccess = "success"
+)
+
+// flash is a message shown once on the next page rendered for the browser,
+// e.g. why the upload it was redirected from failed.
+type flash struct {
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+	Link     string `json:"link,omitempty"`
+	LinkText string `json:"link_text,omitempty"`
+}
+
+// setFlash stores f in a signed cookie for the next page.
+func setFlash(w http.ResponseWriter, r *http.Request, sessions *auth.Sessions, f flash) {
+	if err := sessions.SetSigned(w, r, flashCookieName, f, flashTTL); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set flash message", "error", err)
+	}
+}
+
+// takeFlash returns the pending flash message, if any, and clears it so it
+// is shown only once.
+func takeFlash(w http.ResponseWriter, r *http.Request, sessions *auth.Sessions) *flash {
+	if _, err := r.Cookie(flashCookieName); err != nil {
+		return nil
+	}
+	sessions.Delete(w, flashCookieName)
+
+	var f flash
+	if err := sessions.ReadSigned(r, flashCookieName, &f); err != nil {
+		return nil
+	}
+	return &f
+}
+
+// redirectError redirects the browser to page, showing message there.
+func redirectError(w http.ResponseWriter, r *http.Request, cfg *config.Config, sessions *auth.Sessions, page, message string) {
+	setFlash(w, r, sessions, flash{Kind: flashError, Message: message})
+	redirect(w, r, cfg, page)
+}
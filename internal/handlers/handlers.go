@@ -1,27 +1,131 @@
 package handlers
 
 import (
+	"encoding/json"
 	"html/template"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/clientip"
 	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/logging"
+	"github.com/Firstbober/locara/internal/metrics"
 )
 
-// validateAuthCode checks if the provided auth code matches any configured user.
-func validateAuthCode(cfg *config.Config, code string) bool {
-	for _, user := range cfg.Users {
-		if user.Auth == code {
-			return true
+// bearerChallenge asks API clients without valid credentials for an auth code.
+const bearerChallenge = `Bearer realm="locara"`
+
+// findUser returns the configured user owning the given auth code.
+func findUser(cfg *config.Config, code string) (*config.User, bool) {
+	for i := range cfg.Users {
+		if cfg.Users[i].Auth == code {
+			return &cfg.Users[i], true
 		}
 	}
-	return false
+	return nil, false
+}
+
+// requestAuthCode extracts the auth code from the ar_auth_code form field or
+// an "Authorization: Bearer" header.
+func requestAuthCode(r *http.Request) string {
+	if code := r.FormValue("ar_auth_code"); code != "" {
+		return code
+	}
+	return bearerToken(r)
+}
+
+// bearerToken extracts the auth code from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// requestUser returns the identity of the logged in user, or nil.
+func requestUser(r *http.Request) *auth.Identity {
+	id, _ := auth.IdentityFromContext(r.Context())
+	return id
+}
+
+// authenticateRequest resolves the user behind an API request from the login
+// session or the auth code, answering with a JSON error when neither is valid
+// or the client is throttled after too many failures.
+func authenticateRequest(w http.ResponseWriter, r *http.Request, cfg *config.Config, throttle *auth.Throttle) (*auth.Identity, bool) {
+	return authenticate(w, r, cfg, throttle, requestAuthCode)
+}
+
+// authenticateBearer is authenticateRequest taking the auth code from the
+// Authorization header only, so the request body is not read before the
+// client is known.
+func authenticateBearer(w http.ResponseWriter, r *http.Request, cfg *config.Config, throttle *auth.Throttle) (*auth.Identity, bool) {
+	return authenticate(w, r, cfg, throttle, bearerToken)
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request, cfg *config.Config, throttle *auth.Throttle, authCode func(*http.Request) string) (*auth.Identity, bool) {
+	if id, ok := auth.IdentityFromContext(r.Context()); ok {
+		return id, true
+	}
+
+	ip := clientIP(r)
+	ipKey := auth.IPKey(ip)
+	if wait := throttle.Check(ipKey); wait > 0 {
+		slog.WarnContext(r.Context(), "Authentication throttled", "client_ip", ip, "wait", wait)
+		setRetryAfter(w, wait)
+		writeJSONError(w, r, http.StatusTooManyRequests, codeThrottled, "Too many failed authentication attempts")
+		return nil, false
+	}
+
+	code := authCode(r)
+	if code == "" {
+		w.Header().Set("WWW-Authenticate", bearerChallenge)
+		writeJSONError(w, r, http.StatusUnauthorized, codeAuthRequired, "Missing auth code")
+		return nil, false
+	}
+
+	user, ok := findUser(cfg, code)
+	if !ok {
+		slog.WarnContext(r.Context(), "Invalid auth code", "client_ip", ip)
+		metrics.AuthFailures.With(auth.ProviderAuthCode).Inc()
+		throttle.Failure(ipKey)
+		w.Header().Set("WWW-Authenticate", bearerChallenge)
+		writeJSONError(w, r, http.StatusUnauthorized, codeInvalidAuth, "Invalid auth code")
+		return nil, false
+	}
+
+	throttle.Success(ipKey)
+	logging.SetUser(r.Context(), user.Name)
+	return auth.FromUser(user), true
 }
 
-// writeJSONError writes an HTTP error response as JSON.
-func writeJSONError(w http.ResponseWriter, status int, message string) {
+// clientIP returns the client address resolved from trusted proxy headers.
+func clientIP(r *http.Request) string {
+	return clientip.FromRequest(r)
+}
+
+// setRetryAfter sets the Retry-After header to the wait rounded up to whole seconds.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(status)
-	w.Write([]byte(`{"error":"` + message + `"}`))
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
+	}
+}
+
+// redirect sends a 303 redirect to path below the configured base URL.
+func redirect(w http.ResponseWriter, r *http.Request, cfg *config.Config, path string) {
+	http.Redirect(w, r, cfg.BaseUrl+path, http.StatusSeeOther)
 }
 
 // renderTemplate renders an HTML template with the given data.
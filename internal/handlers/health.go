@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Firstbober/locara/internal/buildinfo"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+// HealthzHandler reports that the process is alive and serving requests.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// writableTTL is how long a successful write check of the uploads directory
+// is trusted, so frequent probes do not each create a file in it.
+const writableTTL = 30 * time.Second
+
+// ReadyzHandler reports whether the server should receive traffic: the
+// uploads directory is reachable and writable and shutdown has not started.
+// The checks cost a stat and, at most every writableTTL while they pass, a
+// file creation whatever the number of archives. Failed checks are logged
+// with their cause and only named in the response.
+func ReadyzHandler(cfg *config.Config, stopping *atomic.Bool) http.HandlerFunc {
+	var mu sync.Mutex
+	var writable time.Time
+
+	checkWritable := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if time.Since(writable) < writableTTL {
+			return nil
+		}
+		if err := storage.CheckWritable(cfg.UseDirectory); err != nil {
+			return err
+		}
+		writable = time.Now()
+		return nil
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]string{}
+		ready := true
+
+		check := func(name string, err error) {
+			if err != nil {
+				slog.WarnContext(r.Context(), "Readiness check failed", "check", name, "error", err)
+				checks[name] = "failed"
+				ready = false
+				return
+			}
+			checks[name] = "ok"
+		}
+
+		check("storage", storageReachable(cfg.UseDirectory))
+		check("writable", checkWritable())
+
+		if stopping.Load() {
+			checks["shutdown"] = "in progress"
+			ready = false
+		}
+
+		status, code := "ready", http.StatusOK
+		if !ready {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+		writeJSON(w, r, code, map[string]any{"status": status, "checks": checks})
+	}
+}
+
+// shutdownRetryAfter is how long clients refused during shutdown are asked
+// to wait, enough for a load balancer to route them to another instance.
+const shutdownRetryAfter = 10 * time.Second
+
+// RefuseWhileStopping answers 503 with Retry-After instead of calling next
+// once shutdown has started, so no new transfer begins while running ones
+// are drained.
+func RefuseWhileStopping(stopping *atomic.Bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if stopping.Load() {
+			slog.InfoContext(r.Context(), "Refusing request during shutdown")
+			setRetryAfter(w, shutdownRetryAfter)
+			if wantsJSON(r) {
+				writeJSONError(w, r, http.StatusServiceUnavailable, codeUnavailable, "Server is shutting down, try again shortly")
+				return
+			}
+			http.Error(w, "Server is shutting down, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// VersionHandler returns the version, commit and Go version of the build.
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, buildinfo.Get())
+}
+
+func storageReachable(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/metrics"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+// archiveStatsTTL is how long the archive count and stored bytes are reused
+// between scrapes, so frequent scrapes do not read every info.json.
+const archiveStatsTTL = time.Minute
+
+// MetricsHandler serves the metrics in the Prometheus text format, asking
+// for the configured bearer token when there is one.
+func MetricsHandler(cfg *config.Config) http.HandlerFunc {
+	var mu sync.Mutex
+	var scanned time.Time
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := cfg.Metrics.Token; token != "" {
+			got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		mu.Lock()
+		if time.Since(scanned) >= archiveStatsTTL {
+			archives, err := storage.ListArchives(cfg.UseDirectory)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to list archives", "error", err)
+				countStorageError(metrics.OpList, err)
+			} else {
+				var size int64
+				for _, a := range archives {
+					size += a.SizeBytes
+				}
+				metrics.Archives.Set(int64(len(archives)))
+				metrics.StoredBytes.Set(size)
+				scanned = time.Now()
+			}
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", metrics.ContentType)
+		if err := metrics.Write(w); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to write metrics", "error", err)
+		}
+	}
+}
+
+// countStorageError records a failed storage operation. Lookups of archives
+// that do not exist are the client's mistake and not counted.
+func countStorageError(op string, err error) {
+	if !errors.Is(err, fs.ErrNotExist) {
+		metrics.StorageErrors.With(op).Inc()
+	}
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/metrics"
+	"github.com/Firstbober/locara/internal/share"
+	"github.com/Firstbober/locara/internal/storage"
+	"github.com/Firstbober/locara/internal/transfer"
+)
+
+const defaultShareExpiry = 24 * time.Hour
+
+// authMethodSharePassword labels failed share link passwords in the auth
+// failure metrics.
+const authMethodSharePassword = "share_password"
+
+// shareResponse is the JSON representation of a share link returned by the API.
+type shareResponse struct {
+	ID           string    `json:"id"`
+	ArchiveID    int       `json:"archive_id"`
+	URL          string    `json:"url"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedOn    time.Time `json:"created_on"`
+	ExpiresOn    time.Time `json:"expires_on"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	Password     bool      `json:"password"`
+}
+
+// CreateShareHandler creates an expiring share link for an archive.
+func CreateShareHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, shares *share.Store, throttle *auth.Throttle) {
+	user, ok := authenticateRequest(w, r, cfg, throttle)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, codeInvalidID, "Invalid archive ID")
+		return
+	}
+
+	if _, err := storage.GetArchive(cfg.UseDirectory, id); err != nil {
+		slog.WarnContext(r.Context(), "Failed to get archive metadata", "archive_id", id, "error", err)
+		writeJSONError(w, r, http.StatusNotFound, codeNotFound, "Archive not found")
+		return
+	}
+
+	expiresOn, err := parseShareExpiry(r.FormValue("expires_in"), r.FormValue("expires_on"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid expiry time")
+		return
+	}
+
+	maxDownloads := 0
+	if v := r.FormValue("max_downloads"); v != "" {
+		maxDownloads, err = strconv.Atoi(v)
+		if err != nil || maxDownloads < 0 {
+			writeJSONError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid download limit")
+			return
+		}
+	}
+
+	link, err := shares.Create(id, user.Name, expiresOn, maxDownloads, r.FormValue("password"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create share link", "archive_id", id, "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, codeInternal, "Failed to create share link")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Share link created", "share_id", link.ID, "archive_id", id, "user", user.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newShareResponse(cfg, shares, link)); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to encode share link", "error", err)
+	}
+}
+
+// ListSharesHandler returns a JSON list of active share links. Admins see
+// every link, other users only the ones they created.
+func ListSharesHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, shares *share.Store, throttle *auth.Throttle) {
+	user, ok := authenticateRequest(w, r, cfg, throttle)
+	if !ok {
+		return
+	}
+
+	links := shares.List()
+	response := make([]shareResponse, 0, len(links))
+	for i := range links {
+		if !user.IsAdmin() && links[i].CreatedBy != user.Name {
+			continue
+		}
+		response = append(response, newShareResponse(cfg, shares, &links[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to encode share links", "error", err)
+	}
+}
+
+// RevokeShareHandler deletes a share link so it can no longer be used. Only
+// the creator of the link or an admin may revoke it.
+func RevokeShareHandler(w http.ResponseWriter, r *http.Request, cfg *config.Config, shares *share.Store, throttle *auth.Throttle) {
+	user, ok := authenticateRequest(w, r, cfg, throttle)
+	if !ok {
+		return
+	}
+
+	linkID := r.PathValue("id")
+	link, err := shares.Get(linkID)
+	if err != nil {
+		writeJSONError(w, r, http.StatusNotFound, codeNotFound, "Share link not found")
+		return
+	}
+	if !user.IsAdmin() && link.CreatedBy != user.Name {
+		writeJSONError(w, r, http.StatusForbidden, codeForbidden, "Not allowed to revoke this share link")
+		return
+	}
+
+	if err := shares.Revoke(linkID); err != nil {
+		if errors.Is(err, share.ErrNotFound) {
+			writeJSONError(w, r, http.StatusNotFound, codeNotFound, "Share link not found")
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to revoke share link", "share_id", linkID, "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, codeInternal, "Failed to revoke share link")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Share link revoked", "share_id", linkID, "user", user.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ShareDownloadHandler serves an archive through a signed share link, asking
+// for the password first when the link is protected.
+func ShareDownloadHandler(tmpl *template.Template, cfg *config.Config, sessions *auth.Sessions, shares *share.Store, throttle *auth.Throttle, transfers *transfer.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkID := r.PathValue("id")
+		exp := r.URL.Query().Get("exp")
+		sig := r.URL.Query().Get("sig")
+
+		link, err := shares.Verify(linkID, exp, sig)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Rejected share link", "share_id", linkID, "error", err)
+			redirectError(w, r, cfg, sessions, "/error", shareErrorMessage(err))
+			return
+		}
+
+		password := ""
+		if r.Method == http.MethodPost {
+			password = r.FormValue("password")
+		}
+
+		if link.HasPassword() && r.Method != http.MethodPost {
+			renderSharePassword(w, r, tmpl, cfg, sessions, false)
+			return
+		}
+
+		keys := []string{auth.IPKey(clientIP(r)), auth.ShareKey(linkID)}
+		if link.HasPassword() {
+			if wait := throttle.Check(keys...); wait > 0 {
+				slog.WarnContext(r.Context(), "Share link password attempts throttled", "share_id", linkID, "client_ip", clientIP(r), "wait", wait)
+				setRetryAfter(w, wait)
+				redirectError(w, r, cfg, sessions, "/error", "Too many wrong passwords, please try again later.")
+				return
+			}
+		}
+
+		link, err = shares.Redeem(linkID, exp, sig, password)
+		if err != nil {
+			if errors.Is(err, share.ErrPasswordRequired) || errors.Is(err, share.ErrWrongPassword) {
+				slog.WarnContext(r.Context(), "Wrong password for share link", "share_id", linkID, "client_ip", clientIP(r))
+				metrics.AuthFailures.With(authMethodSharePassword).Inc()
+				throttle.Failure(keys...)
+				renderSharePassword(w, r, tmpl, cfg, sessions, true)
+				return
+			}
+			slog.WarnContext(r.Context(), "Rejected share link", "share_id", linkID, "error", err)
+			redirectError(w, r, cfg, sessions, "/error", shareErrorMessage(err))
+			return
+		}
+
+		if link.HasPassword() {
+			throttle.Success(keys...)
+		}
+
+		slog.InfoContext(r.Context(), "Share link used", "share_id", link.ID, "archive_id", link.ArchiveID, "downloads", link.Downloads)
+
+		file, archive, rerr := openArchiveFile(r, cfg, link.ArchiveID)
+		if rerr != nil {
+			if err := shares.Release(link.ID); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to release share link download", "share_id", link.ID, "error", err)
+			}
+			failRequest(w, r, cfg, sessions, rerr.status, rerr.code, rerr.message, "/")
+			return
+		}
+		defer file.Close()
+
+		// Every request against a limited link is counted, so it always
+		// gets the whole file rather than a range of it.
+		if link.MaxDownloads > 0 {
+			r.Header.Del("Range")
+			r.Header.Del("If-Range")
+		}
+
+		sendArchiveFile(w, r, transfers, file, archive)
+	}
+}
+
+// shareErrorMessage tells the visitor of a rejected share link why it
+// cannot be used.
+func shareErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, share.ErrExpired):
+		return "This share link has expired."
+	case errors.Is(err, share.ErrLimitReached):
+		return "This share link has reached its download limit."
+	default:
+		return "This share link is not valid."
+	}
+}
+
+func renderSharePassword(w http.ResponseWriter, r *http.Request, tmpl *template.Template, cfg *config.Config, sessions *auth.Sessions, wrong bool) {
+	data := struct {
+		Cfg           *config.Config
+		User          *auth.Identity
+		Flash         *flash
+		WrongPassword bool
+	}{
+		Cfg:           cfg,
+		User:          requestUser(r),
+		Flash:         takeFlash(w, r, sessions),
+		WrongPassword: wrong,
+	}
+
+	if wrong {
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	if err := renderTemplate(w, tmpl, "share.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render template", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func newShareResponse(cfg *config.Config, shares *share.Store, link *share.Link) shareResponse {
+	return shareResponse{
+		ID:           link.ID,
+		ArchiveID:    link.ArchiveID,
+		URL:          cfg.BaseUrl + shares.URL(link),
+		CreatedBy:    link.CreatedBy,
+		CreatedOn:    link.CreatedOn,
+		ExpiresOn:    link.ExpiresOn,
+		MaxDownloads: link.MaxDownloads,
+		Downloads:    link.Downloads,
+		Password:     link.HasPassword(),
+	}
+}
+
+// parseShareExpiry resolves the expiry from either a duration ("72h") or an
+// absolute date/time, defaulting to one day from now.
+func parseShareExpiry(expiresIn, expiresOn string) (time.Time, error) {
+	switch {
+	case expiresIn != "":
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid expiry duration: %q", expiresIn)
+		}
+		return time.Now().Add(d), nil
+	case expiresOn != "":
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, expiresOn); err == nil {
+				if !t.After(time.Now()) {
+					return time.Time{}, fmt.Errorf("expiry time must be in the future")
+				}
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid expiry time: %q", expiresOn)
+	default:
+		return time.Now().Add(defaultShareExpiry), nil
+	}
+}
@@ -2,33 +2,40 @@ package handlers
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 
+	"github.com/Firstbober/locara/internal/auth"
 	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/metrics"
 	"github.com/Firstbober/locara/internal/models"
 	"github.com/Firstbober/locara/internal/storage"
 )
 
 // IndexHandler renders the main page with the archive list.
-func IndexHandler(tmpl *template.Template, cfg *config.Config) http.HandlerFunc {
+func IndexHandler(tmpl *template.Template, cfg *config.Config, sessions *auth.Sessions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		archives, err := storage.ListArchives(cfg.UseDirectory)
 		if err != nil {
-			log.Printf("[ERROR] Failed to list archives: %v", err)
+			slog.ErrorContext(r.Context(), "Failed to list archives", "error", err)
+			countStorageError(metrics.OpList, err)
 			archives = []models.Archive{}
 		}
 
 		data := struct {
 			Archives []models.Archive
 			Cfg      *config.Config
+			User     *auth.Identity
+			Flash    *flash
 		}{
 			Archives: archives,
 			Cfg:      cfg,
+			User:     requestUser(r),
+			Flash:    takeFlash(w, r, sessions),
 		}
 
 		if err := renderTemplate(w, tmpl, "index.html", data); err != nil {
-			log.Printf("[ERROR] Failed to render template: %v", err)
+			slog.ErrorContext(r.Context(), "Failed to render template", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -36,33 +43,42 @@ func IndexHandler(tmpl *template.Template, cfg *config.Config) http.HandlerFunc
 }
 
 // UploadHandler renders the upload form page.
-func UploadHandler(tmpl *template.Template, cfg *config.Config) http.HandlerFunc {
+func UploadHandler(tmpl *template.Template, cfg *config.Config, sessions *auth.Sessions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		data := struct {
-			Cfg *config.Config
+			Cfg   *config.Config
+			User  *auth.Identity
+			Flash *flash
 		}{
-			Cfg: cfg,
+			Cfg:   cfg,
+			User:  requestUser(r),
+			Flash: takeFlash(w, r, sessions),
 		}
 
 		if err := renderTemplate(w, tmpl, "upload.html", data); err != nil {
-			log.Printf("[ERROR] Failed to render template: %v", err)
+			slog.ErrorContext(r.Context(), "Failed to render template", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-// ErrorHandler renders an error page.
-func ErrorHandler(tmpl *template.Template, cfg *config.Config) http.HandlerFunc {
+// ErrorHandler renders an error page with the reason from the flash
+// message, when there is one.
+func ErrorHandler(tmpl *template.Template, cfg *config.Config, sessions *auth.Sessions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		data := struct {
-			Cfg *config.Config
+			Cfg   *config.Config
+			User  *auth.Identity
+			Flash *flash
 		}{
-			Cfg: cfg,
+			Cfg:   cfg,
+			User:  requestUser(r),
+			Flash: takeFlash(w, r, sessions),
 		}
 
 		if err := renderTemplate(w, tmpl, "error.html", data); err != nil {
-			log.Printf("[ERROR] Failed to render template: %v", err)
+			slog.ErrorContext(r.Context(), "Failed to render template", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,447 @@
+// Package importer turns a directory tree of existing files into archives.
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Firstbober/locara/internal/models"
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+// Statuses of results.
+const (
+	// StatusImported is a file saved as a new archive, or that would be in a
+	// dry run.
+	StatusImported = "imported"
+	// StatusDuplicate is a file with the same content as an existing archive
+	// or an earlier file of the import.
+	StatusDuplicate = "duplicate"
+	// StatusSkipped is a file lacking metadata required for an archive.
+	StatusSkipped = "skipped"
+	// StatusFailed is a file that could not be read or saved, or a manifest
+	// entry without a file.
+	StatusFailed = "failed"
+)
+
+// Result is what happened to a file of the import.
+type Result struct {
+	// Path is relative to the imported directory, with forward slashes.
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	// ID is the new archive for imported files, unless in a dry run, and
+	// the existing one for duplicates.
+	ID     int    `json:"id,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Fields is the metadata of an archive taken from a manifest, template or
+// defaults. Empty fields are filled in from the next source.
+type Fields struct {
+	Name    string `json:"name"`
+	DatedOn string `json:"dated_on"`
+	Type    string `json:"type"`
+	Author  string `json:"author"`
+}
+
+func (f *Fields) fill(from Fields) {
+	for _, p := range []struct {
+		to   *string
+		from string
+	}{
+		{&f.Name, from.Name},
+		{&f.DatedOn, from.DatedOn},
+		{&f.Type, from.Type},
+		{&f.Author, from.Author},
+	} {
+		if *p.to == "" {
+			*p.to = strings.TrimSpace(p.from)
+		}
+	}
+}
+
+// missing returns the names of the empty fields.
+func (f *Fields) missing() []string {
+	var missing []string
+	for _, p := range []struct{ name, value string }{
+		{"name", f.Name},
+		{"dated_on", f.DatedOn},
+		{"type", f.Type},
+		{"author", f.Author},
+	} {
+		if p.value == "" {
+			missing = append(missing, p.name)
+		}
+	}
+	return missing
+}
+
+// Manifest holds metadata for files by their path relative to the imported
+// directory.
+type Manifest map[string]Fields
+
+// manifestEntry is a file listed in a manifest.
+type manifestEntry struct {
+	Path string `json:"path"`
+	Fields
+}
+
+// LoadManifest reads a manifest from a CSV file with a header row, or a JSON
+// file holding an array of objects, telling them apart by the extension.
+// Both have the columns or keys path, name, dated_on, type and author, of
+// which only path is required.
+func LoadManifest(file string) (Manifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	var entries []manifestEntry
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".csv":
+		entries, err = readCSVManifest(f)
+	case ".json":
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&entries)
+	default:
+		return nil, fmt.Errorf("manifest must be a .csv or .json file, not %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	m := make(Manifest, len(entries))
+	for i, e := range entries {
+		p := path.Clean(filepath.ToSlash(e.Path))
+		if e.Path == "" || !filepath.IsLocal(p) {
+			return nil, fmt.Errorf("manifest entry %d: invalid path %q", i+1, e.Path)
+		}
+		if _, ok := m[p]; ok {
+			return nil, fmt.Errorf("manifest entry %d: %s is listed twice", i+1, p)
+		}
+		m[p] = e.Fields
+	}
+	return m, nil
+}
+
+func readCSVManifest(r io.Reader) ([]manifestEntry, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]func(*manifestEntry) *string, len(header))
+	hasPath := false
+	for i, name := range header {
+		switch strings.TrimSpace(name) {
+		case "path":
+			columns[i] = func(e *manifestEntry) *string { return &e.Path }
+			hasPath = true
+		case "name":
+			columns[i] = func(e *manifestEntry) *string { return &e.Name }
+		case "dated_on":
+			columns[i] = func(e *manifestEntry) *string { return &e.DatedOn }
+		case "type":
+			columns[i] = func(e *manifestEntry) *string { return &e.Type }
+		case "author":
+			columns[i] = func(e *manifestEntry) *string { return &e.Author }
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+	if !hasPath {
+		return nil, errors.New("missing path column")
+	}
+
+	var entries []manifestEntry
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e manifestEntry
+		for i, value := range record {
+			*columns[i](&e) = value
+		}
+		entries = append(entries, e)
+	}
+}
+
+// templateFields are the placeholders of a template and what they match.
+var templateFields = map[string]string{
+	"name":   `[^/]+?`,
+	"type":   `[^/]+?`,
+	"author": `[^/]+?`,
+	"date":   `\d{4}(?:-\d{2}(?:-\d{2})?)?`,
+	"year":   `\d{4}`,
+	"month":  `\d{2}`,
+	"day":    `\d{2}`,
+	"ext":    `[^/.]+`,
+	"any":    `[^/]*?`,
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// Template derives metadata from the path of a file, such as
+// "{year}/{type}/{author} - {name}.{ext}".
+type Template struct {
+	re *regexp.Regexp
+}
+
+// ParseTemplate parses a template. Placeholders are {name}, {type},
+// {author}, {date} (2003, 2003-07 or 2003-07-01), {year}, {month}, {day},
+// {ext} and {any}, which matches anything within a path element and may be
+// used more than once. The rest of the template must match literally.
+func ParseTemplate(tmpl string) (*Template, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	seen := make(map[string]bool)
+	last := 0
+	for _, m := range placeholder.FindAllStringSubmatchIndex(tmpl, -1) {
+		name := tmpl[m[2]:m[3]]
+		pattern, ok := templateFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s} in template", name)
+		}
+		if seen[name] && name != "any" {
+			return nil, fmt.Errorf("placeholder {%s} used twice in template", name)
+		}
+		seen[name] = true
+
+		expr.WriteString(regexp.QuoteMeta(tmpl[last:m[0]]))
+		if name == "any" {
+			expr.WriteString(pattern)
+		} else {
+			fmt.Fprintf(&expr, "(?P<%s>%s)", name, pattern)
+		}
+		last = m[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(tmpl[last:]))
+	expr.WriteString("$")
+
+	if seen["date"] && (seen["year"] || seen["month"] || seen["day"]) {
+		return nil, errors.New("template cannot use {date} together with {year}, {month} or {day}")
+	}
+	if (seen["month"] && !seen["year"]) || (seen["day"] && !seen["month"]) {
+		return nil, errors.New("template needs {year} for {month} and {month} for {day}")
+	}
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return &Template{re: re}, nil
+}
+
+// Match returns the metadata in a path relative to the imported directory,
+// with forward slashes, or false when the path does not match.
+func (t *Template) Match(rel string) (Fields, bool) {
+	m := t.re.FindStringSubmatch(rel)
+	if m == nil {
+		return Fields{}, false
+	}
+	values := make(map[string]string)
+	for i, name := range t.re.SubexpNames() {
+		if name != "" {
+			values[name] = m[i]
+		}
+	}
+
+	dated := values["date"]
+	if dated == "" {
+		dated = strings.Join(slices.DeleteFunc([]string{values["year"], values["month"], values["day"]}, func(s string) bool { return s == "" }), "-")
+	}
+	return Fields{
+		Name:    values["name"],
+		DatedOn: dated,
+		Type:    values["type"],
+		Author:  values["author"],
+	}, true
+}
+
+// Options configure an import.
+type Options struct {
+	// Manifest, Template and Defaults are consulted in this order for every
+	// field; any may be nil or empty. The name defaults to the file name
+	// without its extension.
+	Manifest Manifest
+	Template *Template
+	Defaults Fields
+	// Uploader is recorded as the uploader of the archives.
+	Uploader string
+	// DryRun reports what would be imported without saving anything.
+	DryRun bool
+	// Exclude lists files not to import, such as the manifest.
+	Exclude []string
+}
+
+// Run imports the regular files below dir into the uploads directory in
+// baseDir, in lexical order and skipping hidden files and directories and
+// those excluded. Files whose content is already archived are reported as
+// duplicates, so running an interrupted import again picks up where it
+// stopped. report is called for every file; Run only returns an error when
+// the import cannot continue, including ctx being cancelled.
+func Run(ctx context.Context, baseDir, dir string, opts Options, report func(Result)) error {
+	archives, err := storage.ListArchives(baseDir)
+	if err != nil {
+		return err
+	}
+	// known maps checksums to the archive or file of the import with them.
+	known := make(map[string]Result, len(archives))
+	for _, a := range archives {
+		if sum := storage.HexMD5(a.MD5Sum); sum != "" {
+			known[sum] = Result{ID: a.ID}
+		}
+	}
+
+	var excluded []os.FileInfo
+	for _, file := range opts.Exclude {
+		if info, err := os.Stat(file); err == nil {
+			excluded = append(excluded, info)
+		}
+	}
+
+	listed := make(map[string]bool, len(opts.Manifest))
+	err = filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && slices.ContainsFunc(excluded, func(e os.FileInfo) bool { return os.SameFile(e, info) }) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := opts.Manifest[rel]; ok {
+			listed[rel] = true
+		}
+
+		result := importFile(ctx, baseDir, file, rel, opts, known)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report(result)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range slices.Sorted(maps.Keys(opts.Manifest)) {
+		if !listed[rel] {
+			report(Result{Path: rel, Status: StatusFailed, Detail: "listed in the manifest but not found"})
+		}
+	}
+	return nil
+}
+
+func importFile(ctx context.Context, baseDir, file, rel string, opts Options, known map[string]Result) Result {
+	result := Result{Path: rel}
+	done := func(status, format string, args ...any) Result {
+		result.Status = status
+		result.Detail = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	fields := opts.Manifest[rel]
+	if opts.Template != nil {
+		matched, ok := opts.Template.Match(rel)
+		if !ok && fields == (Fields{}) {
+			return done(StatusSkipped, "does not match the template")
+		}
+		fields.fill(matched)
+	}
+	fields.fill(opts.Defaults)
+	fields.fill(Fields{Name: strings.TrimSuffix(path.Base(rel), path.Ext(rel))})
+	if missing := fields.missing(); len(missing) > 0 {
+		return done(StatusSkipped, "missing %s", strings.Join(missing, ", "))
+	}
+
+	sum, err := storage.FileMD5(file)
+	if err != nil {
+		return done(StatusFailed, "%v", err)
+	}
+	if orig, ok := known[sum]; ok {
+		result.ID = orig.ID
+		if orig.Path != "" {
+			return done(StatusDuplicate, "same content as %s", orig.Path)
+		}
+		return done(StatusDuplicate, "same content as archive #%d", orig.ID)
+	}
+
+	result.Status = StatusImported
+	result.Detail = fmt.Sprintf("%s, %s, %s, %s", fields.Name, fields.DatedOn, fields.Type, fields.Author)
+	if opts.DryRun {
+		// Later copies within the tree are duplicates of this one.
+		known[sum] = result
+		return result
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return done(StatusFailed, "%v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return done(StatusFailed, "%v", err)
+	}
+
+	meta := &models.Archive{
+		Uploader:   opts.Uploader,
+		FileName:   path.Base(rel),
+		SizeBytes:  info.Size(),
+		MD5Sum:     sum,
+		UploadedOn: time.Now(),
+		Name:       fields.Name,
+		DatedOn:    fields.DatedOn,
+		Type:       fields.Type,
+		Author:     fields.Author,
+	}
+	header := &multipart.FileHeader{Filename: meta.FileName, Size: meta.SizeBytes}
+	if err := storage.SaveArchive(ctx, baseDir, f, header, meta); err != nil {
+		if errors.Is(err, storage.ErrChecksumMismatch) {
+			return done(StatusFailed, "file changed while importing")
+		}
+		return done(StatusFailed, "%v", err)
+	}
+
+	result.ID = meta.ID
+	known[sum] = result
+	return result
+}
@@ -0,0 +1,211 @@
+package importer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/storage"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("{year}/{type}/{author} - {name}.{ext}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() failed: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want Fields
+		ok   bool
+	}{
+		{"2003/video/Jan - Summer - tape.mp4", Fields{Name: "Summer - tape", DatedOn: "2003", Type: "video", Author: "Jan"}, true},
+		{"2003/video/Summer tape.mp4", Fields{}, false},
+		{"03/video/Jan - Summer tape.mp4", Fields{}, false},
+		{"2003/video/extra/Jan - Summer tape.mp4", Fields{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := tmpl.Match(tt.path)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("Match(%q) = %+v, %v, want %+v, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	tmpl, err = ParseTemplate("{any}/{year}-{month}-{day} {name}.{ext}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() failed: %v", err)
+	}
+	want := Fields{Name: "Minutes", DatedOn: "2010-02-01"}
+	if got, ok := tmpl.Match("scans/2010-02-01 Minutes.pdf"); !ok || got != want {
+		t.Errorf("Match() = %+v, %v, want %+v", got, ok, want)
+	}
+
+	for _, bad := range []string{"{title}.{ext}", "{name}/{name}", "{date}/{year}", "{month}/{name}"} {
+		if _, err := ParseTemplate(bad); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	want := Manifest{
+		"a/tape.mp4":  {Name: "Summer tape", DatedOn: "2003-07-01", Type: "video"},
+		"minutes.pdf": {Name: "Minutes, February", Author: "Board"},
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"manifest.csv": "path,name,dated_on,type,author\n" +
+			"a/tape.mp4,Summer tape,2003-07-01,video,\n" +
+			"./minutes.pdf,\"Minutes, February\",,,Board\n",
+		"manifest.json": `[
+			{"path": "a/tape.mp4", "name": "Summer tape", "dated_on": "2003-07-01", "type": "video"},
+			{"path": "minutes.pdf", "name": "Minutes, February", "author": "Board"}
+		]`,
+		"unknown.csv":   "path,title\nx,y\n",
+		"outside.json":  `[{"path": "../secret"}]`,
+		"twice.json":    `[{"path": "x"}, {"path": "./x"}]`,
+		"manifest.yaml": "",
+	})
+
+	for _, name := range []string{"manifest.csv", "manifest.json"} {
+		m, err := LoadManifest(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("LoadManifest(%s) failed: %v", name, err)
+		}
+		if len(m) != len(want) {
+			t.Errorf("LoadManifest(%s) = %+v, want %+v", name, m, want)
+		}
+		for path, fields := range want {
+			if m[path] != fields {
+				t.Errorf("LoadManifest(%s)[%q] = %+v, want %+v", name, path, m[path], fields)
+			}
+		}
+	}
+
+	for _, name := range []string{"unknown.csv", "outside.json", "twice.json", "manifest.yaml", "missing.csv"} {
+		if _, err := LoadManifest(filepath.Join(dir, name)); err == nil {
+			t.Errorf("LoadManifest(%s) succeeded", name)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	baseDir := t.TempDir()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"2003/video/Jan - Summer tape.mp4":  "tape",
+		"2003/video/Jan - Summer copy.mp4":  "tape",
+		"2010/document/Board - Minutes.pdf": "minutes",
+		"2010/document/Board - Budget.xls":  "budget",
+		"loose/notes.txt":                   "notes",
+		"unlisted.txt":                      "unlisted",
+		".hidden/secret.txt":                "secret",
+		"manifest.csv":                      "path\n",
+	})
+
+	tmpl, err := ParseTemplate("{year}/{type}/{author} - {name}.{ext}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() failed: %v", err)
+	}
+	opts := Options{
+		Manifest: Manifest{
+			"loose/notes.txt":                  {Name: "Notes", DatedOn: "1999", Type: "document", Author: "Ann"},
+			"2010/document/Board - Budget.xls": {Name: "Budget 2010"},
+			"gone.txt":                         {Name: "Gone"},
+		},
+		Template: tmpl,
+		Uploader: "import",
+		DryRun:   true,
+		Exclude:  []string{filepath.Join(dir, "manifest.csv")},
+	}
+
+	run := func() map[string]Result {
+		t.Helper()
+
+		results := make(map[string]Result)
+		if err := Run(context.Background(), baseDir, dir, opts, func(r Result) { results[r.Path] = r }); err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+		return results
+	}
+	statuses := func(results map[string]Result) map[string]string {
+		statuses := make(map[string]string)
+		for path, r := range results {
+			statuses[path] = r.Status
+		}
+		return statuses
+	}
+	want := map[string]string{
+		"2003/video/Jan - Summer copy.mp4":  StatusImported,
+		"2003/video/Jan - Summer tape.mp4":  StatusDuplicate,
+		"2010/document/Board - Budget.xls":  StatusImported,
+		"2010/document/Board - Minutes.pdf": StatusImported,
+		"loose/notes.txt":                   StatusImported,
+		"unlisted.txt":                      StatusSkipped,
+		"gone.txt":                          StatusFailed,
+	}
+
+	results := run()
+	if got := statuses(results); len(got) != len(want) {
+		t.Errorf("dry run statuses = %v, want %v", got, want)
+	}
+	for path, status := range want {
+		if results[path].Status != status || results[path].ID != 0 {
+			t.Errorf("dry run result for %s = %+v, want %s without ID", path, results[path], status)
+		}
+	}
+	if archives, _ := storage.ListArchives(baseDir); len(archives) != 0 {
+		t.Fatalf("dry run saved %d archives", len(archives))
+	}
+
+	opts.DryRun = false
+	results = run()
+	for path, status := range want {
+		if results[path].Status != status {
+			t.Errorf("result for %s = %+v, want %s", path, results[path], status)
+		}
+	}
+	archives, err := storage.ListArchives(baseDir)
+	if err != nil || len(archives) != 4 {
+		t.Fatalf("ListArchives() = %d archives, %v, want 4", len(archives), err)
+	}
+	budget, err := storage.GetArchive(baseDir, results["2010/document/Board - Budget.xls"].ID)
+	if err != nil {
+		t.Fatalf("GetArchive() failed: %v", err)
+	}
+	if budget.Name != "Budget 2010" || budget.DatedOn != "2010" || budget.Author != "Board" || budget.FileName != "Board - Budget.xls" || budget.Uploader != "import" {
+		t.Errorf("budget archive = %+v", budget)
+	}
+
+	// Running again only imports what was added since.
+	writeFiles(t, dir, map[string]string{"2011/audio/Ann - Song.ogg": "song"})
+	results = run()
+	if r := results["2011/audio/Ann - Song.ogg"]; r.Status != StatusImported || r.ID != 5 {
+		t.Errorf("result for new file = %+v, want imported as #5", r)
+	}
+	if r := results["loose/notes.txt"]; r.Status != StatusDuplicate || r.ID == 0 {
+		t.Errorf("result for imported file = %+v, want duplicate", r)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Run(ctx, baseDir, dir, opts, func(Result) {}); err == nil {
+		t.Error("Run() with cancelled context succeeded")
+	}
+}
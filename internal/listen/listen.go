@@ -0,0 +1,204 @@
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// UnixPrefix marks a listen address as the path of a Unix domain socket.
+const UnixPrefix = "unix:"
+
+// firstSystemdFD is the first file descriptor passed by systemd.
+const firstSystemdFD = 3
+
+// SocketOptions sets the ownership of created Unix sockets.
+type SocketOptions struct {
+	// Mode is applied to the socket file when non-zero, e.g. 0660.
+	Mode fs.FileMode
+	// Group owns the socket file when set, so e.g. nginx can connect.
+	Group string
+}
+
+// ParseAddr splits a listen address into a network and address for
+// net.Listen: "unix:/run/locara.sock" or a TCP address such as ":4000".
+func ParseAddr(addr string) (network, address string, err error) {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		if path == "" {
+			return "", "", fmt.Errorf("missing socket path in %q", addr)
+		}
+		return "unix", path, nil
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port in listen address %q", addr)
+	}
+
+	return "tcp", addr, nil
+}
+
+// Open listens on every address. On failure the listeners opened so far are
+// closed again.
+func Open(addrs []string, opts SocketOptions) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	for _, addr := range addrs {
+		l, err := openOne(addr, opts)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+func openOne(addr string, opts SocketOptions) (net.Listener, error) {
+	network, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		if err := setSocketOwnership(address, opts); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a previous run.
+// Anything else at the path is left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
+
+func setSocketOwnership(path string, opts SocketOptions) error {
+	if opts.Group != "" {
+		gid, err := LookupGroup(opts.Group)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return fmt.Errorf("failed to change group of %s: %w", path, err)
+		}
+	}
+
+	if opts.Mode != 0 {
+		if err := os.Chmod(path, opts.Mode); err != nil {
+			return fmt.Errorf("failed to change mode of %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// ParseSocketMode parses an octal permission mode such as "0660".
+func ParseSocketMode(mode string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q, want octal permissions such as 0660", mode)
+	}
+	return fs.FileMode(m), nil
+}
+
+// LookupGroup resolves a group name or numeric ID.
+func LookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(g.Gid)
+}
+
+// Systemd returns the sockets passed by systemd socket activation, or nil
+// when the process was not socket activated. The activation variables are
+// cleared so child processes do not pick them up.
+func Systemd() ([]net.Listener, error) {
+	listeners, err := systemdListeners(os.Getenv, os.Getpid(), firstSystemdFD)
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return listeners, err
+}
+
+func systemdListeners(getenv func(string) string, pid, firstFD int) ([]net.Listener, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, n)
+	for i := range n {
+		fd := firstFD + i
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket %s passed by systemd: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
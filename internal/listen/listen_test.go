@@ -0,0 +1,125 @@
+package listen
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAddr(t *testing.T) {
+	valid := map[string]string{
+		":4000":                 "tcp",
+		"127.0.0.1:4000":        "tcp",
+		"[::1]:4000":            "tcp",
+		"unix:/run/locara.sock": "unix",
+	}
+	for addr, network := range valid {
+		got, _, err := ParseAddr(addr)
+		if err != nil || got != network {
+			t.Errorf("ParseAddr(%q) = %q, %v, want %q", addr, got, err, network)
+		}
+	}
+
+	for _, addr := range []string{"4000", "unix:", "localhost:http", ":70000"} {
+		if _, _, err := ParseAddr(addr); err == nil {
+			t.Errorf("ParseAddr(%q) succeeded, want error", addr)
+		}
+	}
+}
+
+func TestOpenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locara.sock")
+
+	listeners, err := Open([]string{"unix:" + path, "127.0.0.1:0"}, SocketOptions{Mode: 0660})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("Open() returned %d listeners, want 2", len(listeners))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Socket not created: %v", err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("Socket mode = %v, want 0660", info.Mode().Perm())
+	}
+
+	if _, err := Open([]string{"unix:" + path}, SocketOptions{}); err == nil {
+		t.Errorf("Open() on a socket in use succeeded")
+	}
+
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	// Go removes the socket file on Close; simulate a crash leaving it behind.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("Stale socket missing: %v", err)
+	}
+
+	listeners, err = Open([]string{"unix:" + path}, SocketOptions{})
+	if err != nil {
+		t.Fatalf("Open() over a stale socket failed: %v", err)
+	}
+	listeners[0].Close()
+
+	file := filepath.Join(t.TempDir(), "regular")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := Open([]string{"unix:" + file}, SocketOptions{}); err == nil {
+		t.Errorf("Open() replaced a regular file")
+	}
+	if info, err := os.Lstat(file); err != nil || info.Mode().Type() == fs.ModeSocket {
+		t.Errorf("Regular file was touched")
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	fd := int(f.Fd())
+
+	env := map[string]string{
+		"LISTEN_PID":     "1234",
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "http",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	if got, err := systemdListeners(getenv, 999, fd); got != nil || err != nil {
+		t.Errorf("systemdListeners() for another PID = %v, %v, want none", got, err)
+	}
+
+	got, err := systemdListeners(getenv, 1234, fd)
+	if err != nil {
+		t.Fatalf("systemdListeners() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Addr().String() != l.Addr().String() {
+		t.Fatalf("systemdListeners() = %v, want the listener on %s", got, l.Addr())
+	}
+	got[0].Close()
+
+	env["LISTEN_FDS"] = "zero"
+	if _, err := systemdListeners(getenv, 1234, fd); err == nil {
+		t.Errorf("systemdListeners() with invalid LISTEN_FDS succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Output formats for log_format.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// level is shared by every handler created by Setup so it can be changed on
+// config reload.
+var level = new(slog.LevelVar)
+
+// Setup installs the default slog logger writing to w in the given format
+// and level. Messages still logged through the log package end up in the
+// same output at info level.
+func Setup(w io.Writer, format, lvl string) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch format {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{h}))
+	log.SetFlags(0)
+
+	return nil
+}
+
+// SetLevel changes the minimum level of the default logger.
+func SetLevel(lvl string) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// ParseLevel parses a level name: debug, info, warn or error.
+func ParseLevel(lvl string) (slog.Level, error) {
+	var l slog.Level
+	if lvl == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(strings.ToUpper(lvl))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", lvl)
+	}
+	return l, nil
+}
+
+// contextHandler adds the request ID and user stored in the context to every
+// record, so logs of handlers and storage can be tied to their request.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		r.AddAttrs(slog.String("request_id", info.id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureJSON installs a JSON logger writing to a buffer for the duration of
+// the test.
+func captureJSON(t *testing.T, lvl string) *bytes.Buffer {
+	t.Helper()
+
+	old := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(old)
+		level.Set(slog.LevelInfo)
+	})
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, FormatJSON, lvl); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	return &buf
+}
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var out []map[string]any
+	for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := captureJSON(t, "info")
+
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), "alice")
+		slog.InfoContext(r.Context(), "handler ran")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/archive/create", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("response %s = %q, want the incoming ID", RequestIDHeader, got)
+	}
+
+	logs := records(t, buf)
+	if len(logs) != 2 {
+		t.Fatalf("got %d log records, want 2: %s", len(logs), buf)
+	}
+	if logs[0]["msg"] != "handler ran" || logs[0]["request_id"] != "abc-123" {
+		t.Errorf("handler record = %v, want request_id abc-123", logs[0])
+	}
+
+	got := logs[1]
+	want := map[string]any{
+		"msg":        "request",
+		"method":     "POST",
+		"path":       "/api/archive/create",
+		"status":     float64(http.StatusCreated),
+		"bytes":      float64(5),
+		"user":       "alice",
+		"request_id": "abc-123",
+		"level":      "INFO",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("request record %s = %v, want %v", k, got[k], v)
+		}
+	}
+	if _, ok := got["duration"]; !ok {
+		t.Error("request record has no duration")
+	}
+}
+
+func TestMiddlewareGeneratesRequestID(t *testing.T) {
+	captureJSON(t, "info")
+
+	var seen string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	for _, incoming := range []string{"", "has spaces", strings.Repeat("a", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if incoming != "" {
+			req.Header.Set(RequestIDHeader, incoming)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		got := rec.Header().Get(RequestIDHeader)
+		if got == "" || got == incoming || got != seen {
+			t.Errorf("incoming %q: response ID %q, handler saw %q, want a fresh ID seen by both", incoming, got, seen)
+		}
+	}
+}
+
+func TestMiddlewareServerErrorLevel(t *testing.T) {
+	buf := captureJSON(t, "info")
+
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	logs := records(t, buf)
+	if len(logs) != 1 || logs[0]["level"] != "ERROR" {
+		t.Errorf("got %v, want one ERROR record", logs)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buf := captureJSON(t, "warn")
+
+	slog.Info("hidden")
+	slog.Warn("shown")
+	if err := SetLevel("debug"); err != nil {
+		t.Fatalf("SetLevel() failed: %v", err)
+	}
+	slog.Debug("shown after reload")
+
+	logs := records(t, buf)
+	if len(logs) != 2 || logs[0]["msg"] != "shown" || logs[1]["msg"] != "shown after reload" {
+		t.Errorf("got %v, want only the warn and debug records", logs)
+	}
+
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("SetLevel(verbose) succeeded, want error")
+	}
+}
+
+func TestSetupRejectsUnknownFormat(t *testing.T) {
+	if err := Setup(&bytes.Buffer{}, "xml", ""); err == nil {
+		t.Error("Setup() with format xml succeeded, want error")
+	}
+}
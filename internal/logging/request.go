@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Firstbober/locara/internal/clientip"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming request IDs so clients cannot bloat logs.
+const maxRequestIDLength = 128
+
+type requestKey struct{}
+
+// requestInfo is shared by a request and everything it calls, so the user
+// authenticated deep inside a handler still shows up in the request log.
+type requestInfo struct {
+	id string
+
+	mu   sync.Mutex
+	user string
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// SetUser records the authenticated user of the request ctx belongs to.
+func SetUser(ctx context.Context, name string) {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.user = name
+		info.mu.Unlock()
+	}
+}
+
+// Middleware assigns every request an ID, taken from the X-Request-ID header
+// when the client sent a sensible one, and logs the request once it is done.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		info := &requestInfo{id: id}
+		ctx := context.WithValue(r.Context(), requestKey{}, info)
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		info.mu.Lock()
+		user := info.user
+		info.mu.Unlock()
+
+		lvl := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			lvl = slog.LevelError
+		}
+
+		slog.Log(ctx, lvl, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"user", user,
+			"client_ip", clientip.FromRequest(r),
+		)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// responseRecorder captures the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps io.Copy able to use the underlying writer's optimised path
+// (e.g. sendfile) for downloads.
+func (r *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.wroteHeader = true
+	n, err := io.Copy(r.ResponseWriter, src)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
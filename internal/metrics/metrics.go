@@ -0,0 +1,68 @@
+// Package metrics collects server metrics and exposes them in the Prometheus
+// text format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+)
+
+// ContentType is the media type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Transfer directions for ActiveTransfers.
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Storage operations for StorageErrors.
+const (
+	OpSave  = "save"
+	OpList  = "list"
+	OpRead  = "read"
+	OpWrite = "write" // metadata updates and deletions
+)
+
+// DefaultBuckets are the request latency buckets in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	Requests = register(newCounterVec("locara_http_requests_total",
+		"HTTP requests handled, by route and status code.", "method", "route", "status"))
+	RequestDuration = register(newHistogramVec("locara_http_request_duration_seconds",
+		"Time taken to handle HTTP requests, by route and status code.", DefaultBuckets, "method", "route", "status"))
+	UploadBytes = &register(newCounter("locara_upload_bytes_total",
+		"Bytes received in archive uploads.")).Counter
+	DownloadBytes = &register(newCounter("locara_download_bytes_total",
+		"Bytes sent in archive downloads.")).Counter
+	ActiveTransfers = register(newGaugeVec("locara_active_transfers",
+		"Uploads and downloads in progress.", "direction"))
+	Archives = &register(newGauge("locara_archives",
+		"Number of stored archives.")).Gauge
+	StoredBytes = &register(newGauge("locara_archive_stored_bytes",
+		"Total size of the stored archive files in bytes.")).Gauge
+	StorageErrors = register(newCounterVec("locara_storage_errors_total",
+		"Failed storage operations.", "operation"))
+	AuthFailures = register(newCounterVec("locara_auth_failures_total",
+		"Failed authentication attempts, by authentication method.", "method"))
+)
+
+func init() {
+	// Expose the fixed label values from the start so rates work before
+	// the first event.
+	ActiveTransfers.With(Upload)
+	ActiveTransfers.With(Download)
+	for _, op := range []string{OpSave, OpList, OpRead, OpWrite} {
+		StorageErrors.With(op)
+	}
+}
+
+// Write writes every metric to w in the Prometheus text format.
+func Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range registry {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
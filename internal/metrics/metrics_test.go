@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, m metric) string {
+	t.Helper()
+
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	m.write(w)
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	return b.String()
+}
+
+func TestCounterVecFormat(t *testing.T) {
+	c := newCounterVec("test_total", "A test counter.", "path")
+	c.With(`/b`).Inc()
+	c.With("/a\"\n\\").Add(3)
+	c.With(`/b`).Add(-5)
+
+	want := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{path="/a\"\n\\"} 3
+test_total{path="/b"} 1
+`
+	if got := render(t, c); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramFormat(t *testing.T) {
+	h := newHistogramVec("test_seconds", "A test histogram.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.5, 0.5, 2} {
+		h.With("/").Observe(v)
+	}
+
+	want := `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/",le="0.1"} 1
+test_seconds_bucket{route="/",le="1"} 3
+test_seconds_bucket{route="/",le="+Inf"} 4
+test_seconds_sum{route="/"} 3.05
+test_seconds_count{route="/"} 4
+`
+	if got := render(t, h); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMiddlewareRouteLabels(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /item/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	h := Middleware(mux)
+
+	for _, path := range []string{"/item/1", "/item/2", "/nope"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := Requests.With("GET", "/item/{id}", "202").Value(); got != 2 {
+		t.Errorf("matched route count = %d, want 2", got)
+	}
+	if got := Requests.With("", unmatchedRoute, "404").Value(); got != 1 {
+		t.Errorf("unmatched route count = %d, want 1", got)
+	}
+}
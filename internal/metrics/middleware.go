@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unmatchedRoute labels requests no route matched, so probing clients cannot
+// create a series per path.
+const unmatchedRoute = "unmatched"
+
+// Middleware counts requests and their latency by route and status code.
+// It must wrap the ServeMux directly, as the matched route is read from the
+// pattern the mux records on the request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		method, route := routeLabels(r)
+		status := strconv.Itoa(rec.status)
+		Requests.With(method, route, status).Inc()
+		RequestDuration.With(method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeLabels splits the matched pattern ("GET /api/archive/{id}") into the
+// method and route labels.
+func routeLabels(r *http.Request) (string, string) {
+	if r.Pattern == "" {
+		return "", unmatchedRoute
+	}
+	if method, route, ok := strings.Cut(r.Pattern, " "); ok {
+		return method, route
+	}
+	return "", r.Pattern
+}
+
+// statusRecorder captures the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// ReadFrom keeps io.Copy able to use the underlying writer's optimised path.
+func (r *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.wroteHeader = true
+	return io.Copy(r.ResponseWriter, src)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,313 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is implemented by every metric kind so Write can expose it.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// registry holds every metric in the order it was declared.
+var registry []metric
+
+func register[M metric](m M) M {
+	registry = append(registry, m)
+	return m
+}
+
+// desc is the name and help text shared by all kinds.
+type desc struct {
+	name string
+	help string
+	typ  string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add adds n to the counter, ignoring negative values.
+func (c *Counter) Add(n int64) {
+	if n > 0 {
+		c.v.Add(uint64(n))
+	}
+}
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Inc adds one to the gauge.
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+// Dec subtracts one from the gauge.
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+// Set replaces the value of the gauge.
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// counter is a registered metric without labels.
+type counter struct {
+	desc
+	Counter
+}
+
+func (c *counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	fmt.Fprintf(w, "%s %d\n", c.name, c.Value())
+}
+
+type gauge struct {
+	desc
+	Gauge
+}
+
+func (g *gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %d\n", g.name, g.Value())
+}
+
+// vec holds one child metric per combination of label values.
+type vec[M any] struct {
+	labels []string
+	create func() *M
+
+	mu       sync.Mutex
+	children map[string]*child[M]
+}
+
+type child[M any] struct {
+	values []string
+	m      *M
+}
+
+func (v *vec[M]) with(values []string) *M {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values, want %d", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if c, ok := v.children[key]; ok {
+		return c.m
+	}
+	if v.children == nil {
+		v.children = make(map[string]*child[M])
+	}
+	c := &child[M]{values: values, m: v.create()}
+	v.children[key] = c
+	return c.m
+}
+
+// sorted returns the children ordered by label values, so the output is
+// stable between scrapes.
+func (v *vec[M]) sorted() []*child[M] {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]*child[M], len(keys))
+	for i, key := range keys {
+		out[i] = v.children[key]
+	}
+	return out
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	desc
+	vec[Counter]
+}
+
+// With returns the counter for the given label values, in label order.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	for _, ch := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, labelString(c.labels, ch.values), ch.m.Value())
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	desc
+	vec[Gauge]
+}
+
+// With returns the gauge for the given label values, in label order.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	for _, ch := range g.sorted() {
+		fmt.Fprintf(w, "%s%s %d\n", g.name, labelString(g.labels, ch.values), ch.m.Value())
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	desc
+	vec[Histogram]
+}
+
+// With returns the histogram for the given label values, in label order.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	for _, ch := range h.sorted() {
+		hist := ch.m
+		hist.mu.Lock()
+		for i, upper := range hist.buckets {
+			labels := labelString(slices.Concat(h.labels, []string{"le"}), slices.Concat(ch.values, []string{formatFloat(upper)}))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, hist.counts[i])
+		}
+		labels := labelString(slices.Concat(h.labels, []string{"le"}), slices.Concat(ch.values, []string{"+Inf"}))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, hist.count)
+		labels = labelString(h.labels, ch.values)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, hist.count)
+		hist.mu.Unlock()
+	}
+}
+
+func newCounter(name, help string) *counter {
+	return &counter{desc: desc{name, help, "counter"}}
+}
+
+func newGauge(name, help string) *gauge {
+	return &gauge{desc: desc{name, help, "gauge"}}
+}
+
+func newCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		desc: desc{name, help, "counter"},
+		vec:  vec[Counter]{labels: labels, create: func() *Counter { return new(Counter) }},
+	}
+}
+
+func newGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{
+		desc: desc{name, help, "gauge"},
+		vec:  vec[Gauge]{labels: labels, create: func() *Gauge { return new(Gauge) }},
+	}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		desc: desc{name, help, "histogram"},
+		vec:  vec[Histogram]{labels: labels, create: func() *Histogram { return newHistogram(buckets) }},
+	}
+}
+
+// labelString formats label pairs as {name="value",...}.
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
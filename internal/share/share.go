@@ -0,0 +1,392 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	storeFileName = ".shares.json"
+
+	passwordIterations = 100000
+	passwordKeyLen     = 32
+)
+
+var (
+	// ErrNotFound is returned when a link does not exist or has been revoked.
+	ErrNotFound = errors.New("share link not found")
+	// ErrInvalidSignature is returned when the URL signature does not match.
+	ErrInvalidSignature = errors.New("invalid share link signature")
+	// ErrExpired is returned when the link is past its expiry time.
+	ErrExpired = errors.New("share link expired")
+	// ErrLimitReached is returned when the download limit has been used up.
+	ErrLimitReached = errors.New("share link download limit reached")
+	// ErrPasswordRequired is returned when a password protected link is opened without one.
+	ErrPasswordRequired = errors.New("share link requires a password")
+	// ErrWrongPassword is returned when the supplied password does not match.
+	ErrWrongPassword = errors.New("wrong share link password")
+)
+
+// Link represents a share link granting temporary access to one archive.
+type Link struct {
+	ID           string    `json:"id"`
+	ArchiveID    int       `json:"archive_id"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedOn    time.Time `json:"created_on"`
+	ExpiresOn    time.Time `json:"expires_on"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	PasswordSalt string    `json:"password_salt,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+}
+
+// HasPassword reports whether the link is password protected.
+func (l *Link) HasPassword() bool {
+	return l.PasswordHash != ""
+}
+
+// Store keeps share links persisted as JSON in the uploads directory.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	key   []byte
+	links map[string]*Link
+}
+
+// Open loads the share link store from baseDir, signing links with key.
+func Open(baseDir string, key []byte) (*Store, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("share signing key cannot be empty")
+	}
+
+	s := &Store{
+		path:  filepath.Join(baseDir, storeFileName),
+		key:   key,
+		links: make(map[string]*Link),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read share store: %w", err)
+	}
+
+	var links []*Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse share store: %w", err)
+	}
+	for _, link := range links {
+		s.links[link.ID] = link
+	}
+
+	return s, nil
+}
+
+// Create registers a new share link for the archive and persists it.
+func (s *Store) Create(archiveID int, createdBy string, expiresOn time.Time, maxDownloads int, password string) (*Link, error) {
+	if !expiresOn.After(time.Now()) {
+		return nil, fmt.Errorf("expiry time must be in the future")
+	}
+	if maxDownloads < 0 {
+		return nil, fmt.Errorf("download limit cannot be negative")
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate link ID: %w", err)
+	}
+
+	link := &Link{
+		ID:           id,
+		ArchiveID:    archiveID,
+		CreatedBy:    createdBy,
+		CreatedOn:    time.Now(),
+		ExpiresOn:    expiresOn.Truncate(time.Second),
+		MaxDownloads: maxDownloads,
+	}
+
+	if password != "" {
+		salt, err := randomHex(16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate password salt: %w", err)
+		}
+		hash, err := hashPassword(password, salt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		link.PasswordSalt = salt
+		link.PasswordHash = hash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.links[link.ID] = link
+	if err := s.save(); err != nil {
+		delete(s.links, link.ID)
+		return nil, err
+	}
+
+	copied := *link
+	return &copied, nil
+}
+
+// List returns all links that are neither expired nor used up, oldest first.
+func (s *Store) List() []Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	links := make([]Link, 0, len(s.links))
+	for _, link := range s.links {
+		if !isActive(link, now) {
+			continue
+		}
+		links = append(links, *link)
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedOn.Before(links[j].CreatedOn)
+	})
+
+	return links
+}
+
+// Get returns the link with the given ID.
+func (s *Store) Get(id string) (*Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copied := *link
+	return &copied, nil
+}
+
+// Revoke deletes the link with the given ID so its URL stops working.
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.links, id)
+	if err := s.save(); err != nil {
+		s.links[id] = link
+		return err
+	}
+
+	return nil
+}
+
+// RevokeArchive deletes every link to the given archive, e.g. once it is
+// deleted, so the links cannot serve an archive that later reuses its ID.
+// It returns the number of links removed.
+func (s *Store) RevokeArchive(archiveID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := make(map[string]*Link)
+	for id, link := range s.links {
+		if link.ArchiveID == archiveID {
+			removed[id] = link
+			delete(s.links, id)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	if err := s.save(); err != nil {
+		for id, link := range removed {
+			s.links[id] = link
+		}
+		return 0, err
+	}
+
+	return len(removed), nil
+}
+
+// URL returns the signed path and query under which the link is served.
+func (s *Store) URL(link *Link) string {
+	exp := strconv.FormatInt(link.ExpiresOn.Unix(), 10)
+
+	query := url.Values{}
+	query.Set("exp", exp)
+	query.Set("sig", s.sign(link.ID, link.ArchiveID, exp))
+
+	return "/share/" + link.ID + "?" + query.Encode()
+}
+
+// Verify checks the signature and state of a link without counting a download.
+func (s *Store) Verify(id, exp, sig string) (*Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, err := s.verifyLocked(id, exp, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *link
+	return &copied, nil
+}
+
+// Redeem verifies the link and password and counts one download against it.
+// The count is taken before the download is served so concurrent requests
+// cannot exceed the limit; Release gives it back when serving fails before
+// any data was sent.
+func (s *Store) Redeem(id, exp, sig, password string) (*Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, err := s.verifyLocked(id, exp, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	if link.HasPassword() {
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		hash, err := hashPassword(password, link.PasswordSalt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(link.PasswordHash)) != 1 {
+			return nil, ErrWrongPassword
+		}
+	}
+
+	link.Downloads++
+	if err := s.save(); err != nil {
+		link.Downloads--
+		return nil, err
+	}
+
+	copied := *link
+	return &copied, nil
+}
+
+// Release gives back a download counted by Redeem that was never served.
+func (s *Store) Release(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[id]
+	if !ok || link.Downloads == 0 {
+		return ErrNotFound
+	}
+
+	link.Downloads--
+	if err := s.save(); err != nil {
+		link.Downloads++
+		return err
+	}
+	return nil
+}
+
+func (s *Store) verifyLocked(id, exp, sig string) (*Link, error) {
+	link, ok := s.links[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	expected := s.sign(link.ID, link.ArchiveID, exp)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return nil, ErrInvalidSignature
+	}
+
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || expUnix != link.ExpiresOn.Unix() {
+		return nil, ErrInvalidSignature
+	}
+
+	now := time.Now()
+	if !now.Before(link.ExpiresOn) {
+		return nil, ErrExpired
+	}
+	if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+		return nil, ErrLimitReached
+	}
+
+	return link, nil
+}
+
+func (s *Store) sign(id string, archiveID int, exp string) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%d\n%s", id, archiveID, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Store) save() error {
+	links := make([]*Link, 0, len(s.links))
+	now := time.Now()
+	for _, link := range s.links {
+		if !isActive(link, now) {
+			continue
+		}
+		links = append(links, link)
+	}
+
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode share store: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write share store: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace share store: %w", err)
+	}
+
+	return nil
+}
+
+func isActive(link *Link, now time.Time) bool {
+	if !now.Before(link.ExpiresOn) {
+		return false
+	}
+	return link.MaxDownloads == 0 || link.Downloads < link.MaxDownloads
+}
+
+func hashPassword(password, salt string) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), passwordIterations, passwordKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,180 @@
+package share
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func parseLinkURL(t *testing.T, s *Store, link *Link) (string, string, string) {
+	t.Helper()
+
+	u, err := url.Parse(s.URL(link))
+	if err != nil {
+		t.Fatalf("Failed to parse share URL: %v", err)
+	}
+
+	return strings.TrimPrefix(u.Path, "/share/"), u.Query().Get("exp"), u.Query().Get("sig")
+}
+
+func TestCreateAndRedeem(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	s, err := Open(tmpDir, []byte("test-key"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	link, err := s.Create(1, "testuser", time.Now().Add(time.Hour), 2, "")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	id, exp, sig := parseLinkURL(t, s, link)
+
+	for i := 1; i <= 2; i++ {
+		redeemed, err := s.Redeem(id, exp, sig, "")
+		if err != nil {
+			t.Fatalf("Redeem() #%d failed: %v", i, err)
+		}
+		if redeemed.Downloads != i {
+			t.Errorf("Redeem().Downloads = %d, want %d", redeemed.Downloads, i)
+		}
+	}
+
+	if _, err := s.Redeem(id, exp, sig, ""); !errors.Is(err, ErrLimitReached) {
+		t.Errorf("Redeem() over limit error = %v, want %v", err, ErrLimitReached)
+	}
+
+	if err := s.Release(id); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+	if _, err := s.Redeem(id, exp, sig, ""); err != nil {
+		t.Errorf("Redeem() after Release() failed: %v", err)
+	}
+}
+
+func TestRedeemConcurrentLimit(t *testing.T) {
+	s, err := Open(t.TempDir(), []byte("test-key"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	link, err := s.Create(1, "testuser", time.Now().Add(time.Hour), 3, "")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	id, exp, sig := parseLinkURL(t, s, link)
+
+	var wg sync.WaitGroup
+	var redeemed atomic.Int32
+	for range 20 {
+		wg.Go(func() {
+			if _, err := s.Redeem(id, exp, sig, ""); err == nil {
+				redeemed.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := redeemed.Load(); got != 3 {
+		t.Errorf("%d concurrent Redeem() calls succeeded, want 3", got)
+	}
+}
+
+func TestRedeemRejectsTampering(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	s, err := Open(tmpDir, []byte("test-key"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	link, err := s.Create(1, "testuser", time.Now().Add(time.Hour), 0, "secret")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	id, exp, sig := parseLinkURL(t, s, link)
+
+	if _, err := s.Verify(id, strings.Repeat("9", len(exp)), sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() with modified expiry error = %v, want %v", err, ErrInvalidSignature)
+	}
+	if _, err := s.Verify(id, exp, sig+"x"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() with modified signature error = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	if _, err := s.Redeem(id, exp, sig, ""); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("Redeem() without password error = %v, want %v", err, ErrPasswordRequired)
+	}
+	if _, err := s.Redeem(id, exp, sig, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("Redeem() with wrong password error = %v, want %v", err, ErrWrongPassword)
+	}
+	if _, err := s.Redeem(id, exp, sig, "secret"); err != nil {
+		t.Errorf("Redeem() with password failed: %v", err)
+	}
+}
+
+func TestRevokeAndReload(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	s, err := Open(tmpDir, []byte("test-key"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	kept, err := s.Create(1, "testuser", time.Now().Add(time.Hour), 0, "")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	revoked, err := s.Create(2, "testuser", time.Now().Add(time.Hour), 0, "")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	if err := s.Revoke(revoked.ID); err != nil {
+		t.Fatalf("Revoke() failed: %v", err)
+	}
+
+	reopened, err := Open(tmpDir, []byte("test-key"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	links := reopened.List()
+	if len(links) != 1 || links[0].ID != kept.ID {
+		t.Errorf("List() after reload = %v, want only link %s", links, kept.ID)
+	}
+
+	id, exp, sig := parseLinkURL(t, reopened, revoked)
+	if _, err := reopened.Verify(id, exp, sig); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Verify() of revoked link error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestRevokeArchive(t *testing.T) {
+	s, err := Open(t.TempDir(), []byte("test-key"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	for _, archiveID := range []int{1, 2, 1} {
+		if _, err := s.Create(archiveID, "testuser", time.Now().Add(time.Hour), 0, ""); err != nil {
+			t.Fatalf("Create() failed: %v", err)
+		}
+	}
+
+	if n, err := s.RevokeArchive(1); n != 2 || err != nil {
+		t.Fatalf("RevokeArchive() = %d, %v, want 2, nil", n, err)
+	}
+
+	links := s.List()
+	if len(links) != 1 || links[0].ArchiveID != 2 {
+		t.Errorf("List() after RevokeArchive() = %v, want only the link to archive 2", links)
+	}
+}
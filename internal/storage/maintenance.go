@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Firstbober/locara/internal/models"
+)
+
+// Kinds of findings reported by Verify and FindOrphans.
+const (
+	// KindMissingFile is an archive whose file does not exist.
+	KindMissingFile = "missing_file"
+	// KindSizeMismatch is an archive whose file has another size than
+	// recorded.
+	KindSizeMismatch = "size_mismatch"
+	// KindChecksumMismatch is an archive whose file does not match its MD5.
+	KindChecksumMismatch = "checksum_mismatch"
+	// KindNoChecksum is an archive without a recorded MD5, uploaded before
+	// checksums were computed.
+	KindNoChecksum = "no_checksum"
+	// KindIDMismatch is an archive whose info.json names another ID than its
+	// directory, e.g. after an interrupted renumbering.
+	KindIDMismatch = "id_mismatch"
+	// KindUnreadable is an archive file that could not be read.
+	KindUnreadable = "unreadable"
+
+	// KindNoInfo is an archive directory without a readable info.json.
+	KindNoInfo = "no_info"
+	// KindUnreferenced is a file in an archive directory that is neither
+	// info.json nor the archive file.
+	KindUnreferenced = "unreferenced_file"
+	// KindStaging is a staging or trash directory left behind by an
+	// interrupted upload or deletion.
+	KindStaging = "staging"
+)
+
+// ErrChecksumMismatch is returned by SaveArchive when the file does not
+// match the checksum sent with it.
+var ErrChecksumMismatch = errors.New("file does not match its checksum")
+
+// Finding is a problem found in the uploads directory.
+type Finding struct {
+	Kind string `json:"kind"`
+	ID   int    `json:"id,omitempty"`
+	// Path is relative to the uploads directory.
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Verify checks that the file of every archive exists and has the recorded
+// size and, when checksums is set, MD5.
+func Verify(baseDir string, checksums bool) (checked int, findings []Finding, err error) {
+	ids, err := archiveIDs(baseDir)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, id := range ids {
+		archive, err := GetArchive(baseDir, id)
+		if err != nil {
+			// Reported by FindOrphans.
+			continue
+		}
+		checked++
+
+		rel := filepath.Join(strconv.Itoa(id), archive.FileName)
+		found := func(kind, detail string) {
+			findings = append(findings, Finding{Kind: kind, ID: id, Path: rel, Detail: detail})
+		}
+
+		if archive.ID != id {
+			found(KindIDMismatch, fmt.Sprintf("info.json has ID %d", archive.ID))
+		}
+
+		info, err := os.Stat(filepath.Join(baseDir, rel))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			found(KindMissingFile, "")
+			continue
+		case err != nil:
+			found(KindUnreadable, err.Error())
+			continue
+		case info.Size() != archive.SizeBytes:
+			found(KindSizeMismatch, fmt.Sprintf("recorded %d bytes, file has %d", archive.SizeBytes, info.Size()))
+			continue
+		}
+
+		if !checksums {
+			continue
+		}
+		if archive.MD5Sum == "" {
+			found(KindNoChecksum, "")
+			continue
+		}
+		sum, err := FileMD5(filepath.Join(baseDir, rel))
+		if err != nil {
+			found(KindUnreadable, err.Error())
+			continue
+		}
+		if !MD5Matches(archive.MD5Sum, sum) {
+			found(KindChecksumMismatch, fmt.Sprintf("recorded %s, file has %s", archive.MD5Sum, sum))
+		}
+	}
+
+	return checked, findings, nil
+}
+
+// FindOrphans reports what in the uploads directory belongs to no archive:
+// archive directories without info.json, files besides the archive file and
+// leftover staging directories. Entries that do not look like archives at
+// all, such as the share link store, are left alone.
+func FindOrphans(baseDir string) ([]Finding, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploads directory: %w", err)
+	}
+
+	var findings []Finding
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() {
+			continue
+		}
+		if isStaging(name) {
+			findings = append(findings, Finding{Kind: KindStaging, Path: name})
+			continue
+		}
+
+		id, err := parseArchiveID(name)
+		if err != nil {
+			continue
+		}
+		archive, err := GetArchive(baseDir, id)
+		if err != nil {
+			findings = append(findings, Finding{Kind: KindNoInfo, ID: id, Path: name, Detail: err.Error()})
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(baseDir, name))
+		if err != nil {
+			return findings, fmt.Errorf("failed to read archive directory: %w", err)
+		}
+		for _, f := range files {
+			if f.Name() == infoFileName || f.Name() == archive.FileName {
+				continue
+			}
+			findings = append(findings, Finding{Kind: KindUnreferenced, ID: id, Path: filepath.Join(name, f.Name())})
+		}
+	}
+
+	return findings, nil
+}
+
+// RemoveOrphan deletes what an orphan finding of FindOrphans points at. It
+// must only be called while the server is stopped, as staging directories of
+// running uploads look like leftovers.
+func RemoveOrphan(baseDir string, f Finding) error {
+	switch f.Kind {
+	case KindStaging, KindNoInfo, KindUnreferenced:
+	default:
+		return fmt.Errorf("%s is not an orphan", f.Kind)
+	}
+	if f.Path == "" || !filepath.IsLocal(f.Path) {
+		return fmt.Errorf("invalid orphan path %q", f.Path)
+	}
+	return os.RemoveAll(filepath.Join(baseDir, f.Path))
+}
+
+// Tally counts archives and their size.
+type Tally struct {
+	Archives int   `json:"archives"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func (t *Tally) add(a *models.Archive) {
+	t.Archives++
+	t.Bytes += a.SizeBytes
+}
+
+// Stats summarises the archives in the uploads directory.
+type Stats struct {
+	Tally
+	ByType     map[string]*Tally `json:"by_type"`
+	ByUploader map[string]*Tally `json:"by_uploader"`
+	// ByYear is keyed by the year the archives are dated in, "unknown" when
+	// the date does not start with one.
+	ByYear      map[string]*Tally `json:"by_year"`
+	FirstUpload *time.Time        `json:"first_upload,omitempty"`
+	LastUpload  *time.Time        `json:"last_upload,omitempty"`
+}
+
+// CollectStats counts the archives in the uploads directory.
+func CollectStats(baseDir string) (*Stats, error) {
+	archives, err := ListArchives(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		ByType:     make(map[string]*Tally),
+		ByUploader: make(map[string]*Tally),
+		ByYear:     make(map[string]*Tally),
+	}
+	count := func(m map[string]*Tally, key string, a *models.Archive) {
+		if m[key] == nil {
+			m[key] = &Tally{}
+		}
+		m[key].add(a)
+	}
+
+	for i := range archives {
+		a := &archives[i]
+		stats.add(a)
+		count(stats.ByType, a.Type, a)
+		count(stats.ByUploader, a.Uploader, a)
+		count(stats.ByYear, datedYear(a.DatedOn), a)
+
+		if stats.FirstUpload == nil || a.UploadedOn.Before(*stats.FirstUpload) {
+			stats.FirstUpload = &a.UploadedOn
+		}
+		if stats.LastUpload == nil || a.UploadedOn.After(*stats.LastUpload) {
+			stats.LastUpload = &a.UploadedOn
+		}
+	}
+
+	return stats, nil
+}
+
+// Renumbering moves an archive to another ID.
+type Renumbering struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// PlanRenumber returns the moves that number the archives consecutively from
+// 1 in their current order. Archives that keep their ID but whose info.json
+// names another one are included with From equal to To.
+func PlanRenumber(baseDir string) ([]Renumbering, error) {
+	ids, err := archiveIDs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var moves []Renumbering
+	for i, id := range ids {
+		archive, err := GetArchive(baseDir, id)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d cannot be renumbered, remove or repair it first: %w", id, err)
+		}
+		if to := i + 1; to != id || archive.ID != id {
+			moves = append(moves, Renumbering{From: id, To: to})
+		}
+	}
+	return moves, nil
+}
+
+// Renumber applies moves planned by PlanRenumber. Each archive directory is
+// renamed before its info.json is updated; when interrupted, planning and
+// renumbering again completes the job.
+func Renumber(baseDir string, moves []Renumbering) error {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+
+	// Moves only go to lower IDs, so in ascending order the target is free.
+	moves = append([]Renumbering(nil), moves...)
+	sort.Slice(moves, func(i, j int) bool { return moves[i].From < moves[j].From })
+
+	for _, m := range moves {
+		if m.To != m.From {
+			if err := renameNoReplace(archivePath(baseDir, m.From), archivePath(baseDir, m.To)); err != nil {
+				return fmt.Errorf("failed to move archive %d to %d: %w", m.From, m.To, err)
+			}
+		}
+
+		archive, err := GetArchive(baseDir, m.To)
+		if err != nil {
+			return err
+		}
+		archive.ID = m.To
+		if err := replaceInfoFile(archivePath(baseDir, m.To), archive); err != nil {
+			return fmt.Errorf("failed to renumber archive %d: %w", m.From, err)
+		}
+	}
+	return nil
+}
+
+// MD5Matches reports whether a recorded checksum, hex or base64 as sent in
+// Content-MD5, equals the hex MD5 sum.
+func MD5Matches(recorded, sum string) bool {
+	hexSum := HexMD5(recorded)
+	return hexSum != "" && hexSum == sum
+}
+
+// HexMD5 returns a recorded checksum, hex or base64 as sent in Content-MD5,
+// as lowercase hex, or "" when it is neither.
+func HexMD5(recorded string) string {
+	raw, err := hex.DecodeString(recorded)
+	if err != nil {
+		if raw, err = base64.StdEncoding.DecodeString(recorded); err != nil {
+			return ""
+		}
+	}
+	if len(raw) != md5.Size {
+		return ""
+	}
+	return hex.EncodeToString(raw)
+}
+
+// archiveIDs returns the IDs of the archive directories in ascending order.
+func archiveIDs(baseDir string) ([]int, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploads directory: %w", err)
+	}
+
+	var ids []int
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if id, err := parseArchiveID(entry.Name()); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// FileMD5 returns the hex MD5 sum of a file.
+func FileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func datedYear(datedOn string) string {
+	if len(datedOn) >= 4 {
+		if _, err := strconv.Atoi(datedOn[:4]); err == nil {
+			return datedOn[:4]
+		}
+	}
+	return "unknown"
+}
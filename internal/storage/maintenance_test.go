@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/models"
+)
+
+// findingKinds maps the paths of findings to their kinds.
+func findingKinds(findings []Finding) map[string]string {
+	kinds := make(map[string]string)
+	for _, f := range findings {
+		kinds[f.Path] = f.Kind
+	}
+	return kinds
+}
+
+func TestVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"ok", "missing", "truncated", "corrupt", "legacy"} {
+		saveTestArchive(t, tmpDir, name)
+	}
+
+	os.Remove(filepath.Join(tmpDir, "2", "missing.txt"))
+	os.WriteFile(filepath.Join(tmpDir, "3", "truncated.txt"), []byte("con"), 0o644)
+	os.WriteFile(filepath.Join(tmpDir, "4", "corrupt.txt"), []byte("CONTENT"), 0o644)
+	if _, err := UpdateArchive(tmpDir, 5, func(a *models.Archive) error {
+		a.MD5Sum = ""
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateArchive() failed: %v", err)
+	}
+
+	checked, findings, err := Verify(tmpDir, true)
+	if err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	if checked != 5 {
+		t.Errorf("Verify() checked %d archives, want 5", checked)
+	}
+	want := map[string]string{
+		filepath.Join("2", "missing.txt"):   KindMissingFile,
+		filepath.Join("3", "truncated.txt"): KindSizeMismatch,
+		filepath.Join("4", "corrupt.txt"):   KindChecksumMismatch,
+		filepath.Join("5", "legacy.txt"):    KindNoChecksum,
+	}
+	if got := findingKinds(findings); !maps.Equal(got, want) {
+		t.Errorf("Verify() found %v, want %v", got, want)
+	}
+
+	// Without checksums the corrupt file has the right size.
+	_, findings, _ = Verify(tmpDir, false)
+	if len(findings) != 2 {
+		t.Errorf("Verify() without checksums found %v, want 2 findings", findings)
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	tmpDir := t.TempDir()
+	saveTestArchive(t, tmpDir, "kept")
+	saveTestArchive(t, tmpDir, "lost")
+
+	os.Remove(filepath.Join(tmpDir, "2", infoFileName))
+	os.WriteFile(filepath.Join(tmpDir, "1", "stray.bin"), []byte("x"), 0o644)
+	os.Mkdir(filepath.Join(tmpDir, stagingPrefix+"123"), 0o755)
+	os.Mkdir(filepath.Join(tmpDir, "notes"), 0o755)
+	os.WriteFile(filepath.Join(tmpDir, ".shares.json"), []byte("{}"), 0o644)
+
+	findings, err := FindOrphans(tmpDir)
+	if err != nil {
+		t.Fatalf("FindOrphans() failed: %v", err)
+	}
+	want := map[string]string{
+		"2":                             KindNoInfo,
+		filepath.Join("1", "stray.bin"): KindUnreferenced,
+		stagingPrefix + "123":           KindStaging,
+	}
+	if got := findingKinds(findings); !maps.Equal(got, want) {
+		t.Fatalf("FindOrphans() found %v, want %v", got, want)
+	}
+
+	for _, f := range findings {
+		if err := RemoveOrphan(tmpDir, f); err != nil {
+			t.Fatalf("RemoveOrphan(%+v) failed: %v", f, err)
+		}
+	}
+	if findings, _ := FindOrphans(tmpDir); len(findings) != 0 {
+		t.Errorf("FindOrphans() after removal found %v", findings)
+	}
+	if _, err := GetArchiveFilePath(tmpDir, 1); err != nil {
+		t.Errorf("archive 1 damaged by removing orphans: %v", err)
+	}
+	if err := RemoveOrphan(tmpDir, Finding{Kind: KindStaging, Path: "../outside"}); err == nil {
+		t.Error("RemoveOrphan() accepted a path outside the uploads directory")
+	}
+	if err := RemoveOrphan(tmpDir, Finding{Kind: KindMissingFile, Path: "1"}); err == nil {
+		t.Error("RemoveOrphan() accepted a finding that is no orphan")
+	}
+}
+
+func TestHexMD5(t *testing.T) {
+	const sum = "9473fdd0d880a43c21b7778d34872157"
+	for recorded, want := range map[string]string{
+		sum:                                sum,
+		"9473FDD0D880A43C21B7778D34872157": sum,
+		"lHP90NiApDwht3eNNIchVw==":         sum,
+		"abc123":                           "",
+		"":                                 "",
+	} {
+		if got := HexMD5(recorded); got != want {
+			t.Errorf("HexMD5(%q) = %q, want %q", recorded, got, want)
+		}
+		if got := MD5Matches(recorded, sum); got != (want != "") {
+			t.Errorf("MD5Matches(%q) = %v", recorded, got)
+		}
+	}
+}
+
+func TestCollectStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	saveTestArchive(t, tmpDir, "a")
+	saveTestArchive(t, tmpDir, "b")
+	UpdateArchive(tmpDir, 2, func(a *models.Archive) error {
+		a.Type, a.DatedOn = "video", "around 1990"
+		return nil
+	})
+
+	stats, err := CollectStats(tmpDir)
+	if err != nil {
+		t.Fatalf("CollectStats() failed: %v", err)
+	}
+	if stats.Archives != 2 || stats.Bytes != 14 {
+		t.Errorf("CollectStats() = %d archives, %d bytes, want 2, 14", stats.Archives, stats.Bytes)
+	}
+	if stats.ByType["video"] == nil || stats.ByType["video"].Archives != 1 {
+		t.Errorf("ByType = %v, want one video", stats.ByType)
+	}
+	if stats.ByYear["2024"] == nil || stats.ByYear["unknown"] == nil {
+		t.Errorf("ByYear = %v, want 2024 and unknown", stats.ByYear)
+	}
+	if stats.FirstUpload == nil || stats.LastUpload == nil {
+		t.Errorf("upload times not set")
+	}
+}
+
+func TestRenumber(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"one", "two", "three", "four"} {
+		saveTestArchive(t, tmpDir, name)
+	}
+	DeleteArchive(tmpDir, 1)
+	DeleteArchive(tmpDir, 3)
+
+	moves, err := PlanRenumber(tmpDir)
+	if err != nil {
+		t.Fatalf("PlanRenumber() failed: %v", err)
+	}
+	want := []Renumbering{{From: 2, To: 1}, {From: 4, To: 2}}
+	if !slices.Equal(moves, want) {
+		t.Fatalf("PlanRenumber() = %v, want %v", moves, want)
+	}
+
+	if err := Renumber(tmpDir, moves); err != nil {
+		t.Fatalf("Renumber() failed: %v", err)
+	}
+	archives, _ := ListArchives(tmpDir)
+	if len(archives) != 2 || archives[0].ID != 1 || archives[0].Name != "two" || archives[1].ID != 2 || archives[1].Name != "four" {
+		t.Errorf("archives after Renumber() = %+v", archives)
+	}
+	if moves, _ := PlanRenumber(tmpDir); len(moves) != 0 {
+		t.Errorf("PlanRenumber() after Renumber() = %v, want nothing", moves)
+	}
+	if _, findings, _ := Verify(tmpDir, true); len(findings) != 0 {
+		t.Errorf("Verify() after Renumber() found %v", findings)
+	}
+}
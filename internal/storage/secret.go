@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	secretFileName = ".secret"
+	secretSize     = 32
+)
+
+// LoadSecret returns the signing key stored in the uploads directory. The
+// error wraps fs.ErrNotExist when none has been created yet.
+func LoadSecret(baseDir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, secretFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret file: %w", err)
+	}
+	return key, nil
+}
+
+// LoadOrCreateSecret returns the signing key stored in the uploads directory,
+// generating and persisting a new random key on first use.
+func LoadOrCreateSecret(baseDir string) ([]byte, error) {
+	key, err := LoadSecret(baseDir)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return key, err
+	}
+
+	key = make([]byte, secretSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(baseDir, secretFileName), []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write secret file: %w", err)
+	}
+
+	return key, nil
+}
@@ -1,43 +1,209 @@
 package storage
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/Firstbober/locara/internal/models"
 )
 
 const (
 	infoFileName = "info.json"
+
+	// stagingPrefix marks directories of uploads that are still being
+	// written. ListArchives ignores them as they are not numeric.
+	stagingPrefix = ".upload-"
+	// trashPrefix marks archive directories moved aside to be deleted.
+	trashPrefix = ".delete-"
+
+	// maxIDAttempts bounds retries when concurrent uploads race for an ID.
+	maxIDAttempts = 100
 )
 
-// SaveArchive saves an uploaded file and its metadata to a new archive directory.
-func SaveArchive(baseDir string, file io.Reader, header *multipart.FileHeader, meta *models.Archive) error {
-	newID, err := GenerateNextID(baseDir)
+// SaveArchive saves an uploaded file and its metadata to a new archive
+// directory. Both are written to a staging directory first, which is renamed
+// to the archive ID once complete, so an upload that fails or is cut off
+// never shows up as a partial archive. ctx carries request details for
+// logging and aborts the copy when cancelled.
+//
+// The MD5 sum of the file is recorded in meta. A checksum already set there,
+// e.g. from Content-MD5, must match or ErrChecksumMismatch is returned.
+func SaveArchive(ctx context.Context, baseDir string, file io.Reader, header *multipart.FileHeader, meta *models.Archive) (err error) {
+	staging, err := stageArchive(ctx, baseDir, file, header.Filename, meta)
 	if err != nil {
-		return fmt.Errorf("failed to generate next ID: %w", err)
+		return err
 	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(staging)
+		}
+	}()
 
-	archiveDir := archivePath(baseDir, newID)
-	if err := os.MkdirAll(archiveDir, 0755); err != nil {
-		return fmt.Errorf("failed to create archive directory: %w", err)
+	for attempt := 0; ; attempt++ {
+		newID, err := GenerateNextID(baseDir)
+		if err != nil {
+			return fmt.Errorf("failed to generate next ID: %w", err)
+		}
+		meta.ID = newID
+
+		if err := writeInfoFile(infoFilePath(staging), meta); err != nil {
+			return fmt.Errorf("failed to write info file: %w", err)
+		}
+
+		archiveDir := archivePath(baseDir, newID)
+		err = renameNoReplace(staging, archiveDir)
+		if err == nil {
+			slog.DebugContext(ctx, "Archive stored", "archive_id", newID, "path", filepath.Join(archiveDir, filepath.Base(header.Filename)))
+			return nil
+		}
+		// Another upload took the ID in the meantime.
+		if !errors.Is(err, fs.ErrExist) || attempt >= maxIDAttempts {
+			return fmt.Errorf("failed to store archive: %w", err)
+		}
 	}
+}
 
-	meta.ID = newID
+// SaveArchiveAs saves a file and its metadata as the archive with the given
+// ID, as when restoring a backup, like SaveArchive does. It fails with
+// fs.ErrExist when the ID is taken.
+func SaveArchiveAs(ctx context.Context, baseDir string, id int, file io.Reader, meta *models.Archive) (err error) {
+	staging, err := stageArchive(ctx, baseDir, file, meta.FileName, meta)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(staging)
+		}
+	}()
 
-	infoPath := infoFilePath(archiveDir)
-	if err := writeInfoFile(infoPath, meta); err != nil {
+	meta.ID = id
+	if err := writeInfoFile(infoFilePath(staging), meta); err != nil {
 		return fmt.Errorf("failed to write info file: %w", err)
 	}
+	if err := renameNoReplace(staging, archivePath(baseDir, id)); err != nil {
+		return fmt.Errorf("failed to store archive: %w", err)
+	}
+	return nil
+}
+
+// stageArchive writes the file of a new archive to a staging directory,
+// which is returned, and records its checksum in meta.
+func stageArchive(ctx context.Context, baseDir string, file io.Reader, fileName string, meta *models.Archive) (string, error) {
+	staging, err := os.MkdirTemp(baseDir, stagingPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	sum, err := saveFile(ctx, filepath.Join(staging, filepath.Base(fileName)), file)
+	if err != nil {
+		os.RemoveAll(staging)
+		return "", fmt.Errorf("failed to save archive file: %w", err)
+	}
+	if meta.MD5Sum != "" && !MD5Matches(meta.MD5Sum, sum) {
+		os.RemoveAll(staging)
+		return "", ErrChecksumMismatch
+	}
+	meta.MD5Sum = sum
+	return staging, nil
+}
+
+// renameNoReplace moves the staging directory to dst, failing with
+// fs.ErrExist rather than replacing a directory that already exists.
+func renameNoReplace(staging, dst string) error {
+	// os.Rename refuses to replace an existing directory; when another
+	// upload wins the race to the same name, rename(2) fails with ENOTEMPTY.
+	err := os.Rename(staging, dst)
+	if errors.Is(err, syscall.ENOTEMPTY) {
+		return fmt.Errorf("%s: %w", dst, fs.ErrExist)
+	}
+	return err
+}
+
+// RemoveStaging deletes staging directories left behind by uploads and
+// deletions that were interrupted, e.g. by a crash. It must only be called
+// while no upload is running.
+func RemoveStaging(baseDir string) (int, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !isStaging(entry.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(baseDir, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// metaMu serialises read-modify-write cycles of archive metadata.
+var metaMu sync.Mutex
+
+// UpdateArchive applies update to the metadata of the archive with the given
+// ID and saves the result, which is returned. The metadata is left unchanged
+// when update fails.
+func UpdateArchive(baseDir string, id int, update func(*models.Archive) error) (*models.Archive, error) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+
+	archive, err := GetArchive(baseDir, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(archive); err != nil {
+		return nil, err
+	}
+	archive.ID = id
+
+	if err := replaceInfoFile(archivePath(baseDir, id), archive); err != nil {
+		return nil, err
+	}
 
-	filePath := filepath.Join(archiveDir, header.Filename)
-	if err := saveFile(filePath, file); err != nil {
-		return fmt.Errorf("failed to save archive file: %w", err)
+	return archive, nil
+}
+
+// DeleteArchive removes the archive with the given ID and its file. The
+// directory is moved aside before it is removed, so the archive disappears
+// at once rather than file by file.
+func DeleteArchive(baseDir string, id int) error {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+
+	if _, err := GetArchive(baseDir, id); err != nil {
+		return err
+	}
+
+	trash, err := os.MkdirTemp(baseDir, trashPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	if err := os.Rename(archivePath(baseDir, id), filepath.Join(trash, strconv.Itoa(id))); err != nil {
+		os.Remove(trash)
+		return fmt.Errorf("failed to delete archive: %w", err)
+	}
+	if err := os.RemoveAll(trash); err != nil {
+		return fmt.Errorf("failed to remove archive files: %w", err)
 	}
 
 	return nil
@@ -61,14 +227,14 @@ func GetArchive(baseDir string, id int) (*models.Archive, error) {
 	return &archive, nil
 }
 
-// ListArchives returns all archives in the uploads directory.
+// ListArchives returns all archives in the uploads directory, ordered by ID.
 func ListArchives(baseDir string) ([]models.Archive, error) {
 	entries, err := os.ReadDir(baseDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read uploads directory: %w", err)
 	}
 
-	var archives []models.Archive
+	archives := []models.Archive{}
 
 	for _, entry := range entries {
 		if !entry.IsDir() {
@@ -88,9 +254,22 @@ func ListArchives(baseDir string) ([]models.Archive, error) {
 		archives = append(archives, *archive)
 	}
 
+	sort.Slice(archives, func(i, j int) bool { return archives[i].ID < archives[j].ID })
 	return archives, nil
 }
 
+// CheckWritable verifies that new files can be created in the uploads
+// directory by creating and removing a temporary one.
+func CheckWritable(baseDir string) error {
+	f, err := os.CreateTemp(baseDir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
 // GenerateNextID finds the highest existing archive ID and returns the next one.
 func GenerateNextID(baseDir string) (int, error) {
 	entries, err := os.ReadDir(baseDir)
@@ -127,7 +306,7 @@ func GetArchiveFilePath(baseDir string, id int) (string, error) {
 
 	filePath := filepath.Join(archivePath(baseDir, id), archive.FileName)
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return "", fmt.Errorf("archive file does not exist: %s", filePath)
+		return "", fmt.Errorf("archive file does not exist: %s: %w", filePath, fs.ErrNotExist)
 	}
 
 	return filePath, nil
@@ -141,10 +320,31 @@ func infoFilePath(archiveDir string) string {
 	return filepath.Join(archiveDir, infoFileName)
 }
 
+func isStaging(dirName string) bool {
+	return strings.HasPrefix(dirName, stagingPrefix) || strings.HasPrefix(dirName, trashPrefix)
+}
+
 func parseArchiveID(dirName string) (int, error) {
 	return strconv.Atoi(dirName)
 }
 
+// replaceInfoFile rewrites the info.json of an existing archive. It is
+// written next to the original and renamed over it, so readers never see a
+// partially written file.
+func replaceInfoFile(archiveDir string, meta *models.Archive) error {
+	infoPath := infoFilePath(archiveDir)
+	tmp := infoPath + ".tmp"
+	if err := writeInfoFile(tmp, meta); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write info file: %w", err)
+	}
+	if err := os.Rename(tmp, infoPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write info file: %w", err)
+	}
+	return nil
+}
+
 func writeInfoFile(path string, meta *models.Archive) error {
 	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
@@ -154,13 +354,30 @@ func writeInfoFile(path string, meta *models.Archive) error {
 	return os.WriteFile(path, data, 0644)
 }
 
-func saveFile(path string, src io.Reader) error {
+// saveFile writes src to path and returns its hex MD5 sum.
+func saveFile(ctx context.Context, path string, src io.Reader) (string, error) {
 	dst, err := os.Create(path)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, src)
-	return err
+	h := md5.New()
+	_, err = io.Copy(io.MultiWriter(dst, h), contextReader{ctx, src})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+// contextReader stops reading once ctx is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
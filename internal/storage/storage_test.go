@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,7 +49,7 @@ func TestSaveAndGetArchive(t *testing.T) {
 		Uploader:   "testuser",
 		FileName:   "test.txt",
 		SizeBytes:  100,
-		MD5Sum:     "abc123",
+		MD5Sum:     "lHP90NiApDwht3eNNIchVw==", // Content-MD5 of the content
 		UploadedOn: time.Now(),
 		Name:       "Test Archive",
 		DatedOn:    "2024-01-01",
@@ -70,7 +74,7 @@ func TestSaveAndGetArchive(t *testing.T) {
 		Size:     int64(len(content)),
 	}
 
-	if err := SaveArchive(tmpDir, file, header, meta); err != nil {
+	if err := SaveArchive(context.Background(), tmpDir, file, header, meta); err != nil {
 		t.Fatalf("SaveArchive() failed: %v", err)
 	}
 
@@ -87,6 +91,10 @@ func TestSaveAndGetArchive(t *testing.T) {
 		t.Errorf("GetArchive().Name = %s, want %s", retrieved.Name, "Test Archive")
 	}
 
+	if want := "9473fdd0d880a43c21b7778d34872157"; retrieved.MD5Sum != want {
+		t.Errorf("GetArchive().MD5Sum = %s, want %s", retrieved.MD5Sum, want)
+	}
+
 	filePath, err := GetArchiveFilePath(tmpDir, 1)
 	if err != nil {
 		t.Fatalf("GetArchiveFilePath() failed: %v", err)
@@ -97,6 +105,41 @@ func TestSaveAndGetArchive(t *testing.T) {
 	}
 }
 
+func TestSaveArchiveChecksumMismatch(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	meta := &models.Archive{FileName: "test.txt", MD5Sum: "0123456789abcdef0123456789abcdef"}
+	header := &multipart.FileHeader{Filename: "test.txt", Size: 4}
+	err := SaveArchive(context.Background(), tmpDir, strings.NewReader("data"), header, meta)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("SaveArchive() = %v, want ErrChecksumMismatch", err)
+	}
+
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+		t.Errorf("uploads directory has %d entries after failed upload, want 0", len(entries))
+	}
+}
+
+func TestSaveArchiveAs(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	meta := &models.Archive{Name: "Restored", FileName: "restored.txt", MD5Sum: "9a0364b9e99bb480dd25e1f0284c8555"}
+	if err := SaveArchiveAs(context.Background(), tmpDir, 7, strings.NewReader("content"), meta); err != nil {
+		t.Fatalf("SaveArchiveAs() failed: %v", err)
+	}
+	if stored, err := GetArchive(tmpDir, 7); err != nil || stored.ID != 7 || stored.Name != "Restored" {
+		t.Errorf("GetArchive(7) = %+v, %v", stored, err)
+	}
+
+	err := SaveArchiveAs(context.Background(), tmpDir, 7, strings.NewReader("other"), &models.Archive{FileName: "other.txt"})
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("SaveArchiveAs() to a taken ID = %v, want fs.ErrExist", err)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 1 {
+		t.Errorf("uploads directory has %d entries after failed restore, want 1", len(entries))
+	}
+}
+
 func TestListArchives(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -134,3 +177,151 @@ func TestListArchives(t *testing.T) {
 		t.Errorf("ListArchives() returned %d archives, want %d", len(archives), 3)
 	}
 }
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("connection reset")
+}
+
+func TestSaveArchiveCleansUpOnError(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	header := &multipart.FileHeader{Filename: "broken.bin"}
+	if err := SaveArchive(context.Background(), tmpDir, failingReader{}, header, &models.Archive{Name: "Broken"}); err == nil {
+		t.Fatal("SaveArchive() with failing reader succeeded, want error")
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadDir() failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("SaveArchive() left %d entries behind, want none", len(entries))
+	}
+}
+
+func TestSaveArchiveConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const uploads = 8
+	errs := make(chan error, uploads)
+	for i := range uploads {
+		go func() {
+			header := &multipart.FileHeader{Filename: fmt.Sprintf("file%d.txt", i)}
+			content := strings.NewReader(fmt.Sprintf("content %d", i))
+			errs <- SaveArchive(context.Background(), tmpDir, content, header, &models.Archive{Name: header.Filename, FileName: header.Filename})
+		}()
+	}
+	for range uploads {
+		if err := <-errs; err != nil {
+			t.Fatalf("SaveArchive() failed: %v", err)
+		}
+	}
+
+	archives, err := ListArchives(tmpDir)
+	if err != nil {
+		t.Fatalf("ListArchives() failed: %v", err)
+	}
+	if len(archives) != uploads {
+		t.Fatalf("ListArchives() returned %d archives, want %d", len(archives), uploads)
+	}
+	for _, a := range archives {
+		path, err := GetArchiveFilePath(tmpDir, a.ID)
+		if err != nil {
+			t.Errorf("archive %d: %v", a.ID, err)
+			continue
+		}
+		if filepath.Base(path) != a.FileName {
+			t.Errorf("archive %d holds %s, want %s", a.ID, filepath.Base(path), a.FileName)
+		}
+	}
+}
+
+func TestRemoveStaging(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, dir := range []string{stagingPrefix + "abc", trashPrefix + "def", "1"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+	}
+
+	n, err := RemoveStaging(tmpDir)
+	if err != nil || n != 2 {
+		t.Fatalf("RemoveStaging() = %d, %v, want 2, nil", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "1")); err != nil {
+		t.Errorf("RemoveStaging() removed an archive directory: %v", err)
+	}
+}
+
+func saveTestArchive(t *testing.T, dir, name string) *models.Archive {
+	t.Helper()
+
+	meta := &models.Archive{Name: name, FileName: name + ".txt", SizeBytes: 7, DatedOn: "2024-01-01"}
+	header := &multipart.FileHeader{Filename: meta.FileName, Size: 7}
+	if err := SaveArchive(context.Background(), dir, strings.NewReader("content"), header, meta); err != nil {
+		t.Fatalf("SaveArchive() failed: %v", err)
+	}
+	return meta
+}
+
+func TestUpdateArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	meta := saveTestArchive(t, tmpDir, "before")
+
+	updated, err := UpdateArchive(tmpDir, meta.ID, func(a *models.Archive) error {
+		a.Name = "after"
+		a.ID = 99
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateArchive() failed: %v", err)
+	}
+	if updated.Name != "after" || updated.ID != meta.ID {
+		t.Errorf("UpdateArchive() = %+v, want name %q and ID %d", updated, "after", meta.ID)
+	}
+
+	stored, err := GetArchive(tmpDir, meta.ID)
+	if err != nil || stored.Name != "after" || stored.FileName != meta.FileName {
+		t.Errorf("GetArchive() after update = %+v, %v", stored, err)
+	}
+
+	_, err = UpdateArchive(tmpDir, meta.ID, func(a *models.Archive) error {
+		a.Name = "discarded"
+		return fmt.Errorf("invalid")
+	})
+	if err == nil {
+		t.Error("UpdateArchive() with failing update succeeded")
+	}
+	if stored, _ := GetArchive(tmpDir, meta.ID); stored.Name != "after" {
+		t.Errorf("failed update was saved: name = %q", stored.Name)
+	}
+
+	if _, err := UpdateArchive(tmpDir, 42, func(*models.Archive) error { return nil }); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("UpdateArchive() of missing archive = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestDeleteArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	first := saveTestArchive(t, tmpDir, "first")
+	second := saveTestArchive(t, tmpDir, "second")
+
+	if err := DeleteArchive(tmpDir, first.ID); err != nil {
+		t.Fatalf("DeleteArchive() failed: %v", err)
+	}
+
+	archives, err := ListArchives(tmpDir)
+	if err != nil || len(archives) != 1 || archives[0].ID != second.ID {
+		t.Errorf("ListArchives() after delete = %v, %v, want only archive %d", archives, err, second.ID)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 1 {
+		t.Errorf("DeleteArchive() left %d entries, want 1", len(entries))
+	}
+
+	if err := DeleteArchive(tmpDir, first.ID); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("DeleteArchive() of deleted archive = %v, want fs.ErrNotExist", err)
+	}
+}
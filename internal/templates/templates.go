@@ -2,18 +2,25 @@ package templates
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"text/template/parse"
 	"time"
 
 	"github.com/Firstbober/locara/internal/models"
 )
 
+// DevDir is the template source directory, used as the override directory
+// in dev mode so template edits show up without rebuilding.
+const DevDir = "internal/templates/templates"
+
 //go:embed templates
 var templateFS embed.FS
 
@@ -22,49 +29,20 @@ func ParseTemplates() (*template.Template, error) {
 	return ParseTemplatesWithFS(templateFS)
 }
 
-// ParseTemplatesFromFS tries to parse from filesystem first, then falls back to embed.
-func ParseTemplatesFromFS() (*template.Template, error) {
-	// Try filesystem first (for development)
-	tmpl := template.New("").Funcs(getTemplateFuncMap())
-
-	// Parse main templates
-	mainGlob := "internal/templates/templates/*.html"
-	mainFiles, err := filepath.Glob(mainGlob)
-	if err == nil {
-		for _, file := range mainFiles {
-			content, err := os.ReadFile(file)
-			if err != nil {
-				continue
-			}
-			name := filepath.Base(file)
-			if _, err := tmpl.New(name).Parse(string(content)); err != nil {
-				fmt.Printf("Warning: failed to parse template %s: %v\n", file, err)
-			}
-		}
-	}
-
-	// Parse partials
-	partialsGlob := "internal/templates/templates/partials/*.html"
-	partialsFiles, err := filepath.Glob(partialsGlob)
-	if err == nil {
-		for _, file := range partialsFiles {
-			content, err := os.ReadFile(file)
-			if err != nil {
-				continue
-			}
-			name := "partials/" + filepath.Base(file)
-			if _, err := tmpl.New(name).Parse(string(content)); err != nil {
-				fmt.Printf("Warning: failed to parse template %s: %v\n", file, err)
-			}
-		}
+// ParseTemplatesWithOverrides parses the embedded templates and replaces
+// those with a counterpart in dir, so operators can customise individual
+// pages or partials. An empty dir uses the embedded templates only.
+func ParseTemplatesWithOverrides(dir string) (*template.Template, error) {
+	tmpl, err := ParseTemplates()
+	if err != nil || dir == "" {
+		return tmpl, err
 	}
 
-	if len(mainFiles) > 0 || len(partialsFiles) > 0 {
-		return tmpl, nil
+	if err := applyOverrides(tmpl, os.DirFS(dir)); err != nil {
+		return nil, fmt.Errorf("invalid template overrides in %s: %w", dir, err)
 	}
 
-	// Fallback to embedded templates
-	return ParseTemplates()
+	return tmpl, nil
 }
 
 // ParseTemplatesWithFS parses templates from a given filesystem.
@@ -100,17 +78,167 @@ func ParseTemplatesWithFS(fsys fs.FS) (*template.Template, error) {
 	return tmpl, nil
 }
 
+// applyOverrides parses every template file in fsys over the embedded set.
+// Each file must match an embedded file by relative path (partials/navbar.html)
+// or by unique base name (navbar.html), parse on its own and define the same
+// template names as the file it replaces. All problems are reported together.
+func applyOverrides(tmpl *template.Template, fsys fs.FS) error {
+	defaults, err := definedTemplates(templateFS, "templates")
+	if err != nil {
+		return err
+	}
+
+	owner := make(map[string]string)
+	for path, names := range defaults {
+		for _, name := range names {
+			owner[name] = path
+		}
+	}
+
+	var errs []error
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isTemplateFile(path) {
+			return nil
+		}
+
+		target, ok := matchOverride(defaults, path)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: does not match any built-in template", path))
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			return nil
+		}
+
+		names, err := parseDefinitions(fsPathToTemplateName("templates/"+target), content)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			return nil
+		}
+
+		for _, want := range defaults[target] {
+			if !slices.Contains(names, want) {
+				errs = append(errs, fmt.Errorf("%s: must define template %q", path, want))
+			}
+		}
+		for _, name := range names {
+			if other, ok := owner[name]; ok && other != target {
+				errs = append(errs, fmt.Errorf("%s: defines %q, which belongs to %s", path, name, other))
+			}
+		}
+
+		if _, err := tmpl.New(fsPathToTemplateName("templates/" + target)).Parse(string(content)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+// definedTemplates returns the template names defined by each template file
+// below root, keyed by path relative to root.
+func definedTemplates(fsys fs.FS, root string) (map[string][]string, error) {
+	defs := make(map[string][]string)
+
+	err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isTemplateFile(path) {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+
+		names, err := parseDefinitions(fsPathToTemplateName(path), content)
+		if err != nil {
+			return fmt.Errorf("failed to parse template %s: %w", path, err)
+		}
+
+		defs[strings.TrimPrefix(path, root+"/")] = names
+		return nil
+	})
+
+	return defs, err
+}
+
+// parseDefinitions parses a template file on its own and returns the names
+// of the non-empty templates it defines.
+func parseDefinitions(name string, content []byte) ([]string, error) {
+	t, err := template.New(name).Funcs(getTemplateFuncMap()).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, def := range t.Templates() {
+		if def.Tree == nil || parse.IsEmptyTree(def.Tree.Root) {
+			continue
+		}
+		names = append(names, def.Name())
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// matchOverride finds the built-in template file an override replaces.
+func matchOverride(defaults map[string][]string, path string) (string, bool) {
+	if _, ok := defaults[path]; ok {
+		return path, true
+	}
+
+	match := ""
+	for candidate := range defaults {
+		if filepath.Base(candidate) != filepath.Base(path) {
+			continue
+		}
+		if match != "" {
+			return "", false
+		}
+		match = candidate
+	}
+
+	return match, match != "" && !strings.Contains(path, "/")
+}
+
 // getTemplateFuncMap returns custom template functions.
 func getTemplateFuncMap() template.FuncMap {
 	return template.FuncMap{
-		"prettyBytes": prettyBytes,
+		"prettyBytes": PrettyBytes,
 		"formatDate":  formatDate,
 		"groupByYear": groupByYear,
+		"asset":       assetPath,
 	}
 }
 
-// prettyBytes formats bytes into human-readable string.
-func prettyBytes(b int64) string {
+// SetAssetFunc replaces the function resolving static asset names to URL
+// paths, e.g. to point templates at fingerprinted files.
+func SetAssetFunc(tmpl *template.Template, fn func(name string) string) {
+	tmpl.Funcs(template.FuncMap{"asset": fn})
+}
+
+// assetPath is the default asset resolver, linking to files unversioned.
+func assetPath(name string) string {
+	return "/static/" + strings.TrimPrefix(name, "/")
+}
+
+// PrettyBytes formats bytes into human-readable string.
+func PrettyBytes(b int64) string {
 	const unit = 1024
 	if b < unit {
 		return fmt.Sprintf("%d B", b)
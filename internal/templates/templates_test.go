@@ -0,0 +1,84 @@
+package templates
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/config"
+)
+
+func writeOverride(t *testing.T, dir, name, content string) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write override: %v", err)
+	}
+}
+
+func TestParseTemplatesWithOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeOverride(t, dir, "navbar.html", `{{define "navbar.html"}}<nav>custom navbar</nav>{{end}}`)
+
+	tmpl, err := ParseTemplatesWithOverrides(dir)
+	if err != nil {
+		t.Fatalf("ParseTemplatesWithOverrides() failed: %v", err)
+	}
+
+	data := struct {
+		Cfg      *config.Config
+		User     any
+		Archives any
+	}{Cfg: &config.Config{}}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "upload.html", data); err != nil {
+		t.Fatalf("ExecuteTemplate() failed: %v", err)
+	}
+
+	page := buf.String()
+	if !strings.Contains(page, "custom navbar") {
+		t.Errorf("Rendered page does not use the navbar override")
+	}
+	if !strings.Contains(page, "ar_auth_code") {
+		t.Errorf("Rendered page lost the embedded upload form")
+	}
+}
+
+func TestParseTemplatesWithInvalidOverrides(t *testing.T) {
+	tests := map[string]struct {
+		name    string
+		content string
+		want    string
+	}{
+		"syntax error":    {"partials/navbar.html", `{{define "navbar.html"}}{{if}}{{end}}`, "partials/navbar.html"},
+		"missing define":  {"navbar.html", `<nav>custom</nav>`, `must define template "navbar.html"`},
+		"unknown file":    {"footer.html", `{{define "footer.html"}}{{end}}`, "does not match any built-in template"},
+		"foreign define":  {"error.html", `{{define "error.html"}}oops{{end}}{{define "navbar.html"}}nav{{end}}`, "belongs to partials/navbar.html"},
+		"unknown partial": {"partials/index.html", `{{define "index.html"}}{{end}}`, "does not match any built-in template"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeOverride(t, dir, tt.name, tt.content)
+
+			_, err := ParseTemplatesWithOverrides(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseTemplatesWithOverrides() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplatesWithDevDir(t *testing.T) {
+	if _, err := ParseTemplatesWithOverrides("templates"); err != nil {
+		t.Errorf("Embedded templates are not valid overrides of themselves: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval limits how often the certificate files are checked for
+// changes during handshakes.
+const checkInterval = 10 * time.Second
+
+// Reloader serves a certificate and key pair from disk, picking up new files
+// written by external renewal (e.g. certbot) without a restart.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	now      func() time.Time
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewReloader loads the certificate and key pair, failing when they cannot
+// be used.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first when the
+// files changed. It is meant for tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.lastCheck) < checkInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = now
+
+	if modTime, err := r.newestModTime(); err == nil && !modTime.Equal(r.modTime) {
+		if err := r.loadLocked(); err != nil {
+			slog.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+		} else {
+			slog.Info("Reloaded TLS certificate", "file", r.certFile)
+		}
+	}
+
+	return r.cert, nil
+}
+
+func (r *Reloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastCheck = r.now()
+	return r.loadLocked()
+}
+
+func (r *Reloader) loadLocked() error {
+	modTime, err := r.newestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+// newestModTime returns the later modification time of the two files, so a
+// change to either triggers a reload.
+func (r *Reloader) newestModTime() (time.Time, error) {
+	var newest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
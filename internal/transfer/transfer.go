@@ -0,0 +1,279 @@
+// Package transfer keeps track of running uploads and downloads so shutdown
+// can wait for them and report their progress.
+package transfer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Firstbober/locara/internal/metrics"
+)
+
+// copyChunk is how much of a download is sent between progress updates.
+const copyChunk = 1 << 20
+
+// Tracker holds the transfers in progress.
+type Tracker struct {
+	mu     sync.Mutex
+	active map[*Transfer]struct{}
+	idle   chan struct{} // closed while no transfer is active
+}
+
+// NewTracker returns an empty tracker.
+func NewTracker() *Tracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &Tracker{active: make(map[*Transfer]struct{}), idle: idle}
+}
+
+// Transfer is a single upload or download.
+type Transfer struct {
+	Direction string // metrics.Upload or metrics.Download
+	Size      int64  // expected size in bytes, or -1 when unknown
+	Started   time.Time
+
+	tracker *Tracker
+	bytes   atomic.Int64
+	once    sync.Once
+
+	rc       *http.ResponseController
+	idle     time.Duration
+	extended time.Time
+
+	mu   sync.Mutex
+	name string
+}
+
+// Progress is a snapshot of a transfer.
+type Progress struct {
+	Direction string
+	Name      string
+	Bytes     int64
+	Size      int64
+	Elapsed   time.Duration
+}
+
+// Start registers a transfer in the given direction. Finish must be called
+// when it ends.
+func (t *Tracker) Start(direction, name string, size int64) *Transfer {
+	tr := &Transfer{Direction: direction, Size: size, Started: time.Now(), tracker: t, name: name}
+
+	t.mu.Lock()
+	if len(t.active) == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.active[tr] = struct{}{}
+	t.mu.Unlock()
+
+	metrics.ActiveTransfers.With(direction).Inc()
+	return tr
+}
+
+// Len returns the number of transfers in progress.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.active)
+}
+
+// Active returns the progress of every running transfer, oldest first.
+func (t *Tracker) Active() []Progress {
+	t.mu.Lock()
+	transfers := make([]*Transfer, 0, len(t.active))
+	for tr := range t.active {
+		transfers = append(transfers, tr)
+	}
+	t.mu.Unlock()
+
+	now := time.Now()
+	out := make([]Progress, 0, len(transfers))
+	for _, tr := range transfers {
+		out = append(out, Progress{
+			Direction: tr.Direction,
+			Name:      tr.Name(),
+			Bytes:     tr.bytes.Load(),
+			Size:      tr.Size,
+			Elapsed:   now.Sub(tr.Started),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Elapsed > out[j].Elapsed })
+	return out
+}
+
+// Wait blocks until no transfer is active or ctx is done.
+func (t *Tracker) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Name returns the name the transfer is reported under.
+func (tr *Transfer) Name() string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.name
+}
+
+// SetName changes the name the transfer is reported under, e.g. once the
+// file name of an upload is known.
+func (tr *Transfer) SetName(name string) {
+	tr.mu.Lock()
+	tr.name = name
+	tr.mu.Unlock()
+}
+
+// Finish removes the transfer from the tracker. Calling it more than once
+// has no effect.
+func (tr *Transfer) Finish() {
+	tr.once.Do(func() {
+		t := tr.tracker
+		t.mu.Lock()
+		delete(t.active, tr)
+		if len(t.active) == 0 {
+			close(t.idle)
+		}
+		t.mu.Unlock()
+
+		metrics.ActiveTransfers.With(tr.Direction).Dec()
+	})
+}
+
+// KeepAlive replaces the server's read and write timeouts for the request
+// w answers, which would cut off long transfers, with a limit on how long
+// the transfer may go without progress: every chunk moves the deadline of
+// its direction idle into the future. The other direction is not limited;
+// an upload is answered with a few bytes and a download has no body to read.
+// It must be called before the transfer makes progress.
+func (tr *Transfer) KeepAlive(w http.ResponseWriter, idle time.Duration) {
+	tr.rc = http.NewResponseController(w)
+	tr.idle = idle
+
+	// Errors mean the writer cannot set deadlines, and so has none to lift.
+	if tr.Direction == metrics.Upload {
+		tr.rc.SetWriteDeadline(time.Time{})
+	} else {
+		tr.rc.SetReadDeadline(time.Time{})
+	}
+	tr.extend()
+}
+
+// extend moves the deadline of a kept alive transfer, at most ten times per
+// idle period.
+func (tr *Transfer) extend() {
+	if tr.rc == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(tr.extended) < tr.idle/10 {
+		return
+	}
+	tr.extended = now
+
+	if tr.Direction == metrics.Upload {
+		tr.rc.SetReadDeadline(now.Add(tr.idle))
+	} else {
+		tr.rc.SetWriteDeadline(now.Add(tr.idle))
+	}
+}
+
+func (tr *Transfer) add(n int64) {
+	tr.bytes.Add(n)
+	if tr.Direction == metrics.Upload {
+		metrics.UploadBytes.Add(n)
+	} else {
+		metrics.DownloadBytes.Add(n)
+	}
+	tr.extend()
+}
+
+// Reader returns rc counting every byte read from it as progress.
+func (tr *Transfer) Reader(rc io.ReadCloser) io.ReadCloser {
+	return &countingReader{ReadCloser: rc, tr: tr}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	tr *Transfer
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.tr.add(int64(n))
+	return n, err
+}
+
+// Copy copies src to dst like io.Copy, counting progress after every chunk.
+// Each chunk is copied with io.CopyN so writers implementing io.ReaderFrom,
+// such as HTTP responses sending a file, keep their fast path.
+func (tr *Transfer) Copy(dst io.Writer, src io.Reader) (int64, error) {
+	var written int64
+	for {
+		n, err := io.CopyN(dst, src, copyChunk)
+		written += n
+		tr.add(n)
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// ResponseWriter returns w counting every byte written to it as progress,
+// for responses sent by http.ServeContent.
+func (tr *Transfer) ResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	return &countingWriter{ResponseWriter: w, tr: tr}
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	tr *Transfer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.tr.add(int64(n))
+	return n, err
+}
+
+// ReadFrom copies src in chunks like Copy. The limit io.CopyN wraps a file in
+// is unwrapped so the file still reaches the underlying writer's fast path.
+func (w *countingWriter) ReadFrom(src io.Reader) (int64, error) {
+	lr, ok := src.(*io.LimitedReader)
+	if !ok {
+		return w.tr.Copy(w.ResponseWriter, src)
+	}
+
+	var written int64
+	for lr.N > 0 {
+		n, err := io.CopyN(w.ResponseWriter, lr.R, min(lr.N, copyChunk))
+		lr.N -= n
+		written += n
+		w.tr.add(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
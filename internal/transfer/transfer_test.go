@@ -0,0 +1,101 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firstbober/locara/internal/metrics"
+)
+
+func TestTrackerWait(t *testing.T) {
+	tracker := NewTracker()
+
+	if err := tracker.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() on idle tracker = %v, want nil", err)
+	}
+
+	upload := tracker.Start(metrics.Upload, "big.iso", 100)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tracker.Wait(ctx); err == nil {
+		t.Fatal("Wait() with a running transfer returned before ctx was done")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- tracker.Wait(context.Background()) }()
+	upload.Finish()
+	upload.Finish()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait() = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after Finish()")
+	}
+	if n := tracker.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func TestTransferProgress(t *testing.T) {
+	tracker := NewTracker()
+
+	upload := tracker.Start(metrics.Upload, "", 11)
+	upload.SetName("upload.txt")
+	if _, err := io.ReadAll(upload.Reader(io.NopCloser(strings.NewReader("hello world")))); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+
+	download := tracker.Start(metrics.Download, "download.bin", 3<<20)
+	var dst bytes.Buffer
+	n, err := download.Copy(&dst, bytes.NewReader(make([]byte, 3<<20)))
+	if err != nil || n != 3<<20 {
+		t.Fatalf("Copy() = %d, %v, want %d, nil", n, err, 3<<20)
+	}
+
+	active := tracker.Active()
+	if len(active) != 2 {
+		t.Fatalf("Active() returned %d transfers, want 2", len(active))
+	}
+	got := map[string]int64{}
+	for _, p := range active {
+		got[p.Name] = p.Bytes
+	}
+	if got["upload.txt"] != 11 || got["download.bin"] != 3<<20 {
+		t.Errorf("progress = %v, want upload.txt 11 and download.bin %d", got, 3<<20)
+	}
+
+	upload.Finish()
+	download.Finish()
+}
+
+func TestTransferResponseWriter(t *testing.T) {
+	tracker := NewTracker()
+	download := tracker.Start(metrics.Download, "range.bin", 3<<20)
+	defer download.Finish()
+
+	content := bytes.NewReader(bytes.Repeat([]byte("0123456789"), 300<<10))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=1000-")
+	rec := httptest.NewRecorder()
+	http.ServeContent(download.ResponseWriter(rec), req, "", time.Time{}, content)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	want := int64(content.Size() - 1000)
+	if got := int64(rec.Body.Len()); got != want {
+		t.Errorf("body has %d bytes, want %d", got, want)
+	}
+	if p := tracker.Active()[0]; p.Bytes != want {
+		t.Errorf("progress = %d bytes, want %d", p.Bytes, want)
+	}
+}
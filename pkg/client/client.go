@@ -0,0 +1,446 @@
+// Package client talks to the versioned HTTP API of a Locara server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Firstbober/locara/internal/models"
+)
+
+// apiPath is where the versioned API is served below the server URL.
+const apiPath = "/api/v1"
+
+// Archive is the metadata of an archive as returned by the server.
+type Archive = models.Archive
+
+// ProgressFunc is called while a file is transferred with the bytes done so
+// far and the total, or -1 when the total is unknown.
+type ProgressFunc func(done, total int64)
+
+// Client is a client of one Locara server.
+type Client struct {
+	// HTTPClient sends the requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+
+	baseURL string
+	token   string
+}
+
+// New returns a client of the server at baseURL, including its base_url
+// path if it has one, e.g. "https://example.com/locara". token is the auth
+// code sent as bearer token; it may be empty for reading.
+func New(baseURL, token string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), token: token}
+}
+
+// Error is an error response of the server.
+type Error struct {
+	StatusCode int
+	// Code is the machine-readable error code, e.g. "not_found".
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server answered %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server answered %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is the server saying the archive does not
+// exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// Query narrows down the archives returned by Search. Empty fields match
+// every archive.
+type Query struct {
+	// Text is searched for in the name, author, type and file name,
+	// ignoring case.
+	Text     string
+	Type     string
+	Author   string
+	Uploader string
+	// Year is the year the archives are dated in, 0 for any.
+	Year int
+}
+
+func (q Query) values() url.Values {
+	v := url.Values{}
+	for key, value := range map[string]string{"q": q.Text, "type": q.Type, "author": q.Author, "uploader": q.Uploader} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	if q.Year != 0 {
+		v.Set("year", fmt.Sprintf("%04d", q.Year))
+	}
+	return v
+}
+
+// List returns every archive, ordered by ID.
+func (c *Client) List(ctx context.Context) ([]Archive, error) {
+	return c.Search(ctx, Query{})
+}
+
+// Search returns the archives matching q, ordered by ID.
+func (c *Client) Search(ctx context.Context, q Query) ([]Archive, error) {
+	path := "/archives"
+	if v := q.values(); len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+
+	var archives []Archive
+	if err := c.do(ctx, http.MethodGet, path, http.StatusOK, &archives); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// Get returns the metadata of the archive with the given ID.
+func (c *Client) Get(ctx context.Context, id int) (*Archive, error) {
+	var archive Archive
+	if err := c.do(ctx, http.MethodGet, archivePath(id), http.StatusOK, &archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// Delete deletes the archive with the given ID, its file and its share
+// links.
+func (c *Client) Delete(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, archivePath(id), http.StatusNoContent, nil)
+}
+
+// Upload is a file to upload with the metadata of the new archive.
+type Upload struct {
+	// FileName is the name the file is stored under.
+	FileName string
+	Content  io.Reader
+	// Size is the length of Content, or -1 when unknown. A known size lets
+	// the server refuse oversized uploads before they are sent.
+	Size int64
+
+	Name    string
+	DatedOn string
+	Type    string
+	Author  string
+
+	// Progress, if set, is called as the content is sent.
+	Progress ProgressFunc
+}
+
+// Upload creates an archive and returns its metadata.
+func (c *Client) Upload(ctx context.Context, u Upload) (*Archive, error) {
+	// The multipart body is assembled around the content so it is streamed
+	// rather than buffered, with a known length when its size is known.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range []struct{ name, value string }{
+		{"name", u.Name},
+		{"dated_on", u.DatedOn},
+		{"type", u.Type},
+		{"author", u.Author},
+	} {
+		if err := mw.WriteField(f.name, f.value); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := mw.CreateFormFile("file", u.FileName); err != nil {
+		return nil, err
+	}
+	head := bytes.Clone(buf.Bytes())
+	buf.Reset()
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	tail := buf.Bytes()
+
+	var content io.Reader = u.Content
+	if u.Progress != nil {
+		content = &progressReader{r: content, total: u.Size, progress: u.Progress}
+	}
+	body := io.MultiReader(bytes.NewReader(head), content, bytes.NewReader(tail))
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/archives", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if u.Size >= 0 {
+		req.ContentLength = int64(len(head)) + u.Size + int64(len(tail))
+	}
+
+	var archive Archive
+	if err := c.send(req, http.StatusCreated, &archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// UploadFile uploads the file at path, named after its base name unless
+// u.FileName is set. u.Content and u.Size are filled in from the file.
+func (c *Client) UploadFile(ctx context.Context, path string, u Upload) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if u.FileName == "" {
+		u.FileName = info.Name()
+	}
+	u.Content, u.Size = f, info.Size()
+	return c.Upload(ctx, u)
+}
+
+// Download writes the file of the archive with the given ID to dst,
+// starting offset bytes into it, and returns the number of bytes written.
+// It fails without writing anything if the server sends the whole file
+// although a part was asked for.
+func (c *Client) Download(ctx context.Context, id int, dst io.Writer, offset int64, progress ProgressFunc) (int64, error) {
+	resp, err := c.download(ctx, id, offset, "")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("server sent the whole file instead of the part from byte %d", offset)
+	}
+	return copyBody(dst, resp, offset, progress)
+}
+
+// ErrChecksumMismatch is returned by DownloadFile when the downloaded file
+// does not match the MD5 sum the server recorded for the archive.
+var ErrChecksumMismatch = errors.New("downloaded file does not match the archive's MD5 sum")
+
+// DownloadFile saves the file of the archive with the given ID at path,
+// replacing any file there, and returns its metadata. The file is written to
+// path with a .part suffix and only renamed once its MD5 sum matches the
+// archive's; a .part file left by an interrupted download of the same
+// archive is resumed.
+func (c *Client) DownloadFile(ctx context.Context, id int, path string, progress ProgressFunc) (*Archive, error) {
+	archive, err := c.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	partPath := path + ".part"
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size()
+	if offset > archive.SizeBytes {
+		offset = 0
+	}
+
+	// If-Range makes the server send the whole file when the archive was
+	// replaced since the earlier download.
+	resp, err := c.download(ctx, id, offset, archive.UploadedOn.UTC().Format(http.TimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		offset = 0
+	}
+	if err := f.Truncate(offset); err != nil {
+		return nil, err
+	}
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, offset)); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := copyBody(io.MultiWriter(f, hash), resp, offset, progress); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if archive.MD5Sum != "" && !strings.EqualFold(archive.MD5Sum, hex.EncodeToString(hash.Sum(nil))) {
+		// Resuming from it would fail again.
+		os.Remove(partPath)
+		return nil, ErrChecksumMismatch
+	}
+	return archive, os.Rename(partPath, path)
+}
+
+// download requests the file of an archive from offset on. The response has
+// status 200 or 206; a request for the part after the end of a complete
+// file is answered with an empty 206.
+func (c *Client) download(ctx context.Context, id int, offset int64, ifRange string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, archivePath(id)+"/file", nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		if size, ok := rangeSize(resp.Header.Get("Content-Range")); ok && size == offset {
+			resp.StatusCode = http.StatusPartialContent
+			resp.Body = http.NoBody
+			resp.ContentLength = 0
+			return resp, nil
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: "requested part lies outside the file"}
+	default:
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+}
+
+// rangeSize returns the file size from the Content-Range of a 416 response,
+// "bytes */<size>".
+func rangeSize(contentRange string) (int64, bool) {
+	size, ok := strings.CutPrefix(contentRange, "bytes */")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	return n, err == nil
+}
+
+func copyBody(dst io.Writer, resp *http.Response, offset int64, progress ProgressFunc) (int64, error) {
+	var body io.Reader = resp.Body
+	if progress != nil {
+		total := int64(-1)
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
+		}
+		progress(offset, total)
+		body = &progressReader{r: body, done: offset, total: total, progress: progress}
+	}
+	return io.Copy(dst, body)
+}
+
+func archivePath(id int) string {
+	return "/archives/" + strconv.Itoa(id)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPath+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends a request without body and decodes the response into out, which
+// may be nil.
+func (c *Client) do(ctx context.Context, method, path string, want int, out any) error {
+	req, err := c.newRequest(ctx, method, path, nil)
+	if err != nil {
+		return err
+	}
+	return c.send(req, want, out)
+}
+
+// send sends req and decodes the response into out, failing unless it has
+// the status want.
+func (c *Client) send(req *http.Request, want int, out any) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// responseError reads the JSON error body of a failed request.
+func responseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var body struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil {
+			e.Code, e.Message = body.Code, body.Error
+		}
+	}
+	return e
+}
+
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
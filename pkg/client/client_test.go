@@ -0,0 +1,249 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Firstbober/locara/internal/auth"
+	"github.com/Firstbober/locara/internal/config"
+	"github.com/Firstbober/locara/internal/handlers"
+	"github.com/Firstbober/locara/internal/share"
+	"github.com/Firstbober/locara/internal/transfer"
+)
+
+const testToken = "test-code"
+
+// newTestServer serves the versioned API of the real handlers below the
+// base URL /locara.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	cfg := &config.Config{
+		BaseUrl:      "/locara",
+		UseDirectory: t.TempDir(),
+		Users:        []config.User{{Name: "tester", Auth: testToken, Role: config.RoleUploader}},
+	}
+	cfg.LoginThrottle.Disabled = true
+	throttle := auth.NewThrottle(cfg.LoginThrottle)
+	transfers := transfer.NewTracker()
+	shares, err := share.Open(cfg.UseDirectory, []byte("secret"))
+	if err != nil {
+		t.Fatalf("share.Open() failed: %v", err)
+	}
+
+	prefix := handlers.APIPrefix
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix+"/archives", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListArchivesHandler(w, r, cfg)
+	})
+	mux.HandleFunc("POST "+prefix+"/archives", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UploadArchiveHandler(w, r, cfg, throttle, transfers)
+	})
+	mux.HandleFunc("GET "+prefix+"/archives/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetArchiveHandler(w, r, cfg)
+	})
+	mux.HandleFunc("DELETE "+prefix+"/archives/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteArchiveHandler(w, r, cfg, shares, throttle)
+	})
+	mux.HandleFunc("GET "+prefix+"/archives/{id}/file", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ArchiveFileHandler(w, r, cfg, transfers)
+	})
+
+	server := httptest.NewServer(http.StripPrefix(cfg.BaseUrl, mux))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func upload(t *testing.T, c *Client, content, name, datedOn, typ string) *Archive {
+	t.Helper()
+
+	archive, err := c.Upload(context.Background(), Upload{
+		FileName: name + ".txt",
+		Content:  strings.NewReader(content),
+		Size:     int64(len(content)),
+		Name:     name,
+		DatedOn:  datedOn,
+		Type:     typ,
+		Author:   "Author",
+	})
+	if err != nil {
+		t.Fatalf("Upload() failed: %v", err)
+	}
+	return archive
+}
+
+func TestUploadAndSearch(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.URL+"/locara/", testToken)
+	ctx := context.Background()
+
+	var done, total int64
+	content := strings.Repeat("x", 100<<10)
+	archive, err := c.Upload(ctx, Upload{
+		FileName: "tape.mp4",
+		Content:  strings.NewReader(content),
+		Size:     -1,
+		Name:     "Summer Tape",
+		DatedOn:  "2003-07-01",
+		Type:     "video",
+		Author:   "Author",
+		Progress: func(d, t int64) { done, total = d, t },
+	})
+	if err != nil {
+		t.Fatalf("Upload() failed: %v", err)
+	}
+	if archive.ID != 1 || archive.Uploader != "tester" || archive.SizeBytes != int64(len(content)) {
+		t.Errorf("Upload() = %+v, want archive 1 of tester with %d bytes", archive, len(content))
+	}
+	if done != int64(len(content)) || total != -1 {
+		t.Errorf("last progress = %d of %d, want %d of -1", done, total, len(content))
+	}
+
+	upload(t, c, "minutes", "Minutes", "2003-02-01", "document")
+	upload(t, c, "later", "Later Tape", "2010-01-01", "video")
+
+	tests := []struct {
+		query Query
+		want  []int
+	}{
+		{Query{}, []int{1, 2, 3}},
+		{Query{Type: "VIDEO"}, []int{1, 3}},
+		{Query{Type: "video", Year: 2003}, []int{1}},
+		{Query{Text: "tape"}, []int{1, 3}},
+		{Query{Uploader: "someone"}, nil},
+	}
+	for _, tt := range tests {
+		archives, err := c.Search(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("Search(%+v) failed: %v", tt.query, err)
+		}
+		var ids []int
+		for _, a := range archives {
+			ids = append(ids, a.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("Search(%+v) = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	got, err := c.Get(ctx, 2)
+	if err != nil || got.Name != "Minutes" {
+		t.Errorf("Get(2) = %+v, %v, want Minutes", got, err)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.URL+"/locara", testToken)
+	ctx := context.Background()
+	content := "0123456789abcdefghij"
+	archive := upload(t, c, content, "Digits", "2024-01-01", "other")
+
+	var buf bytes.Buffer
+	n, err := c.Download(ctx, archive.ID, &buf, 10, nil)
+	if err != nil || n != 10 || buf.String() != content[10:] {
+		t.Errorf("Download() from 10 = %d %q, %v, want %q", n, buf.String(), err, content[10:])
+	}
+
+	path := filepath.Join(t.TempDir(), "digits.txt")
+	tests := []struct {
+		name    string
+		partial string
+		// wantFrom is where progress should start, i.e. how much is reused.
+		wantFrom int64
+	}{
+		{"fresh", "", 0},
+		{"interrupted", content[:7], 7},
+		{"complete", content, int64(len(content))},
+		{"stale", "unrelated content that is too long", 0},
+	}
+	for _, tt := range tests {
+		// A file already at path is never resumed from.
+		if err := os.WriteFile(path, []byte("0123"), 0o644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		if err := os.WriteFile(path+".part", []byte(tt.partial), 0o644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+
+		from := int64(-1)
+		_, err := c.DownloadFile(ctx, archive.ID, path, func(done, total int64) {
+			if from < 0 {
+				from = done
+			}
+			if total != int64(len(content)) {
+				t.Errorf("%s: progress total = %d, want %d", tt.name, total, len(content))
+			}
+		})
+		if err != nil {
+			t.Fatalf("%s: DownloadFile() failed: %v", tt.name, err)
+		}
+		if from != tt.wantFrom {
+			t.Errorf("%s: download started at %d, want %d", tt.name, from, tt.wantFrom)
+		}
+		if data, _ := os.ReadFile(path); string(data) != content {
+			t.Errorf("%s: file = %q, want %q", tt.name, data, content)
+		}
+		if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+			t.Errorf("%s: partial file left behind: %v", tt.name, err)
+		}
+	}
+
+	// A corrupt partial download fails the checksum and is discarded.
+	os.Remove(path)
+	if err := os.WriteFile(path+".part", []byte("xxxxxxx"), 0o644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := c.DownloadFile(ctx, archive.ID, path, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("DownloadFile() of corrupt partial file error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("corrupt download was saved: %v", err)
+	}
+	if _, err := c.DownloadFile(ctx, archive.ID, path, nil); err != nil {
+		t.Errorf("DownloadFile() after discarding corrupt partial file failed: %v", err)
+	}
+}
+
+func TestDeleteAndErrors(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.URL+"/locara", testToken)
+	ctx := context.Background()
+	archive := upload(t, c, "data", "Doomed", "2024-01-01", "other")
+
+	_, err := New(server.URL+"/locara", "wrong").Upload(ctx, Upload{
+		FileName: "x.txt",
+		Content:  strings.NewReader("x"),
+		Size:     1,
+		Name:     "X",
+		DatedOn:  "2024-01-01",
+		Type:     "other",
+		Author:   "Author",
+	})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "invalid_auth" {
+		t.Errorf("Upload() with wrong token = %v, want 401 invalid_auth", err)
+	}
+
+	if err := c.Delete(ctx, archive.ID); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := c.Get(ctx, archive.ID); !IsNotFound(err) {
+		t.Errorf("Get() after Delete() = %v, want not found", err)
+	}
+	if err := c.Delete(ctx, archive.ID); !IsNotFound(err) {
+		t.Errorf("second Delete() = %v, want not found", err)
+	}
+	if _, err := c.Download(ctx, archive.ID, io.Discard, 0, nil); !IsNotFound(err) {
+		t.Errorf("Download() after Delete() = %v, want not found", err)
+	}
+}
@@ -0,0 +1,8 @@
+// Package static embeds the stylesheets, scripts and images served under
+// /static/ so the binary runs without the source tree.
+package static
+
+import "embed"
+
+//go:embed css js img
+var FS embed.FS